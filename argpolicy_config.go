@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// configArgPolicy is an argPolicy declared under arg_policies in security.yaml.
+// On its own it is deny-by-default like the built-ins: every flag must be
+// listed, as a long flag or as short letters, and the subcommand, if the spec
+// names any, must be one of them. With extend it wraps the built-in policy of
+// the same executable instead: the flags it lists are accepted as well, and
+// its denied flags and subcommands narrow what the built-in allows.
+type configArgPolicy struct {
+	exe         string
+	short       byteSet
+	argTaking   byteSet
+	long        stringSet
+	subcommands stringSet
+	denied      map[string]string
+	base        argPolicy
+	paths       *pathRules
+	err         error
+}
+
+func (p *configArgPolicy) name() string { return p.exe }
+
+func (p *configArgPolicy) check(argv []string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	// rest is what the built-in still has to judge: argv minus the flags this
+	// policy accepts itself. A subcommand error is reported after the
+	// built-in's verdict, which knows the values of its own flags and so
+	// names the real culprit in "git -c x=y status".
+	var rest []string
+	if p.base != nil {
+		rest = []string{argv[0]}
+	}
+	var subcommandErr error
+	seenSubcommand := len(p.subcommands) == 0
+	for i := 1; i < len(argv); i++ {
+		a := argv[i]
+		if !isFlagToken(a) {
+			if !seenSubcommand {
+				if !p.subcommands.has(a) && subcommandErr == nil {
+					subcommandErr = fmt.Errorf("%s: subcommand %q is not allowed in secure mode", p.exe, a)
+				}
+				seenSubcommand = true
+			}
+			rest = append(rest, a)
+			continue
+		}
+		if err := p.checkDenied(a); err != nil {
+			return err
+		}
+		if ok, valueFollows := p.allows(a); ok {
+			// The next word is the flag's value, not a flag or subcommand.
+			if valueFollows {
+				i++
+			}
+			continue
+		}
+		if p.base == nil {
+			return fmt.Errorf("%s: %q is not allowed in secure mode", p.exe, a)
+		}
+		rest = append(rest, a)
+	}
+	if p.base != nil {
+		if err := p.base.check(rest); err != nil {
+			return err
+		}
+	}
+	return subcommandErr
+}
+
+// checkDenied rejects a flag token listed under denied_flags, whole ("--output",
+// "-exec") or as one letter of a short cluster ("-o" in "-nro").
+func (p *configArgPolicy) checkDenied(tok string) error {
+	if msg, ok := p.denied[tok]; ok {
+		return p.deny(tok, msg)
+	}
+	if msg, ok := p.denied[longFlagName(tok)]; ok {
+		return p.deny(tok, msg)
+	}
+	if strings.HasPrefix(tok, "--") || p.long.has(tok) {
+		return nil
+	}
+	for i := 1; i < len(tok); i++ {
+		if msg, ok := p.denied["-"+tok[i:i+1]]; ok {
+			return p.deny(tok, msg)
+		}
+		if p.argTaking.has(tok[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (p *configArgPolicy) deny(tok, msg string) error {
+	if msg == "" {
+		return fmt.Errorf("%s: %q is not allowed in secure mode", p.exe, tok)
+	}
+	return fmt.Errorf("%s: %q %s and is not allowed in secure mode", p.exe, tok, msg)
+}
+
+// allows reports whether the policy accepts flag token tok, and whether its
+// value is the next word: a short cluster ending in an arg-taking letter.
+func (p *configArgPolicy) allows(tok string) (ok, valueFollows bool) {
+	if p.long.has(tok) || p.long.has(longFlagName(tok)) {
+		return true, false
+	}
+	if strings.HasPrefix(tok, "--") {
+		return false, false
+	}
+	letters := tok[1:]
+	for i := 0; i < len(letters); i++ {
+		c := letters[i]
+		if !p.short.has(c) {
+			return false, false
+		}
+		if p.argTaking.has(c) {
+			return true, i == len(letters)-1
+		}
+	}
+	return true, false
+}
+
+// newConfiguredPolicySet returns the built-in policies with the arg_policies
+// entries applied on top: an entry replaces the built-in of the same name, or
+// wraps it when extend is set. An entry validateConfig would reject is still
+// registered, as a policy that rejects every use of the executable, so a bad
+// spec fails closed rather than leaving the executable ungoverned.
+func newConfiguredPolicySet(specs map[string]ArgPolicySpec) *policySet {
+	set := newDefaultPolicySet()
+	for exe, spec := range specs {
+		set.byName[exe] = compileArgPolicy(exe, spec, set.byName[exe])
+	}
+	return set
+}
+
+// compileArgPolicy builds the policy spec declares for exe. builtin is the
+// built-in policy for exe, if any, which an extending spec wraps.
+func compileArgPolicy(exe string, spec ArgPolicySpec, builtin argPolicy) *configArgPolicy {
+	p := &configArgPolicy{
+		exe:         exe,
+		short:       newByteSet(spec.ShortFlags + spec.ArgShortFlags),
+		argTaking:   newByteSet(spec.ArgShortFlags),
+		long:        newStringSet(spec.LongFlags...),
+		subcommands: newStringSet(spec.Subcommands...),
+		denied:      spec.DeniedFlags,
+	}
+	if spec.Extend {
+		p.base = builtin
+	}
+	if !spec.Extend || len(spec.PathFlags) > 0 || spec.Operands != "" {
+		p.paths = compilePathRules(p.argTaking, spec)
+	}
+	if err := validateArgPolicy(exe, spec, builtin != nil); err != nil {
+		p.err = fmt.Errorf("%s: policy is invalid, so every use is rejected: %w", exe, err)
+	}
+	// A denied letter stays denied even if it is also listed as allowed.
+	for flag := range spec.DeniedFlags {
+		if len(flag) == 2 && flag[0] == '-' {
+			delete(p.short, flag[1])
+		}
+	}
+	return p
+}
+
+// validateArgPolicy checks one arg_policies entry. hasBuiltin reports whether
+// exe has a built-in policy, which extend requires.
+func validateArgPolicy(exe string, spec ArgPolicySpec, hasBuiltin bool) error {
+	if exe == "" || strings.Contains(exe, "/") {
+		return fmt.Errorf("invalid executable name %q (use its basename)", exe)
+	}
+	// Governing an interpreter lifts its hard deny, yet no flag policy can
+	// stop it running the script or code it is handed.
+	if isInterpreterExecutable(exe) && !hasBuiltin {
+		return fmt.Errorf("%s is an interpreter and cannot be governed by a policy", exe)
+	}
+	if spec.Extend && !hasBuiltin {
+		return fmt.Errorf("extend requires a built-in policy and there is none for %s", exe)
+	}
+	for _, c := range []byte(spec.ShortFlags + spec.ArgShortFlags) {
+		if c <= ' ' || c >= 0x7f || c == '-' || c == '=' {
+			return fmt.Errorf("invalid short flag letter %q", c)
+		}
+	}
+	for _, flag := range spec.LongFlags {
+		if !isFlagToken(flag) || strings.Contains(flag, "=") {
+			return fmt.Errorf("long flag %q must start with - and contain no =", flag)
+		}
+	}
+	for flag := range spec.DeniedFlags {
+		if !isFlagToken(flag) || strings.Contains(flag, "=") {
+			return fmt.Errorf("denied flag %q must start with - and contain no =", flag)
+		}
+	}
+	for flag, access := range spec.PathFlags {
+		if !isFlagToken(flag) || strings.Contains(flag, "=") {
+			return fmt.Errorf("path flag %q must start with - and contain no =", flag)
+		}
+		if _, err := parsePathAccess(access); err != nil {
+			return fmt.Errorf("path flag %s: %w", flag, err)
+		}
+	}
+	if spec.Operands != "" {
+		if _, err := parsePathAccess(spec.Operands); err != nil {
+			return fmt.Errorf("operands: %w", err)
+		}
+	}
+	for _, sub := range spec.Subcommands {
+		if sub == "" || strings.HasPrefix(sub, "-") {
+			return fmt.Errorf("invalid subcommand %q", sub)
+		}
+	}
+	return nil
+}
+
+// pathArgs classifies argv for confine_paths with the entry's path_flags and
+// operands. An extending entry that declares neither defers to the built-in.
+func (p *configArgPolicy) pathArgs(argv []string) []pathArg {
+	if p.paths == nil {
+		if c, ok := p.base.(pathClassifier); ok {
+			return c.pathArgs(argv)
+		}
+		return fallbackPathRules.pathArgs(argv)
+	}
+	return p.paths.pathArgs(argv)
+}
+
+// compilePathRules builds the path rules of an arg_policies entry. Accesses
+// validateArgPolicy would reject count as read.
+func compilePathRules(argTaking byteSet, spec ArgPolicySpec) *pathRules {
+	valueFlags := make(map[string]pathAccess, len(spec.PathFlags))
+	for flag, name := range spec.PathFlags {
+		access, err := parsePathAccess(name)
+		if err != nil {
+			access = accessRead
+		}
+		valueFlags[flag] = access
+	}
+	r := newPathRules(argTaking, valueFlags)
+	r.values = accessRead
+	if spec.Operands != "" {
+		if access, err := parsePathAccess(spec.Operands); err == nil {
+			r.operands = access
+		}
+	}
+	return r
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfiguredPolicySet_check(t *testing.T) {
+	set := newConfiguredPolicySet(map[string]ArgPolicySpec{
+		"jq": {
+			ShortFlags:    "rcnS",
+			ArgShortFlags: "f",
+			LongFlags:     []string{"--raw-output", "--arg", "--tab"},
+			DeniedFlags: map[string]string{
+				"-f":          "reads a filter from an arbitrary file",
+				"--from-file": "reads a filter from an arbitrary file",
+			},
+		},
+		"kubectl": {
+			ArgShortFlags: "no",
+			LongFlags:     []string{"--namespace"},
+			Subcommands:   []string{"get", "describe"},
+		},
+		"sort": {
+			Extend:      true,
+			LongFlags:   []string{"--files0-from"},
+			DeniedFlags: map[string]string{"-m": "merges already sorted files"},
+		},
+		"git": {
+			Extend:      true,
+			Subcommands: []string{"status", "log"},
+		},
+		"tar": {
+			ShortFlags: "tvf",
+		},
+		"bash": {
+			LongFlags: []string{"--version"},
+		},
+	})
+
+	tests := []struct {
+		name          string
+		argv          []string
+		expectError   bool
+		errorContains string
+	}{
+		{
+			name: "listed long and short flags allowed",
+			argv: []string{"jq", "-rc", "--raw-output", "--arg=x", ".", "file.json"},
+		},
+		{
+			name:          "unlisted flag denied by default",
+			argv:          []string{"jq", "--seq", "."},
+			expectError:   true,
+			errorContains: `jq: "--seq" is not allowed in secure mode`,
+		},
+		{
+			name:          "unlisted letter in a cluster denied",
+			argv:          []string{"jq", "-rx", "."},
+			expectError:   true,
+			errorContains: `jq: "-rx" is not allowed`,
+		},
+		{
+			name:          "denied long flag carries its reason",
+			argv:          []string{"jq", "--from-file=/etc/shadow"},
+			expectError:   true,
+			errorContains: `jq: "--from-file=/etc/shadow" reads a filter from an arbitrary file and is not allowed`,
+		},
+		{
+			name:          "denied letter wins over the allowlist",
+			argv:          []string{"jq", "-rf", "/etc/shadow"},
+			expectError:   true,
+			errorContains: "reads a filter from an arbitrary file",
+		},
+		{
+			name: "subcommand allowed after an arg-taking flag and its value",
+			argv: []string{"kubectl", "-n", "prod", "get", "pods", "-oyaml"},
+		},
+		{
+			name:          "subcommand outside the list denied",
+			argv:          []string{"kubectl", "--namespace=prod", "delete", "pod", "x"},
+			expectError:   true,
+			errorContains: `kubectl: subcommand "delete" is not allowed`,
+		},
+		{
+			name: "extension accepts its own flags",
+			argv: []string{"sort", "-n", "--files0-from=list", "-k2"},
+		},
+		{
+			name:          "extension keeps the built-in denials",
+			argv:          []string{"sort", "-o", "out.txt"},
+			expectError:   true,
+			errorContains: "writes to an arbitrary file",
+		},
+		{
+			name:          "extension adds denials",
+			argv:          []string{"sort", "-nm", "a", "b"},
+			expectError:   true,
+			errorContains: `sort: "-nm" merges already sorted files`,
+		},
+		{
+			name: "extension narrows built-in subcommands",
+			argv: []string{"git", "--no-pager", "log", "--oneline"},
+		},
+		{
+			name:          "extension rejects a subcommand the built-in allows",
+			argv:          []string{"git", "diff"},
+			expectError:   true,
+			errorContains: `git: subcommand "diff" is not allowed`,
+		},
+		{
+			name:          "extension keeps built-in global flag checks",
+			argv:          []string{"git", "-c", "alias.x=!id", "status"},
+			expectError:   true,
+			errorContains: "config injection",
+		},
+		{
+			name: "replacement drops the built-in",
+			argv: []string{"tar", "-tvf", "a.tar"},
+		},
+		{
+			name:          "replacement is deny-by-default",
+			argv:          []string{"tar", "-xf", "a.tar"},
+			expectError:   true,
+			errorContains: `tar: "-xf" is not allowed`,
+		},
+		{
+			name:          "invalid entry rejects every use",
+			argv:          []string{"bash", "--version"},
+			expectError:   true,
+			errorContains: "bash: policy is invalid, so every use is rejected: bash is an interpreter",
+		},
+		{
+			name:          "untouched built-ins still apply",
+			argv:          []string{"find", ".", "-delete"},
+			expectError:   true,
+			errorContains: "-delete",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := set.check(tt.argv)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
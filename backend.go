@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Execution backends: where a validated command runs.
+const (
+	backendLocal   = "local"
+	backendSandbox = "sandbox"
+	backendSSH     = "ssh"
+)
+
+// Executor runs commands the handler has already validated, on a single
+// backend or, through a backendRouter, on the one assigned to their
+// executables.
+type Executor interface {
+	// executeValidated runs plan, the plan validateCommand judged command
+	// as, without parsing or expanding anything again. A nil plan is only
+	// valid in legacy shell mode, which runs command through bash.
+	executeValidated(ctx context.Context, command string, plan *execPlan, useBase64 bool) (*ExecutionResult, error)
+	// executeArgv runs a structured argv as a single command, with no
+	// parsing and no shell.
+	executeArgv(ctx context.Context, argv []string, useBase64 bool) (*ExecutionResult, error)
+	// in returns a copy that runs commands in dir, an absolute directory
+	// inside the working directory, as resolved by resolveWorkDir.
+	in(dir string) Executor
+	// withTimeout returns a copy whose executions run under the requested
+	// timeout, capped at max_timeout.
+	withTimeout(timeout time.Duration) Executor
+	// pinned returns the path an executable, by argv[0], runs from, or ""
+	// when exec looks it up, and the error that refuses it instead.
+	pinned(executable string) (string, error)
+}
+
+// defaultBackend returns the backend of executables not assigned one of
+// their own.
+func (c SecurityConfig) defaultBackend() string {
+	if c.Backends.Default != "" {
+		return c.Backends.Default
+	}
+	if c.Sandbox.Enabled {
+		return backendSandbox
+	}
+	return backendLocal
+}
+
+// backendFor returns the backend an executable, by argv[0], runs on.
+func (c SecurityConfig) backendFor(argv0 string) string {
+	if name, ok := c.Backends.Executables[filepath.Base(argv0)]; ok {
+		return name
+	}
+	return c.defaultBackend()
+}
+
+// usesBackend reports whether any executable runs on backend name.
+func (c SecurityConfig) usesBackend(name string) bool {
+	return c.defaultBackend() == name || slices.Contains(slices.Collect(maps.Values(c.Backends.Executables)), name)
+}
+
+// newExecutor builds the executor of every backend cfg uses: the one itself
+// when there is a single one, otherwise a backendRouter over all of them.
+func newExecutor(cfg SecurityConfig, logger zerolog.Logger) Executor {
+	backends := map[string]Executor{}
+	if cfg.usesBackend(backendLocal) || cfg.usesBackend(backendSandbox) {
+		// Both share one executor, and with it the pinned executables and
+		// the cgroup subtree; they differ only in entering the sandbox.
+		local := cfg
+		local.Sandbox.Enabled = cfg.usesBackend(backendSandbox)
+		e := newCommandExecutor(local, logger)
+		if cfg.usesBackend(backendLocal) {
+			backends[backendLocal] = e.sandboxed(false)
+		}
+		if cfg.usesBackend(backendSandbox) {
+			backends[backendSandbox] = e.sandboxed(true)
+		}
+	}
+	if cfg.usesBackend(backendSSH) {
+		backends[backendSSH] = newSSHExecutor(cfg, logger)
+	}
+	if backend, ok := backends[cfg.defaultBackend()]; ok && len(backends) == 1 {
+		return backend
+	}
+	return &backendRouter{
+		config:   cfg,
+		backends: backends,
+	}
+}
+
+// backendRouter runs every command on the backend its executables are
+// assigned. A command is run by one backend as a whole, so its executables
+// must all be assigned the same one. In legacy shell mode, where what a
+// command runs is only known to the shell, every command runs on the default
+// backend.
+type backendRouter struct {
+	config   SecurityConfig
+	backends map[string]Executor
+}
+
+func (r *backendRouter) executeValidated(ctx context.Context, command string, plan *execPlan, useBase64 bool) (*ExecutionResult, error) {
+	backend, err := r.route(plan)
+	if err != nil {
+		return nil, err
+	}
+	return backend.executeValidated(ctx, command, plan, useBase64)
+}
+
+func (r *backendRouter) executeArgv(ctx context.Context, argv []string, useBase64 bool) (*ExecutionResult, error) {
+	backend, err := r.pick(argv[:min(len(argv), 1)])
+	if err != nil {
+		return nil, err
+	}
+	return backend.executeArgv(ctx, argv, useBase64)
+}
+
+func (r *backendRouter) in(dir string) Executor {
+	c := *r
+	c.backends = make(map[string]Executor, len(r.backends))
+	for name, backend := range r.backends {
+		c.backends[name] = backend.in(dir)
+	}
+	return &c
+}
+
+func (r *backendRouter) withTimeout(timeout time.Duration) Executor {
+	c := *r
+	c.backends = make(map[string]Executor, len(r.backends))
+	for name, backend := range r.backends {
+		c.backends[name] = backend.withTimeout(timeout)
+	}
+	return &c
+}
+
+func (r *backendRouter) pinned(executable string) (string, error) {
+	backend, err := r.pick([]string{executable})
+	if err != nil {
+		return "", err
+	}
+	return backend.pinned(executable)
+}
+
+// route returns the backend to run plan on, from its executables. Without a
+// plan, in legacy shell mode, that is the default backend.
+func (r *backendRouter) route(plan *execPlan) (Executor, error) {
+	if plan == nil {
+		return r.backend(r.config.defaultBackend())
+	}
+	var executables []string
+	for _, cmd := range plan.commands() {
+		executables = append(executables, cmd.Argv[0])
+	}
+	return r.pick(executables)
+}
+
+// pick returns the backend executables are all assigned, or the default one
+// when there are none.
+func (r *backendRouter) pick(executables []string) (Executor, error) {
+	name := r.config.defaultBackend()
+	for i, exe := range executables {
+		backend := r.config.backendFor(exe)
+		if i > 0 && backend != name {
+			return nil, fmt.Errorf("'%s' runs on the %s backend and '%s' on the %s backend; they cannot run in one command",
+				executables[0], name, exe, backend)
+		}
+		name = backend
+	}
+	return r.backend(name)
+}
+
+func (r *backendRouter) backend(name string) (Executor, error) {
+	backend, ok := r.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return backend, nil
+}
+
+// validateBackends checks the backends block of security.yaml.
+func validateBackends(c SecurityConfig) error {
+	known := []string{backendLocal, backendSandbox, backendSSH}
+	if c.Backends.Default != "" && !slices.Contains(known, c.Backends.Default) {
+		return fmt.Errorf("backends.default must be local, sandbox or ssh, not %q", c.Backends.Default)
+	}
+	if c.Sandbox.Enabled && c.Backends.Default != "" && c.Backends.Default != backendSandbox {
+		return fmt.Errorf("sandbox.enabled makes sandbox the default backend, which backends.default sets to %s", c.Backends.Default)
+	}
+	for _, exe := range slices.Sorted(maps.Keys(c.Backends.Executables)) {
+		if name := c.Backends.Executables[exe]; !slices.Contains(known, name) {
+			return fmt.Errorf("backends.executables: %s must run on local, sandbox or ssh, not %q", exe, name)
+		}
+	}
+	if c.usesBackend(backendSSH) {
+		return validateSSHBackend(c.Backends.SSH)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingExecutor is a backend that only records what it was asked to run.
+type recordingExecutor struct {
+	name    string
+	dir     string
+	timeout time.Duration
+	ran     *[]string
+}
+
+func (r *recordingExecutor) record(command string) (*ExecutionResult, error) {
+	*r.ran = append(*r.ran, r.name+": "+command)
+	return &ExecutionResult{Command: command, SecurityInfo: &SecurityInfo{Backend: r.name, WorkingDir: r.dir, Timeout: r.timeout.String()}}, nil
+}
+
+func (r *recordingExecutor) executeValidated(ctx context.Context, command string, _ *execPlan, useBase64 bool) (*ExecutionResult, error) {
+	return r.record(command)
+}
+
+func (r *recordingExecutor) executeArgv(ctx context.Context, argv []string, useBase64 bool) (*ExecutionResult, error) {
+	return r.record(quoteArgv(argv))
+}
+
+func (r *recordingExecutor) in(dir string) Executor {
+	c := *r
+	c.dir = dir
+	return &c
+}
+
+func (r *recordingExecutor) withTimeout(timeout time.Duration) Executor {
+	c := *r
+	c.timeout = timeout
+	return &c
+}
+
+func (r *recordingExecutor) pinned(string) (string, error) {
+	return "", nil
+}
+
+func TestValidateBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SecurityConfig
+		wantErr string
+	}{
+		{name: "unset"},
+		{
+			name: "overrides",
+			config: SecurityConfig{Backends: BackendsConfig{
+				Default:     backendLocal,
+				Executables: map[string]string{"make": backendSandbox, "git": backendSSH},
+				SSH:         SSHBackendConfig{Host: "build.example.com"},
+			}},
+		},
+		{
+			name:    "unknown default",
+			config:  SecurityConfig{Backends: BackendsConfig{Default: "docker"}},
+			wantErr: `backends.default must be local, sandbox or ssh, not "docker"`,
+		},
+		{
+			name:    "unknown override",
+			config:  SecurityConfig{Backends: BackendsConfig{Executables: map[string]string{"make": "vm"}}},
+			wantErr: `backends.executables: make must run on local, sandbox or ssh, not "vm"`,
+		},
+		{
+			name: "sandbox enabled with another default",
+			config: SecurityConfig{
+				Sandbox:  SandboxConfig{Enabled: true},
+				Backends: BackendsConfig{Default: backendLocal},
+			},
+			wantErr: "sandbox.enabled makes sandbox the default backend, which backends.default sets to local",
+		},
+		{
+			name:    "ssh without a host",
+			config:  SecurityConfig{Backends: BackendsConfig{Executables: map[string]string{"git": backendSSH}}},
+			wantErr: "the ssh backend requires backends.ssh.host",
+		},
+		{
+			name:   "ssh host unused",
+			config: SecurityConfig{Backends: BackendsConfig{SSH: SSHBackendConfig{Port: -1}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBackends(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSecurityConfig_backendFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   SecurityConfig
+		argv0    string
+		want     string
+		wantUses []string
+	}{
+		{name: "local by default", argv0: "ls", want: backendLocal, wantUses: []string{backendLocal}},
+		{
+			name:     "sandbox.enabled",
+			config:   SecurityConfig{Sandbox: SandboxConfig{Enabled: true}},
+			argv0:    "ls",
+			want:     backendSandbox,
+			wantUses: []string{backendSandbox},
+		},
+		{
+			name:     "override by basename",
+			config:   SecurityConfig{Backends: BackendsConfig{Executables: map[string]string{"git": backendSSH}}},
+			argv0:    "/usr/bin/git",
+			want:     backendSSH,
+			wantUses: []string{backendLocal, backendSSH},
+		},
+		{
+			name: "default without the override",
+			config: SecurityConfig{Backends: BackendsConfig{
+				Default:     backendSSH,
+				Executables: map[string]string{"make": backendSandbox},
+			}},
+			argv0:    "ls",
+			want:     backendSSH,
+			wantUses: []string{backendSandbox, backendSSH},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.config.backendFor(tt.argv0))
+			var uses []string
+			for _, name := range []string{backendLocal, backendSandbox, backendSSH} {
+				if tt.config.usesBackend(name) {
+					uses = append(uses, name)
+				}
+			}
+			assert.Equal(t, tt.wantUses, uses)
+		})
+	}
+}
+
+func TestBackendRouter(t *testing.T) {
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"ls", "git", "grep"},
+		Backends: BackendsConfig{
+			Executables: map[string]string{"git": backendSSH},
+		},
+	}
+	var ran []string
+	newRouter := func(config SecurityConfig) Executor {
+		return &backendRouter{
+			config: config,
+			backends: map[string]Executor{
+				backendLocal: &recordingExecutor{name: backendLocal, ran: &ran},
+				backendSSH:   &recordingExecutor{name: backendSSH, ran: &ran},
+			},
+		}
+	}
+	router := newRouter(config)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		command string
+		argv    []string
+		shell   bool
+		want    string
+		wantErr string
+	}{
+		{name: "default backend", command: "ls -l", want: "local: ls -l"},
+		{name: "override", command: "git log | git shortlog", want: "ssh: git log | git shortlog"},
+		{name: "argv override", argv: []string{"git", "status"}, want: "ssh: git status"},
+		{name: "argv default", argv: []string{"ls"}, want: "local: ls"},
+		{name: "shell mode, without a plan, runs everything on the default", command: "git log", shell: true, want: "local: git log"},
+		{
+			name:    "executables on different backends",
+			command: "git log && ls",
+			wantErr: "'git' runs on the ssh backend and 'ls' on the local backend; they cannot run in one command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			executor := router
+			if tt.shell {
+				shell := config
+				shell.UseShellExecution = true
+				executor = newRouter(shell)
+			}
+			var err error
+			if tt.argv != nil {
+				_, err = executor.executeArgv(ctx, tt.argv, false)
+			} else {
+				var plan *execPlan
+				if !tt.shell {
+					plan = newCommandUnfurler(config).unfurl(tt.command).Plan
+				}
+				_, err = executor.executeValidated(ctx, tt.command, plan, false)
+			}
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Empty(t, ran)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.want}, ran)
+		})
+	}
+
+	t.Run("the validated plan picks the backend", func(t *testing.T) {
+		ran = nil
+		// The command string is only reported; it is never parsed again.
+		plan := newCommandUnfurler(config).unfurl("git status").Plan
+		_, err := router.executeValidated(ctx, "ls", plan, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ssh: ls"}, ran)
+	})
+
+	t.Run("directory and timeout reach every backend", func(t *testing.T) {
+		scoped := router.in("/work/sub").withTimeout(time.Second)
+		for _, command := range []string{"ls", "git status"} {
+			plan := newCommandUnfurler(config).unfurl(command).Plan
+			result, err := scoped.executeValidated(ctx, command, plan, false)
+			require.NoError(t, err)
+			assert.Equal(t, "/work/sub", result.SecurityInfo.WorkingDir)
+			assert.Equal(t, "1s", result.SecurityInfo.Timeout)
+		}
+	})
+}
+
+func TestNewExecutor(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	t.Run("a single backend runs commands itself", func(t *testing.T) {
+		executor := newExecutor(SecurityConfig{Enabled: true, AllowedExecutables: []string{"echo"}}, logger)
+		require.IsType(t, &CommandExecutor{}, executor)
+		result, err := execute(ctx, executor, "echo hi", false)
+		require.NoError(t, err)
+		assert.Equal(t, "hi", result.Stdout)
+		assert.Equal(t, backendLocal, result.SecurityInfo.Backend)
+	})
+
+	t.Run("overrides route between backends", func(t *testing.T) {
+		executor := newExecutor(SecurityConfig{
+			Enabled:            true,
+			AllowedExecutables: []string{"echo", "git"},
+			WorkingDirectory:   t.TempDir(),
+			Backends: BackendsConfig{
+				Executables: map[string]string{"git": backendSandbox},
+			},
+		}, logger)
+		router, ok := executor.(*backendRouter)
+		require.True(t, ok)
+		assert.Len(t, router.backends, 2)
+		assert.False(t, router.backends[backendLocal].(*CommandExecutor).config.Sandbox.Enabled)
+		assert.True(t, router.backends[backendSandbox].(*CommandExecutor).config.Sandbox.Enabled)
+
+		result, err := execute(ctx, executor, "echo hi", false)
+		require.NoError(t, err)
+		assert.Equal(t, backendLocal, result.SecurityInfo.Backend)
+		assert.False(t, result.SecurityInfo.Sandboxed)
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// defaultMaxArgvLength caps a simple command's argv, after brace and glob
+// expansion, when max_argv_length is unset.
+const defaultMaxArgvLength = 1024
+
+// expandBraces performs bash brace expansion on word and returns the words it
+// expands to, in order: a{b,c}d gives abd and acd, {1..3} gives 1 2 3. A word
+// without braces is returned as is. Expansion is purely lexical, so the result
+// words still go through literal checks and glob expansion like any other.
+// The number of words is computed before anything is built, and ok is false
+// if it exceeds limit, so {1..999999999} costs nothing.
+func expandBraces(word *syntax.Word, limit int) (words []*syntax.Word, ok bool) {
+	quoteEscapes(word)
+	if !syntax.SplitBraces(word) {
+		return []*syntax.Word{word}, true
+	}
+	if braceCount(word.Parts) > limit {
+		return nil, false
+	}
+	alts := braceAlternatives(word.Parts)
+	words = make([]*syntax.Word, len(alts))
+	for i, parts := range alts {
+		words[i] = &syntax.Word{Parts: parts}
+	}
+	return words, true
+}
+
+// quoteEscapes rewrites every backslash-escaped character of the word's
+// unquoted literals as a single-quoted part. SplitBraces does not know about
+// escapes, so without this \{a,b\} would expand; quoted, the character is
+// literal to both brace and glob expansion, exactly what the backslash meant.
+func quoteEscapes(word *syntax.Word) {
+	var parts []syntax.WordPart
+	for _, part := range word.Parts {
+		lit, ok := part.(*syntax.Lit)
+		if !ok || !strings.Contains(lit.Value, `\`) {
+			parts = append(parts, part)
+			continue
+		}
+		v, start := lit.Value, 0
+		for i := 0; i < len(v)-1; i++ {
+			if v[i] != '\\' {
+				continue
+			}
+			if start < i {
+				parts = append(parts, &syntax.Lit{Value: v[start:i]})
+			}
+			_, size := utf8.DecodeRuneInString(v[i+1:])
+			parts = append(parts, &syntax.SglQuoted{Value: v[i+1 : i+1+size]})
+			i += size
+			start = i + 1
+		}
+		if start < len(v) {
+			parts = append(parts, &syntax.Lit{Value: v[start:]})
+		}
+	}
+	word.Parts = parts
+}
+
+// braceCount returns how many words parts expand to. It saturates instead of
+// overflowing, which is enough to compare it against a limit.
+func braceCount(parts []syntax.WordPart) int {
+	const saturated = 1 << 30 // matches braceSequenceLen
+	total := 1
+	for _, part := range parts {
+		br, ok := part.(*syntax.BraceExp)
+		if !ok {
+			continue
+		}
+		n := 0
+		if br.Sequence {
+			n = braceSequenceLen(br)
+		} else {
+			for _, elem := range br.Elems {
+				n = min(n+braceCount(elem.Parts), saturated)
+			}
+		}
+		if n != 0 && total > saturated/n {
+			return saturated
+		}
+		total *= n
+	}
+	return total
+}
+
+// braceAlternatives expands parts into every combination of their brace
+// alternatives. Every returned slice is freshly allocated, so no two words
+// share a backing array.
+func braceAlternatives(parts []syntax.WordPart) [][]syntax.WordPart {
+	out := [][]syntax.WordPart{nil}
+	for _, part := range parts {
+		br, ok := part.(*syntax.BraceExp)
+		if !ok {
+			for i := range out {
+				out[i] = append(out[i], part)
+			}
+			continue
+		}
+
+		var alts [][]syntax.WordPart
+		if br.Sequence {
+			for _, v := range braceSequence(br) {
+				// Quoted, so a character sequence such as {Z..a} yields
+				// literal [ and \ rather than live glob syntax.
+				alts = append(alts, []syntax.WordPart{&syntax.SglQuoted{Value: v}})
+			}
+		} else {
+			for _, elem := range br.Elems {
+				alts = append(alts, braceAlternatives(elem.Parts)...)
+			}
+		}
+
+		next := make([][]syntax.WordPart, 0, len(out)*len(alts))
+		for _, prefix := range out {
+			for _, alt := range alts {
+				next = append(next, slices.Concat(prefix, alt))
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+// braceSeq is a parsed {from..to[..incr]} sequence. Numeric bounds are kept
+// in 64 bits on every platform, beyond int64 saturated.
+type braceSeq struct {
+	from, to, incr int64
+	chars          bool
+	width          int
+}
+
+// parseBraceSeq reads a sequence the way bash does: numeric bounds, optionally
+// zero-padded to a common width, or single-character bounds. The sign of the
+// increment is ignored; the direction comes from the bounds.
+func parseBraceSeq(br *syntax.BraceExp) braceSeq {
+	fromLit, toLit := br.Elems[0].Lit(), br.Elems[1].Lit()
+	seq := braceSeq{incr: 1}
+
+	from, err1 := parseBraceInt(fromLit)
+	to, err2 := parseBraceInt(toLit)
+	if err1 != nil || err2 != nil {
+		seq.chars = true
+		from, to = int64(fromLit[0]), int64(toLit[0])
+	} else if zeroPadded(fromLit) || zeroPadded(toLit) {
+		seq.width = max(len(fromLit), len(toLit))
+	}
+	seq.from, seq.to = from, to
+
+	if len(br.Elems) > 2 {
+		if n, err := parseBraceInt(br.Elems[2].Lit()); err == nil && n != 0 {
+			seq.incr = max(n, -n)
+		}
+	}
+	return seq
+}
+
+// parseBraceInt parses a numeric sequence element, saturating one too large
+// for int64 rather than taking it for a character.
+func parseBraceInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		err = nil
+	}
+	return n, err
+}
+
+// zeroPadded reports whether a numeric bound has a leading zero, which makes
+// bash pad every number in the sequence to the widest bound.
+func zeroPadded(s string) bool {
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	return len(s) > 1 && s[0] == '0'
+}
+
+// braceSequenceLen returns how many words a sequence yields without building
+// them. Bounds too large to enumerate anyway report saturated.
+func braceSequenceLen(br *syntax.BraceExp) int {
+	const bound = 1 << 40
+	seq := parseBraceSeq(br)
+	if max(seq.from, -seq.from) > bound || max(seq.to, -seq.to) > bound {
+		return 1 << 30
+	}
+	return int(min(max(seq.to-seq.from, seq.from-seq.to)/seq.incr+1, 1<<30))
+}
+
+func braceSequence(br *syntax.BraceExp) []string {
+	seq := parseBraceSeq(br)
+	step := seq.incr
+	if seq.from > seq.to {
+		step = -step
+	}
+
+	// Counted rather than compared against to, so that a step larger than
+	// the distance cannot overflow past it.
+	count := braceSequenceLen(br)
+	var out []string
+	for i, n := 0, seq.from; i < count; i, n = i+1, n+step {
+		switch {
+		case seq.chars:
+			out = append(out, string(rune(n)))
+		case seq.width > 0:
+			out = append(out, fmt.Sprintf("%0*d", seq.width, n))
+		default:
+			out = append(out, strconv.FormatInt(n, 10))
+		}
+	}
+	return out
+}
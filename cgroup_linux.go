@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuMaxPeriod is the cpu.max period, in microseconds, quotas are set against.
+const cpuMaxPeriod = 100000
+
+// cgroupManager gives every execution a cgroup v2 of its own, a child of a
+// delegated parent, with the memory, pids and cpu limits written to it.
+// Processes are created directly inside it (CLONE_INTO_CGROUP), so nothing a
+// command forks ever runs outside it.
+type cgroupManager struct {
+	parent string
+	limits ResourceLimits
+	seq    atomic.Uint64
+}
+
+// newCgroupManager prepares the parent cgroup: limits.CgroupParent or, when
+// unset, the server's own cgroup, which must be cgroup v2 and offer every
+// controller the limits need. The controllers are enabled for its children;
+// as cgroup v2 allows no processes in a cgroup that does that, the server
+// first moves itself into a leaf when the parent is its own cgroup. An error
+// means no usable delegated subtree is available.
+func newCgroupManager(limits ResourceLimits) (*cgroupManager, error) {
+	parent, own := limits.CgroupParent, false
+	if parent == "" {
+		var err error
+		if parent, err = ownCgroup(); err != nil {
+			return nil, err
+		}
+		own = true
+	}
+
+	data, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", parent, err)
+	}
+	available := strings.Fields(string(data))
+	controllers := limits.cgroupControllers()
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			return nil, fmt.Errorf("controller %s is not available in %s", c, parent)
+		}
+	}
+
+	enable := "+" + strings.Join(controllers, " +")
+	err = writeCgroupFile(parent, "cgroup.subtree_control", enable)
+	if err != nil && own && errors.Is(err, unix.EBUSY) {
+		leaf := filepath.Join(parent, "mcp-shell-server")
+		if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("create %s: %w", leaf, err)
+		}
+		if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return nil, fmt.Errorf("move the server into %s: %w", leaf, err)
+		}
+		err = writeCgroupFile(parent, "cgroup.subtree_control", enable)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("enable %s in %s: %w", strings.Join(controllers, ", "), parent, err)
+	}
+	return &cgroupManager{parent: parent, limits: limits}, nil
+}
+
+// ownCgroup returns the directory of the server's cgroup v2.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if path, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", errors.New("the server is not in a cgroup v2 hierarchy")
+}
+
+// execCgroup is the cgroup of one execution, held open so its processes can
+// be created in it.
+type execCgroup struct {
+	dir string
+	fd  int
+}
+
+// create makes the cgroup of a new execution and writes its limits. Swap is
+// disabled along with a memory limit, so the limit cannot be evaded by
+// swapping.
+func (m *cgroupManager) create() (*execCgroup, error) {
+	dir := filepath.Join(m.parent, fmt.Sprintf("mcp-shell-exec-%d-%d", os.Getpid(), m.seq.Add(1)))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	files := map[string]string{}
+	if m.limits.Memory > 0 {
+		files["memory.max"] = strconv.FormatInt(m.limits.Memory, 10)
+	}
+	if m.limits.Pids > 0 {
+		files["pids.max"] = strconv.FormatInt(m.limits.Pids, 10)
+	}
+	if m.limits.CPU > 0 {
+		quota := max(int64(m.limits.CPU*cpuMaxPeriod), 1000)
+		files["cpu.max"] = fmt.Sprintf("%d %d", quota, cpuMaxPeriod)
+	}
+	for name, value := range files {
+		if err := writeCgroupFile(dir, name, value); err != nil {
+			os.Remove(dir)
+			return nil, fmt.Errorf("set cgroup limit: %w", err)
+		}
+	}
+	if m.limits.Memory > 0 {
+		if err := writeCgroupFile(dir, "memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(dir)
+			return nil, fmt.Errorf("set cgroup limit: %w", err)
+		}
+	}
+
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	return &execCgroup{dir: dir, fd: fd}, nil
+}
+
+// apply makes processes started with attr begin inside the cgroup.
+func (c *execCgroup) apply(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = c.fd
+}
+
+// exceeded returns the cgroup limits the execution ran into: memory when the
+// OOM killer killed one of its processes, pids when a fork was refused.
+func (c *execCgroup) exceeded() []string {
+	var out []string
+	if cgroupEventCount(c.dir, "memory.events", "oom_kill") > 0 {
+		out = append(out, "memory")
+	}
+	if cgroupEventCount(c.dir, "pids.events", "max") > 0 {
+		out = append(out, "pids")
+	}
+	return out
+}
+
+// remove kills whatever is left in the cgroup and deletes it.
+func (c *execCgroup) remove() {
+	if c == nil {
+		return
+	}
+	unix.Close(c.fd)
+	_ = writeCgroupFile(c.dir, "cgroup.kill", "1")
+	// rmdir fails with EBUSY until the killed processes are gone.
+	for range 50 {
+		if err := os.Remove(c.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cgroupEventCount reads one counter of a cgroup's flat-keyed events file.
+func cgroupEventCount(dir, file, key string) int64 {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	for line := range bytes.Lines(data) {
+		k, v, ok := strings.Cut(strings.TrimSpace(string(line)), " ")
+		if ok && k == key {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCgroup lays out the files of a cgroup v2 directory offering controllers
+// in a temporary directory, where they are plain files.
+func fakeCgroup(t *testing.T, controllers string) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte(controllers+"\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), nil, 0o644))
+	return dir
+}
+
+func TestNewCgroupManager(t *testing.T) {
+	t.Run("enables the controllers the limits need", func(t *testing.T) {
+		parent := fakeCgroup(t, "cpuset cpu io memory pids")
+		m, err := newCgroupManager(ResourceLimits{Memory: 1 << 20, Pids: 16, CgroupParent: parent})
+		require.NoError(t, err)
+		assert.Equal(t, parent, m.parent)
+
+		data, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+		require.NoError(t, err)
+		assert.Equal(t, "+memory +pids", string(data))
+	})
+
+	t.Run("not cgroup v2", func(t *testing.T) {
+		_, err := newCgroupManager(ResourceLimits{Pids: 16, CgroupParent: t.TempDir()})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not a cgroup v2 directory")
+	})
+
+	t.Run("missing controller", func(t *testing.T) {
+		parent := fakeCgroup(t, "cpu pids")
+		_, err := newCgroupManager(ResourceLimits{Memory: 1 << 20, CgroupParent: parent})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "controller memory is not available")
+	})
+}
+
+func TestCgroupManager_create(t *testing.T) {
+	parent := fakeCgroup(t, "cpu memory pids")
+	m, err := newCgroupManager(ResourceLimits{Memory: 1 << 20, Pids: 16, CPU: 0.5, CgroupParent: parent})
+	require.NoError(t, err)
+
+	cg, err := m.create()
+	require.NoError(t, err)
+	assert.Equal(t, parent, filepath.Dir(cg.dir))
+
+	for file, want := range map[string]string{
+		"memory.max":      "1048576",
+		"memory.swap.max": "0",
+		"pids.max":        "16",
+		"cpu.max":         "50000 100000",
+	} {
+		data, err := os.ReadFile(filepath.Join(cg.dir, file))
+		require.NoError(t, err, file)
+		assert.Equal(t, want, string(data), file)
+	}
+
+	other, err := m.create()
+	require.NoError(t, err)
+	assert.NotEqual(t, cg.dir, other.dir)
+}
+
+func TestExecCgroup_exceeded(t *testing.T) {
+	dir := t.TempDir()
+	cg := &execCgroup{dir: dir}
+	assert.Empty(t, cg.exceeded())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.events"),
+		[]byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pids.events"), []byte("max 0\n"), 0o644))
+	assert.Equal(t, []string{"memory"}, cg.exceeded())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pids.events"), []byte("max 2\n"), 0o644))
+	assert.Equal(t, []string{"memory", "pids"}, cg.exceeded())
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Per-execution cgroups exist on Linux only; elsewhere the cgroup limits are
+// reported unavailable at startup and never enforced.
+
+type cgroupManager struct{}
+
+type execCgroup struct{}
+
+func newCgroupManager(ResourceLimits) (*cgroupManager, error) {
+	return nil, errors.New("cgroup limits require Linux")
+}
+
+func (*cgroupManager) create() (*execCgroup, error) {
+	return nil, errors.New("cgroup limits require Linux")
+}
+
+func (*execCgroup) apply(*syscall.SysProcAttr) {}
+
+func (*execCgroup) exceeded() []string { return nil }
+
+func (*execCgroup) remove() {}
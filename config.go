@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"time"
 
@@ -23,11 +26,218 @@ type SecurityConfig struct {
 	BlockedPatterns    []string      `yaml:"blocked_patterns"`    // Deprecated: use validation instead
 	AllowedExecutables []string      `yaml:"allowed_executables"` // Secure: list of allowed executable paths
 	MaxExecutionTime   time.Duration `yaml:"max_execution_time"`
+	MaxTimeout         time.Duration `yaml:"max_timeout"`       // Longest timeout a call may request; defaults to MaxExecutionTime
+	KillGracePeriod    time.Duration `yaml:"kill_grace_period"` // Wait between SIGTERM and SIGKILL for a stopped command; defaults to 2s
 	WorkingDirectory   string        `yaml:"working_directory"`
 	RunAsUser          string        `yaml:"run_as_user"`
 	MaxOutputSize      int           `yaml:"max_output_size"`
+	OutputTailSize     int           `yaml:"output_tail_size"` // Bytes of max_output_size kept from the end of the output; defaults to half
 	AuditLog           bool          `yaml:"audit_log"`
 	UseShellExecution  bool          `yaml:"use_shell_execution"` // Legacy mode - enables shell execution (DANGEROUS)
+	Pipefail           bool          `yaml:"pipefail"`            // Pipeline exit code is the rightmost non-zero stage, not the last
+	MaxGlobMatches     int           `yaml:"max_glob_matches"`    // Cap on one glob's expansion in secure mode
+	MaxArgvLength      int           `yaml:"max_argv_length"`     // Cap on a command's argv after brace and glob expansion
+	MaxStdinSize       int           `yaml:"max_stdin_size"`      // Cap on one here-document or here-string, in bytes
+
+	// ExpandableVariables are the $VAR names secure mode substitutes, from
+	// Variables or the built-ins HOME, PWD and WORKSPACE; never from the
+	// server's own environment.
+	ExpandableVariables []string          `yaml:"expandable_variables"`
+	Variables           map[string]string `yaml:"variables"`
+
+	// InlineEnv maps the variable names secure mode accepts as inline
+	// assignments (LC_ALL=C sort) to a regex the whole value must match; an
+	// empty regex accepts any value.
+	InlineEnv map[string]string `yaml:"inline_env"`
+
+	// ArgPolicies declares per-executable argument policies, keyed by
+	// basename, on top of the built-in ones for git, find, sort and tar.
+	ArgPolicies map[string]ArgPolicySpec `yaml:"arg_policies"`
+
+	// ConfinePaths restricts the paths a secure-mode command names to
+	// ReadableRoots and WritableRoots (read access to both, write access to
+	// the latter), or to WorkingDirectory when neither is set.
+	ConfinePaths  bool     `yaml:"confine_paths"`
+	ReadableRoots []string `yaml:"readable_roots"`
+	WritableRoots []string `yaml:"writable_roots"`
+
+	// VerifyExecutableHashes records the SHA-256 of every allowed executable
+	// at startup and refuses to run one whose content has changed since.
+	// ExecutableHashes pins the expected hash of an allowed_executables entry.
+	VerifyExecutableHashes bool              `yaml:"verify_executable_hashes"`
+	ExecutableHashes       map[string]string `yaml:"executable_hashes"`
+
+	// Environment controls the environment of every child process. Nil, what
+	// environment.inherit loads as, inherits the server's whole environment,
+	// secrets included.
+	Environment *EnvironmentConfig `yaml:"environment"`
+
+	// Limits caps the resources of every child process.
+	Limits ResourceLimits `yaml:"limits"`
+
+	// Sandbox runs every command in namespaces of its own.
+	Sandbox SandboxConfig `yaml:"sandbox"`
+
+	// Landlock confines the filesystem access of every child process.
+	Landlock LandlockConfig `yaml:"landlock"`
+
+	// Seccomp filters the syscalls of child processes.
+	Seccomp SeccompConfig `yaml:"seccomp"`
+
+	// ExecTracing checks every exec in a command's process tree.
+	ExecTracing ExecTracingConfig `yaml:"exec_tracing"`
+
+	// Privileges hardens the identity child processes run with.
+	Privileges PrivilegesConfig `yaml:"privileges"`
+
+	// Backends selects where commands run: locally, in the namespace
+	// sandbox or on a remote host.
+	Backends BackendsConfig `yaml:"backends"`
+}
+
+// BackendsConfig selects the backend every command runs on: Default, unless
+// Executables, keyed by basename, assigns its executables another. The
+// backends are local, sandbox (the namespace sandbox configured under
+// Sandbox) and ssh (the host configured under SSH). Sandbox.Enabled makes
+// sandbox the default.
+type BackendsConfig struct {
+	Default     string            `yaml:"default"`
+	Executables map[string]string `yaml:"executables"`
+	SSH         SSHBackendConfig  `yaml:"ssh"`
+}
+
+// SSHBackendConfig is the host the ssh backend runs commands on, through
+// Client (by default ssh from the server's PATH), in batch mode and only
+// against a host key already in KnownHostsFile (by default the client's own
+// known hosts). WorkingDirectory is the remote directory that mirrors the
+// local working_directory; commands run in the same place beneath it.
+type SSHBackendConfig struct {
+	Host             string        `yaml:"host"`
+	Port             int           `yaml:"port"`
+	User             string        `yaml:"user"`
+	IdentityFile     string        `yaml:"identity_file"`
+	KnownHostsFile   string        `yaml:"known_hosts_file"`
+	WorkingDirectory string        `yaml:"working_directory"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout"`
+	Client           string        `yaml:"client"`
+}
+
+// PrivilegesConfig makes every child switch to RunAsUser (or stay the
+// server's user) with Groups, by name or id, as its only supplementary
+// groups, drop every capability, ambient and bounding set included, and set
+// no_new_privs before it runs the command. Whether the switch is possible is
+// checked at startup; if not, every command is refused. Linux only.
+type PrivilegesConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Groups  []string `yaml:"groups"`
+}
+
+// ExecTracingConfig intercepts every execve a command's process tree makes
+// after the command itself started, and checks the new argv against
+// AllowedExecutables and the argument policies like a top-level one.
+// OnViolation says what a rejected exec does: block (the default) makes it
+// fail with EPERM, kill also kills the stage's process group. Linux on amd64
+// or arm64 only.
+type ExecTracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	OnViolation string `yaml:"on_violation"`
+}
+
+// SeccompConfig assigns seccomp profiles: Profile to every executable, and
+// Executables, keyed by basename, to single ones, overriding it ("none"
+// exempts one). Profiles defines profiles on top of the built-in default,
+// no-network and read-only-fs, replacing any of the same name.
+type SeccompConfig struct {
+	Profile     string                    `yaml:"profile"`
+	Executables map[string]string         `yaml:"executables"`
+	Profiles    map[string]SeccompProfile `yaml:"profiles"`
+}
+
+// SeccompProfile is a named syscall filter. Deny lists syscalls and the
+// groups @privileged, @network and @fs-write, which also denies opening files
+// for writing. Action is what a denied call does: kill (the default) kills
+// the process and marks the execution a seccomp violation, errno makes the
+// call fail with EPERM.
+type SeccompProfile struct {
+	Deny   []string `yaml:"deny"`
+	Action string   `yaml:"action"`
+}
+
+// LandlockConfig applies a Landlock ruleset to every child before it starts:
+// ReadOnlyPaths (by default defaultSandboxPaths and a few devices) may be
+// read and executed, WorkingDirectory and /dev/null also written, and nothing
+// else touched. Unsupported says what to do on a kernel without Landlock:
+// refuse to run commands (the default) or warn once and run them unconfined.
+type LandlockConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	ReadOnlyPaths []string `yaml:"read_only_paths"`
+	Unsupported   string   `yaml:"unsupported"`
+}
+
+// SandboxConfig runs every command in new user, mount, PID, IPC, UTS and,
+// unless Network is set, network namespaces. The command sees a filesystem
+// of its own: WorkingDirectory read-write, ReadOnlyPaths (by default
+// defaultSandboxPaths) read-only, a private /tmp and /dev, and nothing else.
+// Linux only, and unprivileged user namespaces must be allowed.
+type SandboxConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Network       bool     `yaml:"network"` // Keep the host network; by default a command has none
+	ReadOnlyPaths []string `yaml:"read_only_paths"`
+}
+
+// ResourceLimits are the resource caps applied to every execution; zero
+// leaves a resource unlimited. The rlimits are set on each child process
+// before it starts. Memory, Pids and CPU are enforced on the execution as a
+// whole, through a cgroup v2 created for it under CgroupParent (by default
+// the server's own cgroup), and only when that subtree is delegated to the
+// server.
+type ResourceLimits struct {
+	AddressSpace int64 `yaml:"address_space"` // RLIMIT_AS, in bytes
+	CPUSeconds   int64 `yaml:"cpu_seconds"`   // RLIMIT_CPU
+	Processes    int64 `yaml:"processes"`     // RLIMIT_NPROC, counted per user
+	FileSize     int64 `yaml:"file_size"`     // RLIMIT_FSIZE, in bytes
+	OpenFiles    int64 `yaml:"open_files"`    // RLIMIT_NOFILE
+
+	Memory       int64   `yaml:"memory"` // memory.max, in bytes
+	Pids         int64   `yaml:"pids"`   // pids.max
+	CPU          float64 `yaml:"cpu"`    // cpu.max, in CPUs (0.5 is half of one)
+	CgroupParent string  `yaml:"cgroup_parent"`
+}
+
+// EnvironmentConfig is the environment children start from: the Passthrough
+// variables copied from the server, the fixed Set values, and PATH set to
+// Path when it is not empty. A configured Path also replaces the server's
+// PATH for resolving allowed executables. Nothing else reaches a child, apart
+// from a command's own allowed inline assignments. Inherit, which excludes
+// the other fields, opts back into the server's whole environment.
+type EnvironmentConfig struct {
+	Inherit     bool              `yaml:"inherit"`
+	Passthrough []string          `yaml:"passthrough"`
+	Set         map[string]string `yaml:"set"`
+	Path        string            `yaml:"path"`
+}
+
+// ArgPolicySpec is one arg_policies entry. Flags are deny-by-default: a flag
+// is accepted only if listed under LongFlags (whole, "--key" or find-style
+// "-name") or if every letter of its short cluster is in ShortFlags or
+// ArgShortFlags; an ArgShortFlags letter takes the rest of the cluster or the
+// next word as its value. DeniedFlags maps a flag to the reason it is refused
+// ("writes to an arbitrary file") and wins over the allowlists. Subcommands,
+// if set, lists the accepted values of the first non-flag word. With Extend
+// the built-in policy of the same name keeps applying and the entry only adds
+// to it; without it the entry replaces the built-in. PathFlags and Operands
+// tell confine_paths which words are paths: PathFlags maps a value-taking flag
+// to read, write or none, any other flag value counts as read, and Operands
+// (read by default) applies to every operand.
+type ArgPolicySpec struct {
+	ShortFlags    string            `yaml:"short_flags"`
+	ArgShortFlags string            `yaml:"arg_short_flags"`
+	LongFlags     []string          `yaml:"long_flags"`
+	Subcommands   []string          `yaml:"subcommands"`
+	DeniedFlags   map[string]string `yaml:"denied_flags"`
+	Extend        bool              `yaml:"extend"`
+	PathFlags     map[string]string `yaml:"path_flags"`
+	Operands      string            `yaml:"operands"`
 }
 
 type ServerConfig struct {
@@ -59,6 +269,13 @@ func newDefaultSecurityConfig() SecurityConfig {
 		MaxOutputSize:    1048576,
 		WorkingDirectory: "/tmp",
 		AuditLog:         true,
+		Pipefail:         true,
+		MaxGlobMatches:   defaultMaxGlobMatches,
+		MaxArgvLength:    defaultMaxArgvLength,
+		MaxStdinSize:     defaultMaxStdinSize,
+		Environment: &EnvironmentConfig{
+			Passthrough: defaultPassthroughEnv,
+		},
 	}
 }
 
@@ -106,17 +323,41 @@ func loadSecurityFromFile(config *Config, filename string) error {
 
 	var yamlConfig struct {
 		Security struct {
-			Enabled            bool     `yaml:"enabled"`
-			AllowedCommands    []string `yaml:"allowed_commands"`
-			BlockedCommands    []string `yaml:"blocked_commands"`
-			BlockedPatterns    []string `yaml:"blocked_patterns"`
-			AllowedExecutables []string `yaml:"allowed_executables"`
-			MaxExecutionTime   string   `yaml:"max_execution_time"`
-			WorkingDirectory   string   `yaml:"working_directory"`
-			RunAsUser          string   `yaml:"run_as_user"`
-			MaxOutputSize      int      `yaml:"max_output_size"`
-			AuditLog           bool     `yaml:"audit_log"`
-			UseShellExecution  bool     `yaml:"use_shell_execution"`
+			Enabled                bool                     `yaml:"enabled"`
+			AllowedCommands        []string                 `yaml:"allowed_commands"`
+			BlockedCommands        []string                 `yaml:"blocked_commands"`
+			BlockedPatterns        []string                 `yaml:"blocked_patterns"`
+			AllowedExecutables     []string                 `yaml:"allowed_executables"`
+			MaxExecutionTime       string                   `yaml:"max_execution_time"`
+			MaxTimeout             string                   `yaml:"max_timeout"`
+			KillGracePeriod        string                   `yaml:"kill_grace_period"`
+			WorkingDirectory       string                   `yaml:"working_directory"`
+			RunAsUser              string                   `yaml:"run_as_user"`
+			MaxOutputSize          int                      `yaml:"max_output_size"`
+			OutputTailSize         int                      `yaml:"output_tail_size"`
+			AuditLog               bool                     `yaml:"audit_log"`
+			UseShellExecution      bool                     `yaml:"use_shell_execution"`
+			Pipefail               *bool                    `yaml:"pipefail"`
+			MaxGlobMatches         int                      `yaml:"max_glob_matches"`
+			MaxArgvLength          int                      `yaml:"max_argv_length"`
+			MaxStdinSize           int                      `yaml:"max_stdin_size"`
+			ExpandableVariables    []string                 `yaml:"expandable_variables"`
+			Variables              map[string]string        `yaml:"variables"`
+			InlineEnv              map[string]string        `yaml:"inline_env"`
+			ArgPolicies            map[string]ArgPolicySpec `yaml:"arg_policies"`
+			ConfinePaths           bool                     `yaml:"confine_paths"`
+			ReadableRoots          []string                 `yaml:"readable_roots"`
+			WritableRoots          []string                 `yaml:"writable_roots"`
+			VerifyExecutableHashes bool                     `yaml:"verify_executable_hashes"`
+			ExecutableHashes       map[string]string        `yaml:"executable_hashes"`
+			Environment            *EnvironmentConfig       `yaml:"environment"`
+			Limits                 ResourceLimits           `yaml:"limits"`
+			Sandbox                SandboxConfig            `yaml:"sandbox"`
+			Landlock               LandlockConfig           `yaml:"landlock"`
+			Seccomp                SeccompConfig            `yaml:"seccomp"`
+			ExecTracing            ExecTracingConfig        `yaml:"exec_tracing"`
+			Privileges             PrivilegesConfig         `yaml:"privileges"`
+			Backends               BackendsConfig           `yaml:"backends"`
 		} `yaml:"security"`
 	}
 
@@ -132,8 +373,40 @@ func loadSecurityFromFile(config *Config, filename string) error {
 	config.Security.WorkingDirectory = yamlConfig.Security.WorkingDirectory
 	config.Security.RunAsUser = yamlConfig.Security.RunAsUser
 	config.Security.MaxOutputSize = yamlConfig.Security.MaxOutputSize
+	config.Security.OutputTailSize = yamlConfig.Security.OutputTailSize
 	config.Security.AuditLog = yamlConfig.Security.AuditLog
 	config.Security.UseShellExecution = yamlConfig.Security.UseShellExecution
+	if yamlConfig.Security.Pipefail != nil {
+		config.Security.Pipefail = *yamlConfig.Security.Pipefail
+	}
+	config.Security.MaxGlobMatches = yamlConfig.Security.MaxGlobMatches
+	config.Security.MaxArgvLength = yamlConfig.Security.MaxArgvLength
+	config.Security.MaxStdinSize = yamlConfig.Security.MaxStdinSize
+	config.Security.ExpandableVariables = yamlConfig.Security.ExpandableVariables
+	config.Security.Variables = yamlConfig.Security.Variables
+	config.Security.InlineEnv = yamlConfig.Security.InlineEnv
+	config.Security.ArgPolicies = yamlConfig.Security.ArgPolicies
+	config.Security.ConfinePaths = yamlConfig.Security.ConfinePaths
+	config.Security.ReadableRoots = yamlConfig.Security.ReadableRoots
+	config.Security.WritableRoots = yamlConfig.Security.WritableRoots
+	config.Security.VerifyExecutableHashes = yamlConfig.Security.VerifyExecutableHashes
+	config.Security.ExecutableHashes = yamlConfig.Security.ExecutableHashes
+	if env := yamlConfig.Security.Environment; env != nil {
+		if env.Inherit {
+			if len(env.Passthrough) > 0 || len(env.Set) > 0 || env.Path != "" {
+				return fmt.Errorf("environment.inherit cannot be combined with passthrough, set or path")
+			}
+			env = nil
+		}
+		config.Security.Environment = env
+	}
+	config.Security.Limits = yamlConfig.Security.Limits
+	config.Security.Sandbox = yamlConfig.Security.Sandbox
+	config.Security.Landlock = yamlConfig.Security.Landlock
+	config.Security.Seccomp = yamlConfig.Security.Seccomp
+	config.Security.ExecTracing = yamlConfig.Security.ExecTracing
+	config.Security.Privileges = yamlConfig.Security.Privileges
+	config.Security.Backends = yamlConfig.Security.Backends
 
 	if yamlConfig.Security.MaxExecutionTime != "" {
 		duration, err := time.ParseDuration(yamlConfig.Security.MaxExecutionTime)
@@ -142,6 +415,20 @@ func loadSecurityFromFile(config *Config, filename string) error {
 		}
 		config.Security.MaxExecutionTime = duration
 	}
+	if yamlConfig.Security.MaxTimeout != "" {
+		duration, err := time.ParseDuration(yamlConfig.Security.MaxTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid max_timeout: %w", err)
+		}
+		config.Security.MaxTimeout = duration
+	}
+	if yamlConfig.Security.KillGracePeriod != "" {
+		duration, err := time.ParseDuration(yamlConfig.Security.KillGracePeriod)
+		if err != nil {
+			return fmt.Errorf("invalid kill_grace_period: %w", err)
+		}
+		config.Security.KillGracePeriod = duration
+	}
 
 	return nil
 }
@@ -150,6 +437,100 @@ func validateConfig(config *Config) error {
 	if config.Security.MaxOutputSize < 0 {
 		return fmt.Errorf("max_output_size cannot be negative")
 	}
+	if config.Security.OutputTailSize < 0 {
+		return fmt.Errorf("output_tail_size cannot be negative")
+	}
+	if config.Security.MaxOutputSize > 0 && config.Security.OutputTailSize > config.Security.MaxOutputSize {
+		return fmt.Errorf("output_tail_size cannot exceed max_output_size")
+	}
+	if config.Security.KillGracePeriod < 0 {
+		return fmt.Errorf("kill_grace_period cannot be negative")
+	}
+	if config.Security.MaxTimeout < 0 {
+		return fmt.Errorf("max_timeout cannot be negative")
+	}
+	if config.Security.MaxTimeout > 0 && config.Security.MaxTimeout < config.Security.MaxExecutionTime {
+		return fmt.Errorf("max_timeout cannot be less than max_execution_time")
+	}
+	if config.Security.MaxGlobMatches < 0 {
+		return fmt.Errorf("max_glob_matches cannot be negative")
+	}
+	if config.Security.MaxArgvLength < 0 {
+		return fmt.Errorf("max_argv_length cannot be negative")
+	}
+	if config.Security.MaxStdinSize < 0 {
+		return fmt.Errorf("max_stdin_size cannot be negative")
+	}
+	for name := range config.Security.Variables {
+		if !variableNameRe.MatchString(name) {
+			return fmt.Errorf("invalid variable name: %q", name)
+		}
+	}
+	for name, pattern := range config.Security.InlineEnv {
+		if !variableNameRe.MatchString(name) {
+			return fmt.Errorf("invalid inline_env variable name: %q", name)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid inline_env pattern for %s: %w", name, err)
+		}
+	}
+	for _, name := range config.Security.ExpandableVariables {
+		if _, ok := config.Security.Variables[name]; !ok && !slices.Contains(builtinVariables, name) {
+			return fmt.Errorf("expandable variable %q is neither built in nor defined under variables", name)
+		}
+	}
+	builtinPolicies := newDefaultPolicySet()
+	for exe, spec := range config.Security.ArgPolicies {
+		if err := validateArgPolicy(exe, spec, builtinPolicies.governs(exe)); err != nil {
+			return fmt.Errorf("invalid arg_policies entry %q: %w", exe, err)
+		}
+	}
+	for _, root := range config.Security.ReadableRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("readable_roots entry %q must be an absolute path", root)
+		}
+	}
+	for _, root := range config.Security.WritableRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("writable_roots entry %q must be an absolute path", root)
+		}
+	}
+	if config.Security.ConfinePaths && config.Security.WorkingDirectory == "" &&
+		len(config.Security.ReadableRoots) == 0 && len(config.Security.WritableRoots) == 0 {
+		return fmt.Errorf("confine_paths requires readable_roots, writable_roots or a working_directory")
+	}
+	for entry, sum := range config.Security.ExecutableHashes {
+		if !slices.Contains(config.Security.AllowedExecutables, entry) {
+			return fmt.Errorf("executable_hashes entry %q is not in allowed_executables", entry)
+		}
+		if !sha256HexRe.MatchString(sum) {
+			return fmt.Errorf("executable_hashes entry %q is not a lowercase hex SHA-256", entry)
+		}
+	}
+	if err := validateEnvironment(config.Security.Environment); err != nil {
+		return err
+	}
+	if err := validateLimits(config.Security.Limits); err != nil {
+		return err
+	}
+	if err := validateBackends(config.Security); err != nil {
+		return err
+	}
+	if err := validateSandbox(config.Security); err != nil {
+		return err
+	}
+	if err := validateLandlock(config.Security); err != nil {
+		return err
+	}
+	if err := validateSeccomp(config.Security.Seccomp); err != nil {
+		return err
+	}
+	if err := validateExecTracing(config.Security); err != nil {
+		return err
+	}
+	if err := validatePrivileges(config.Security); err != nil {
+		return err
+	}
 
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true, "fatal": true,
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,6 +25,13 @@ func TestLoadConfig_defaults(t *testing.T) {
 	assert.True(t, config.Security.Enabled)
 	assert.False(t, config.Security.UseShellExecution)
 	assert.NotEmpty(t, config.Security.AllowedExecutables)
+	assert.True(t, config.Security.Pipefail)
+	assert.Equal(t, defaultMaxGlobMatches, config.Security.MaxGlobMatches)
+	assert.Equal(t, defaultMaxArgvLength, config.Security.MaxArgvLength)
+	assert.Equal(t, defaultMaxStdinSize, config.Security.MaxStdinSize)
+	// Children never inherit the server's whole environment by default.
+	require.NotNil(t, config.Security.Environment)
+	assert.Equal(t, defaultPassthroughEnv, config.Security.Environment.Passthrough)
 	// No shell/language interpreter ships in the default allowlist.
 	for _, exe := range config.Security.AllowedExecutables {
 		assert.False(t, isInterpreterExecutable(exe),
@@ -84,6 +92,35 @@ security:
   run_as_user: "nobody"
   max_output_size: 2048
   audit_log: true
+  pipefail: true
+  expandable_variables: ["WORKSPACE", "PROJECT"]
+  variables:
+    PROJECT: demo
+  inline_env:
+    LC_ALL: "C|POSIX"
+    TZ: ""
+  arg_policies:
+    jq:
+      short_flags: "rcnSe"
+      arg_short_flags: "f"
+      long_flags: ["--raw-output", "--arg"]
+      denied_flags:
+        "--from-file": "reads a filter from an arbitrary file"
+    sort:
+      extend: true
+      denied_flags:
+        "-m": ""
+  confine_paths: true
+  readable_roots: ["/tmp", "/usr/share/dict"]
+  writable_roots: ["/tmp/out"]
+  verify_executable_hashes: true
+  executable_hashes:
+    ls: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+  environment:
+    passthrough: [HOME, USER]
+    set:
+      LANG: C.UTF-8
+    path: /usr/bin:/bin
 `,
 			expectError: false,
 			validateConfig: func(t *testing.T, config *Config) {
@@ -95,6 +132,29 @@ security:
 				assert.Equal(t, "nobody", config.Security.RunAsUser)
 				assert.Equal(t, 2048, config.Security.MaxOutputSize)
 				assert.True(t, config.Security.AuditLog)
+				assert.True(t, config.Security.Pipefail)
+				assert.Equal(t, []string{"WORKSPACE", "PROJECT"}, config.Security.ExpandableVariables)
+				assert.Equal(t, map[string]string{"PROJECT": "demo"}, config.Security.Variables)
+				assert.Equal(t, map[string]string{"LC_ALL": "C|POSIX", "TZ": ""}, config.Security.InlineEnv)
+				assert.Equal(t, map[string]ArgPolicySpec{
+					"jq": {
+						ShortFlags:    "rcnSe",
+						ArgShortFlags: "f",
+						LongFlags:     []string{"--raw-output", "--arg"},
+						DeniedFlags:   map[string]string{"--from-file": "reads a filter from an arbitrary file"},
+					},
+					"sort": {Extend: true, DeniedFlags: map[string]string{"-m": ""}},
+				}, config.Security.ArgPolicies)
+				assert.True(t, config.Security.ConfinePaths)
+				assert.Equal(t, []string{"/tmp", "/usr/share/dict"}, config.Security.ReadableRoots)
+				assert.Equal(t, []string{"/tmp/out"}, config.Security.WritableRoots)
+				assert.True(t, config.Security.VerifyExecutableHashes)
+				assert.Equal(t, &EnvironmentConfig{
+					Passthrough: []string{"HOME", "USER"},
+					Set:         map[string]string{"LANG": "C.UTF-8"},
+					Path:        "/usr/bin:/bin",
+				}, config.Security.Environment)
+				assert.Equal(t, map[string]string{"ls": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}, config.Security.ExecutableHashes)
 			},
 		},
 		{
@@ -125,12 +185,244 @@ security:
 				assert.False(t, config.Security.AuditLog)
 			},
 		},
+		{
+			name: "no environment block keeps the default passthrough",
+			yamlContent: `
+security:
+  enabled: true
+  allowed_executables: [ls]
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				require.NotNil(t, config.Security.Environment)
+				assert.Equal(t, defaultPassthroughEnv, config.Security.Environment.Passthrough)
+			},
+		},
+		{
+			name: "environment inherit",
+			yamlContent: `
+security:
+  enabled: true
+  environment:
+    inherit: true
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Nil(t, config.Security.Environment)
+			},
+		},
+		{
+			name: "environment inherit with passthrough",
+			yamlContent: `
+security:
+  environment:
+    inherit: true
+    passthrough: [HOME]
+`,
+			expectError: true,
+		},
+		{
+			name: "no pipefail keeps the default",
+			yamlContent: `
+security:
+  enabled: true
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.True(t, config.Security.Pipefail)
+			},
+		},
+		{
+			name: "pipefail disabled",
+			yamlContent: `
+security:
+  enabled: true
+  pipefail: false
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.False(t, config.Security.Pipefail)
+			},
+		},
 		{
 			name: "invalid max_execution_time",
 			yamlContent: `
 security:
   enabled: true
   max_execution_time: "invalid_duration"
+`,
+			expectError: true,
+		},
+		{
+			name: "max_timeout",
+			yamlContent: `
+security:
+  enabled: true
+  max_execution_time: "30s"
+  max_timeout: "10m"
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, 10*time.Minute, config.Security.MaxTimeout)
+			},
+		},
+		{
+			name: "resource limits",
+			yamlContent: `
+security:
+  enabled: true
+  limits:
+    cpu_seconds: 10
+    open_files: 256
+    memory: 268435456
+    cpu: 0.5
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, ResourceLimits{CPUSeconds: 10, OpenFiles: 256, Memory: 268435456, CPU: 0.5}, config.Security.Limits)
+			},
+		},
+		{
+			name: "sandbox",
+			yamlContent: `
+security:
+  enabled: true
+  working_directory: /tmp
+  sandbox:
+    enabled: true
+    network: true
+    read_only_paths: [/usr, /etc]
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, SandboxConfig{Enabled: true, Network: true, ReadOnlyPaths: []string{"/usr", "/etc"}}, config.Security.Sandbox)
+			},
+		},
+		{
+			name: "landlock",
+			yamlContent: `
+security:
+  enabled: true
+  working_directory: /tmp
+  landlock:
+    enabled: true
+    read_only_paths: [/usr, /etc]
+    unsupported: warn
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, LandlockConfig{Enabled: true, ReadOnlyPaths: []string{"/usr", "/etc"}, Unsupported: "warn"}, config.Security.Landlock)
+			},
+		},
+		{
+			name: "seccomp",
+			yamlContent: `
+security:
+  enabled: true
+  seccomp:
+    executables:
+      git: none
+    profiles:
+      offline:
+        deny: ["@privileged", ptrace]
+        action: errno
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, SeccompConfig{
+					Executables: map[string]string{"git": "none"},
+					Profiles:    map[string]SeccompProfile{"offline": {Deny: []string{"@privileged", "ptrace"}, Action: "errno"}},
+				}, config.Security.Seccomp)
+			},
+		},
+		{
+			name: "exec tracing",
+			yamlContent: `
+security:
+  enabled: true
+  allowed_executables: [ls]
+  exec_tracing:
+    enabled: true
+    on_violation: kill
+`,
+			expectError: !execTracingSupported,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, ExecTracingConfig{Enabled: true, OnViolation: "kill"}, config.Security.ExecTracing)
+			},
+		},
+		{
+			name: "privileges",
+			yamlContent: `
+security:
+  enabled: true
+  run_as_user: nobody
+  privileges:
+    enabled: true
+    groups: [daemon]
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, PrivilegesConfig{Enabled: true, Groups: []string{"daemon"}}, config.Security.Privileges)
+			},
+		},
+		{
+			name: "backends",
+			yamlContent: `
+security:
+  enabled: true
+  allowed_executables: [ls, git]
+  backends:
+    default: local
+    executables:
+      git: ssh
+    ssh:
+      host: build.example.com
+      port: 2222
+      user: mcp
+      working_directory: /srv/work
+      connect_timeout: 5s
+`,
+			expectError: false,
+			validateConfig: func(t *testing.T, config *Config) {
+				assert.Equal(t, BackendsConfig{
+					Default:     backendLocal,
+					Executables: map[string]string{"git": backendSSH},
+					SSH: SSHBackendConfig{
+						Host:             "build.example.com",
+						Port:             2222,
+						User:             "mcp",
+						WorkingDirectory: "/srv/work",
+						ConnectTimeout:   5 * time.Second,
+					},
+				}, config.Security.Backends)
+			},
+		},
+		{
+			name: "ssh backend without a host",
+			yamlContent: `
+security:
+  enabled: true
+  backends:
+    default: ssh
+`,
+			expectError: true,
+		},
+		{
+			name: "unknown seccomp profile",
+			yamlContent: `
+security:
+  enabled: true
+  seccomp:
+    profile: offline
+`,
+			expectError: true,
+		},
+		{
+			name: "invalid max_timeout",
+			yamlContent: `
+security:
+  enabled: true
+  max_timeout: "forever"
 `,
 			expectError: true,
 		},
@@ -203,6 +495,258 @@ func TestValidateConfig(t *testing.T) {
 			expectError: true,
 			errorMsg:    "max_output_size cannot be negative",
 		},
+		{
+			name: "negative max_glob_matches",
+			config: Config{
+				Security: SecurityConfig{
+					MaxGlobMatches: -1,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "max_glob_matches cannot be negative",
+		},
+		{
+			name: "negative max_argv_length",
+			config: Config{
+				Security: SecurityConfig{
+					MaxArgvLength: -1,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "max_argv_length cannot be negative",
+		},
+		{
+			name: "negative max_stdin_size",
+			config: Config{
+				Security: SecurityConfig{
+					MaxStdinSize: -1,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "max_stdin_size cannot be negative",
+		},
+		{
+			name: "expandable variable without a value",
+			config: Config{
+				Security: SecurityConfig{
+					ExpandableVariables: []string{"HOME", "PROJECT"},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `expandable variable "PROJECT" is neither built in nor defined under variables`,
+		},
+		{
+			name: "invalid variable name",
+			config: Config{
+				Security: SecurityConfig{
+					Variables: map[string]string{"A-B": "x"},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `invalid variable name: "A-B"`,
+		},
+		{
+			name: "invalid inline_env pattern",
+			config: Config{
+				Security: SecurityConfig{
+					InlineEnv: map[string]string{"LC_ALL": "("},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "invalid inline_env pattern for LC_ALL",
+		},
+		{
+			name: "arg policy for an interpreter",
+			config: Config{
+				Security: SecurityConfig{
+					ArgPolicies: map[string]ArgPolicySpec{"python3": {LongFlags: []string{"--version"}}},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `invalid arg_policies entry "python3": python3 is an interpreter`,
+		},
+		{
+			name: "arg policy extending nothing",
+			config: Config{
+				Security: SecurityConfig{
+					ArgPolicies: map[string]ArgPolicySpec{"jq": {Extend: true}},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "extend requires a built-in policy",
+		},
+		{
+			name: "arg policy with a malformed long flag",
+			config: Config{
+				Security: SecurityConfig{
+					ArgPolicies: map[string]ArgPolicySpec{"jq": {LongFlags: []string{"raw-output"}}},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `long flag "raw-output" must start with -`,
+		},
+		{
+			name: "arg policy with an unknown path access",
+			config: Config{
+				Security: SecurityConfig{
+					ArgPolicies: map[string]ArgPolicySpec{"jq": {PathFlags: map[string]string{"-f": "exec"}}},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `path flag -f: invalid path access "exec"`,
+		},
+		{
+			name: "relative readable root",
+			config: Config{
+				Security: SecurityConfig{
+					ConfinePaths:  true,
+					ReadableRoots: []string{"data"},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `readable_roots entry "data" must be an absolute path`,
+		},
+		{
+			name: "confinement without any root",
+			config: Config{
+				Security: SecurityConfig{
+					ConfinePaths: true,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "confine_paths requires readable_roots, writable_roots or a working_directory",
+		},
+		{
+			name: "hash for an executable not on the allowlist",
+			config: Config{
+				Security: SecurityConfig{
+					AllowedExecutables: []string{"ls"},
+					ExecutableHashes:   map[string]string{"cat": strings.Repeat("a", 64)},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `executable_hashes entry "cat" is not in allowed_executables`,
+		},
+		{
+			name: "malformed executable hash",
+			config: Config{
+				Security: SecurityConfig{
+					AllowedExecutables: []string{"ls"},
+					ExecutableHashes:   map[string]string{"ls": "ABC"},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `executable_hashes entry "ls" is not a lowercase hex SHA-256`,
+		},
+		{
+			name: "invalid environment",
+			config: Config{
+				Security: SecurityConfig{
+					Environment: &EnvironmentConfig{Path: "bin"},
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    `environment.path entry "bin" must be an absolute directory`,
+		},
+		{
+			name: "output_tail_size above max_output_size",
+			config: Config{
+				Security: SecurityConfig{
+					MaxOutputSize:  1024,
+					OutputTailSize: 2048,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "output_tail_size cannot exceed max_output_size",
+		},
+		{
+			name: "negative kill_grace_period",
+			config: Config{
+				Security: SecurityConfig{
+					KillGracePeriod: -time.Second,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "kill_grace_period cannot be negative",
+		},
+		{
+			name: "negative max_timeout",
+			config: Config{
+				Security: SecurityConfig{
+					MaxTimeout: -time.Second,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "max_timeout cannot be negative",
+		},
+		{
+			name: "max_timeout below max_execution_time",
+			config: Config{
+				Security: SecurityConfig{
+					MaxExecutionTime: time.Minute,
+					MaxTimeout:       time.Second,
+				},
+				Logging: LoggingConfig{
+					Level: "info",
+				},
+			},
+			expectError: true,
+			errorMsg:    "max_timeout cannot be less than max_execution_time",
+		},
 		{
 			name: "invalid log level",
 			config: Config{
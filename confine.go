@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pathAccess is what a command does with a path argument.
+type pathAccess int
+
+const (
+	accessNone pathAccess = iota
+	accessRead
+	accessWrite
+)
+
+// parsePathAccess parses an access named in security.yaml.
+func parsePathAccess(s string) (pathAccess, error) {
+	switch s {
+	case "none":
+		return accessNone, nil
+	case "read":
+		return accessRead, nil
+	case "write":
+		return accessWrite, nil
+	}
+	return accessNone, fmt.Errorf("invalid path access %q (want read, write or none)", s)
+}
+
+// pathArg is one argv word that names a file, and how it is used.
+type pathArg struct {
+	path   string
+	access pathAccess
+}
+
+// pathClassifier is implemented by argPolicies that know where their
+// executable's argv names files. Executables without one fall back to
+// defaultPathRules or, failing that, fallbackPathRules.
+type pathClassifier interface {
+	pathArgs(argv []string) []pathArg
+}
+
+// pathRules classifies the words of a getopt-style argv. Operands are paths
+// with the operands access, except a leading pattern operand and the operand
+// at writeOperand (1-based). A flag's value is a path with the access given in
+// valueFlags for that flag ("-f", "--file"), or values otherwise; argTaking
+// lists the short letters that take a value, attached or in the next word.
+type pathRules struct {
+	argTaking    byteSet
+	valueFlags   map[string]pathAccess
+	values       pathAccess
+	operands     pathAccess
+	writeOperand int
+	// patternFlags supply the pattern themselves (grep -e/-f); without one,
+	// the first operand is the pattern, not a path.
+	patternFlags stringSet
+}
+
+// newPathRules builds rules whose argTaking also covers every short flag in
+// valueFlags. argTaking is copied, never modified, so a policy's own letter
+// set can be passed in.
+func newPathRules(argTaking byteSet, valueFlags map[string]pathAccess) *pathRules {
+	letters := make(byteSet, len(argTaking))
+	for c := range argTaking {
+		letters[c] = struct{}{}
+	}
+	for flag := range valueFlags {
+		if len(flag) == 2 && flag[0] == '-' && flag[1] != '-' {
+			letters[flag[1]] = struct{}{}
+		}
+	}
+	return &pathRules{argTaking: letters, valueFlags: valueFlags, operands: accessRead}
+}
+
+func (r *pathRules) pathArgs(argv []string) []pathArg {
+	var out []pathArg
+	add := func(p string, access pathAccess) {
+		if access != accessNone && p != "" && p != "-" {
+			out = append(out, pathArg{path: p, access: access})
+		}
+	}
+
+	patternPending := len(r.patternFlags) > 0 && !r.patternSupplied(argv)
+	operand := 0
+	operandsOnly := false
+	for i := 1; i < len(argv); i++ {
+		a := argv[i]
+		if operandsOnly || !isFlagToken(a) {
+			if a == "--" && !operandsOnly {
+				operandsOnly = true
+				continue
+			}
+			if patternPending {
+				patternPending = false
+				continue
+			}
+			operand++
+			if operand == r.writeOperand {
+				add(a, accessWrite)
+			} else {
+				add(a, r.operands)
+			}
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(a, "=")
+		if access, ok := r.valueFlags[name]; ok && (strings.HasPrefix(a, "--") || len(name) > 2) {
+			// A long flag, or a find-style single-dash word such as -newer.
+			if !hasValue && i+1 < len(argv) {
+				i++
+				value = argv[i]
+			}
+			add(value, access)
+			continue
+		}
+		if strings.HasPrefix(a, "--") {
+			if hasValue {
+				add(value, r.values)
+			}
+			continue
+		}
+		for j := 1; j < len(a); j++ {
+			c := a[j]
+			if !r.argTaking.has(c) {
+				continue
+			}
+			value := a[j+1:]
+			if value == "" && i+1 < len(argv) {
+				i++
+				value = argv[i]
+			}
+			access, ok := r.valueFlags["-"+string(c)]
+			if !ok {
+				access = r.values
+			}
+			add(value, access)
+			break
+		}
+	}
+	return out
+}
+
+// patternSupplied reports whether a flag in argv supplies the pattern, so no
+// operand is taken for it.
+func (r *pathRules) patternSupplied(argv []string) bool {
+	for _, a := range argv[1:] {
+		if a == "--" {
+			return false
+		}
+		if !isFlagToken(a) {
+			continue
+		}
+		if strings.HasPrefix(a, "--") {
+			if r.patternFlags.has(longFlagName(a)) {
+				return true
+			}
+			continue
+		}
+		for j := 1; j < len(a); j++ {
+			if r.patternFlags.has("-" + a[j:j+1]) {
+				return true
+			}
+			if r.argTaking.has(a[j]) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// defaultPathRules covers the default allowlist's executables that no
+// argPolicy governs. Flag values they do not list are not paths.
+var defaultPathRules = map[string]*pathRules{
+	"cat":  newPathRules(nil, nil),
+	"ls":   newPathRules(newByteSet("ITw"), map[string]pathAccess{"--hide": accessNone, "--ignore": accessNone}),
+	"head": newPathRules(newByteSet("nc"), nil),
+	"tail": newPathRules(newByteSet("ncs"), map[string]pathAccess{"--pid": accessNone}),
+	"wc":   newPathRules(nil, map[string]pathAccess{"--files0-from": accessRead}),
+	"grep": func() *pathRules {
+		r := newPathRules(newByteSet("efmABCdD"), map[string]pathAccess{
+			"-f":             accessRead,
+			"--file":         accessRead,
+			"--exclude-from": accessRead,
+		})
+		r.patternFlags = newStringSet("-e", "-f", "--regexp", "--file")
+		return r
+	}(),
+	"uniq": func() *pathRules {
+		r := newPathRules(newByteSet("fsw"), nil)
+		r.writeOperand = 2
+		return r
+	}(),
+	"date": func() *pathRules {
+		r := newPathRules(newByteSet("ds"), map[string]pathAccess{
+			"-f": accessRead, "--file": accessRead,
+			"-r": accessRead, "--reference": accessRead,
+		})
+		r.operands = accessNone
+		return r
+	}(),
+	"echo":   {operands: accessNone},
+	"pwd":    {operands: accessNone},
+	"whoami": {operands: accessNone},
+}
+
+// fallbackPathRules is used for an executable nothing else describes: every
+// operand and every --flag=value value is taken to be a path it reads.
+var fallbackPathRules = &pathRules{values: accessRead, operands: accessRead}
+
+// pathArgs classifies argv's path arguments with the rules for its executable.
+func (s *policySet) pathArgs(argv []string) []pathArg {
+	base := filepath.Base(argv[0])
+	if c, ok := s.byName[base].(pathClassifier); ok {
+		return c.pathArgs(argv)
+	}
+	if r, ok := defaultPathRules[base]; ok {
+		return r.pathArgs(argv)
+	}
+	return fallbackPathRules.pathArgs(argv)
+}
+
+// pathConfinement restricts the paths a command names to a set of roots:
+// reads to readable or writable roots, writes to writable roots only.
+// Relative paths are taken relative to workDir, and every path is resolved
+// through its symlinks before it is judged.
+type pathConfinement struct {
+	workDir  string
+	readable []string
+	writable []string
+}
+
+// newPathConfinement returns the confinement cfg configures, or nil when
+// confine_paths is off. With no roots configured, the working directory is
+// the only root, readable and writable.
+func newPathConfinement(cfg SecurityConfig) *pathConfinement {
+	if !cfg.ConfinePaths {
+		return nil
+	}
+	c := &pathConfinement{
+		workDir:  cfg.WorkingDirectory,
+		readable: cfg.ReadableRoots,
+		writable: cfg.WritableRoots,
+	}
+	if len(c.readable) == 0 && len(c.writable) == 0 && cfg.WorkingDirectory != "" {
+		c.writable = []string{cfg.WorkingDirectory}
+	}
+	return c
+}
+
+func (c *pathConfinement) check(arg pathArg) error {
+	p := arg.path
+	if !filepath.IsAbs(p) {
+		dir, err := filepath.Abs(c.workDir)
+		if err != nil {
+			return fmt.Errorf("resolve working directory %q: %w", c.workDir, err)
+		}
+		p = filepath.Join(dir, p)
+	}
+	real, err := resolveExisting(p)
+	if err != nil {
+		return fmt.Errorf("resolve %q: %w", arg.path, err)
+	}
+
+	roots, kind := c.writable, "writable"
+	if arg.access == accessRead {
+		roots, kind = append(append([]string(nil), c.readable...), c.writable...), "readable"
+	}
+	for _, root := range roots {
+		realRoot, err := resolveExisting(root)
+		if err != nil {
+			continue
+		}
+		if pathWithin(realRoot, real) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q resolves outside the %s roots", arg.path, kind)
+}
+
+// Path classification for the built-in policies, reusing their knowledge of
+// which short flags take a value.
+
+var sortPathRules = newPathRules(sortArgTaking, map[string]pathAccess{
+	"-o": accessWrite, "--output": accessWrite,
+	"-T": accessWrite, "--temporary-directory": accessWrite,
+	"--files0-from": accessRead, "--random-source": accessRead,
+})
+
+func (*sortArgPolicy) pathArgs(argv []string) []pathArg {
+	return sortPathRules.pathArgs(argv)
+}
+
+// Every git operand after the subcommand is taken to be a path: refs and
+// revisions are relative names that resolve inside the working directory,
+// while "git diff --no-index /etc/shadow x" is caught.
+var gitPathRules = newPathRules(nil, nil)
+
+func (*gitArgPolicy) pathArgs(argv []string) []pathArg {
+	return gitPathRules.pathArgs(argv)
+}
+
+// findReadPrimaries name a file find reads to compare against.
+var findReadPrimaries = newStringSet("-newer", "-anewer", "-cnewer", "-samefile")
+
+// pathArgs returns find's starting points, the operands before the first
+// option or expression token, and the files its comparison primaries name.
+// Every other expression word is a pattern or a number, not a path.
+func (*findArgPolicy) pathArgs(argv []string) []pathArg {
+	var out []pathArg
+	i := 1
+	for i < len(argv) && (argv[i] == "-H" || argv[i] == "-L" || argv[i] == "-P") {
+		i++
+	}
+	for ; i < len(argv); i++ {
+		a := argv[i]
+		if strings.HasPrefix(a, "-") || a == "(" || a == "!" {
+			break
+		}
+		out = append(out, pathArg{path: a, access: accessRead})
+	}
+	for ; i < len(argv)-1; i++ {
+		a := argv[i]
+		// -newerXY compares against a file unless Y is t (a date string).
+		if findReadPrimaries.has(a) || (strings.HasPrefix(a, "-newer") && len(a) == 8 && a[7] != 't') {
+			i++
+			out = append(out, pathArg{path: argv[i], access: accessRead})
+		}
+	}
+	return out
+}
+
+// pathArgs classifies tar's words. The archive named by -f is written when
+// tar creates, appends to or updates it and read otherwise; member operands
+// are taken to be read paths either way. The historic bundled first word
+// ("tar czf a.tar dir") is unbundled first, each value-taking letter taking
+// the next word in turn.
+func (*tarArgPolicy) pathArgs(argv []string) []pathArg {
+	words := argv
+	if len(argv) > 1 && argv[1] != "" && argv[1][0] != '-' {
+		words = []string{argv[0]}
+		next := 2
+		for i := 0; i < len(argv[1]); i++ {
+			c := argv[1][i]
+			words = append(words, "-"+string(c))
+			if tarArgTaking.has(c) && next < len(argv) {
+				words = append(words, argv[next])
+				next++
+			}
+		}
+		words = append(words, argv[next:]...)
+	}
+
+	archive := accessRead
+	for _, a := range words[1:] {
+		if a == "--" {
+			break
+		}
+		switch {
+		case !isFlagToken(a):
+		case strings.HasPrefix(a, "--"):
+			switch longFlagName(a) {
+			case "--create", "--append", "--update", "--concatenate":
+				archive = accessWrite
+			}
+		default:
+			for j := 1; j < len(a); j++ {
+				if strings.IndexByte("cruA", a[j]) >= 0 {
+					archive = accessWrite
+				}
+				if tarArgTaking.has(a[j]) {
+					break
+				}
+			}
+		}
+	}
+	return newPathRules(tarArgTaking, map[string]pathAccess{
+		"-f": archive, "--file": archive,
+		"-T": accessRead, "--files-from": accessRead,
+		"-X": accessRead, "--exclude-from": accessRead,
+	}).pathArgs(words)
+}
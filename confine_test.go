@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicySet_pathArgs(t *testing.T) {
+	set := newConfiguredPolicySet(map[string]ArgPolicySpec{
+		"jq": {
+			ShortFlags:    "r",
+			ArgShortFlags: "fL",
+			LongFlags:     []string{"--arg", "--from-file"},
+			PathFlags:     map[string]string{"-f": "read", "--from-file": "read", "-L": "none"},
+		},
+		"rsync": {
+			LongFlags: []string{"--dry-run"},
+			Operands:  "write",
+		},
+	})
+
+	read := func(p string) pathArg { return pathArg{path: p, access: accessRead} }
+	write := func(p string) pathArg { return pathArg{path: p, access: accessWrite} }
+
+	tests := []struct {
+		name string
+		argv []string
+		want []pathArg
+	}{
+		{
+			name: "cat operands",
+			argv: []string{"cat", "-n", "a.txt", "/etc/shadow"},
+			want: []pathArg{read("a.txt"), read("/etc/shadow")},
+		},
+		{
+			name: "operands after end of options",
+			argv: []string{"cat", "--", "-n"},
+			want: []pathArg{read("-n")},
+		},
+		{
+			name: "stdin marker is not a path",
+			argv: []string{"cat", "-"},
+		},
+		{
+			name: "head flag value is not a path",
+			argv: []string{"head", "-n", "5", "log.txt"},
+			want: []pathArg{read("log.txt")},
+		},
+		{
+			name: "grep pattern operand is skipped",
+			argv: []string{"grep", "-rn", "/etc", "src"},
+			want: []pathArg{read("src")},
+		},
+		{
+			name: "grep pattern from -e leaves every operand a path",
+			argv: []string{"grep", "-e", "token", "/home"},
+			want: []pathArg{read("/home")},
+		},
+		{
+			name: "grep pattern file",
+			argv: []string{"grep", "--file=/etc/shadow", "x"},
+			want: []pathArg{read("/etc/shadow"), read("x")},
+		},
+		{
+			name: "grep attached pattern file",
+			argv: []string{"grep", "-if/etc/shadow", "x"},
+			want: []pathArg{read("/etc/shadow"), read("x")},
+		},
+		{
+			name: "uniq output operand",
+			argv: []string{"uniq", "-c", "in.txt", "out.txt"},
+			want: []pathArg{read("in.txt"), write("out.txt")},
+		},
+		{
+			name: "echo has no paths",
+			argv: []string{"echo", "/etc/shadow"},
+		},
+		{
+			name: "date reference file",
+			argv: []string{"date", "-r", "/etc/passwd", "+%s"},
+			want: []pathArg{read("/etc/passwd")},
+		},
+		{
+			name: "sort output and key",
+			argv: []string{"sort", "-k", "2", "-o", "out.txt", "-t/", "in.txt"},
+			want: []pathArg{write("out.txt"), read("in.txt")},
+		},
+		{
+			name: "find starting points and comparison files",
+			argv: []string{"find", "-L", "src", "/etc", "-name", "*.go", "-newer", "ref", "-newermt", "2024-01-01"},
+			want: []pathArg{read("src"), read("/etc"), read("ref")},
+		},
+		{
+			name: "tar create writes the archive",
+			argv: []string{"tar", "-czf", "out.tgz", "src"},
+			want: []pathArg{write("out.tgz"), read("src")},
+		},
+		{
+			name: "tar bundled extract reads the archive",
+			argv: []string{"tar", "xzf", "/tmp/in.tgz", "member"},
+			want: []pathArg{read("/tmp/in.tgz"), read("member")},
+		},
+		{
+			name: "tar bundled values are taken in order",
+			argv: []string{"tar", "cfT", "out.tar", "list.txt"},
+			want: []pathArg{write("out.tar"), read("list.txt")},
+		},
+		{
+			name: "git operands",
+			argv: []string{"git", "diff", "--no-index", "/etc/shadow", "x"},
+			want: []pathArg{read("diff"), read("/etc/shadow"), read("x")},
+		},
+		{
+			name: "configured path flags",
+			argv: []string{"jq", "-L", "/usr/lib/jq", "-rf", "prog.jq", "--from-file=other.jq", "data.json"},
+			want: []pathArg{read("prog.jq"), read("other.jq"), read("data.json")},
+		},
+		{
+			name: "configured operand access",
+			argv: []string{"rsync", "--dry-run", "a", "b"},
+			want: []pathArg{write("a"), write("b")},
+		},
+		{
+			name: "unknown executable reads operands and long flag values",
+			argv: []string{"stat", "--format=%s", "-L", "/etc/shadow"},
+			want: []pathArg{read("%s"), read("/etc/shadow")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, set.pathArgs(tt.argv))
+		})
+	}
+}
+
+func TestPathConfinement_check(t *testing.T) {
+	base := t.TempDir()
+	work := filepath.Join(base, "work")
+	out := filepath.Join(work, "out")
+	shared := filepath.Join(base, "shared")
+	for _, dir := range []string{out, shared} {
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+	}
+	require.NoError(t, os.Symlink("/etc", filepath.Join(work, "etc")))
+	require.NoError(t, os.Symlink(filepath.Join(base, "missing"), filepath.Join(work, "dangling")))
+
+	c := newPathConfinement(SecurityConfig{
+		ConfinePaths:     true,
+		WorkingDirectory: work,
+		ReadableRoots:    []string{work, shared},
+		WritableRoots:    []string{out},
+	})
+
+	tests := []struct {
+		name          string
+		arg           pathArg
+		errorContains string
+	}{
+		{name: "relative read", arg: pathArg{"notes.txt", accessRead}},
+		{name: "read in another root", arg: pathArg{shared + "/data", accessRead}},
+		{name: "read in a writable root", arg: pathArg{"out/result", accessRead}},
+		{name: "write in a writable root", arg: pathArg{"out/new.txt", accessWrite}},
+		{
+			name:          "write outside writable roots",
+			arg:           pathArg{"notes.txt", accessWrite},
+			errorContains: `"notes.txt" resolves outside the writable roots`,
+		},
+		{
+			name:          "absolute read outside",
+			arg:           pathArg{"/etc/shadow", accessRead},
+			errorContains: "outside the readable roots",
+		},
+		{
+			name:          "dot-dot escape",
+			arg:           pathArg{"../../etc/passwd", accessRead},
+			errorContains: "outside the readable roots",
+		},
+		{
+			name:          "symlink escape",
+			arg:           pathArg{"etc/passwd", accessRead},
+			errorContains: "outside the readable roots",
+		},
+		{
+			name:          "dangling symlink",
+			arg:           pathArg{"dangling", accessRead},
+			errorContains: "dangling symlink",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.check(tt.arg)
+			if tt.errorContains == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+			}
+		})
+	}
+
+	assert.Nil(t, newPathConfinement(SecurityConfig{WorkingDirectory: work}))
+	assert.Equal(t, []string{work}, newPathConfinement(SecurityConfig{ConfinePaths: true, WorkingDirectory: work}).writable)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultPassthroughEnv is the built-in environment passthrough: enough for
+// tools to find each other, a home and a locale, and nothing like a token or
+// key the MCP client handed the server.
+var defaultPassthroughEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TERM", "TZ", "TMPDIR",
+}
+
+// childEnvironment returns the environment every child process starts from,
+// sorted by name: the passthrough variables the server has, then the fixed
+// values, then PATH when one is configured. It is nil when env is nil, meaning
+// children inherit the server's whole environment.
+func childEnvironment(env *EnvironmentConfig) []string {
+	if env == nil {
+		return nil
+	}
+	vars := make(map[string]string, len(env.Passthrough)+len(env.Set)+1)
+	for _, name := range env.Passthrough {
+		if value, ok := os.LookupEnv(name); ok {
+			vars[name] = value
+		}
+	}
+	for name, value := range env.Set {
+		vars[name] = value
+	}
+	if env.Path != "" {
+		vars["PATH"] = env.Path
+	}
+
+	out := make([]string, 0, len(vars))
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		out = append(out, name+"="+vars[name])
+	}
+	return out
+}
+
+// envNames returns the variable names of env, a NAME=value list.
+func envNames(env []string) []string {
+	names := make([]string, len(env))
+	for i, kv := range env {
+		names[i], _, _ = strings.Cut(kv, "=")
+	}
+	return names
+}
+
+// childPath returns the configured PATH children run with, or "" when they
+// use the server's.
+func (c SecurityConfig) childPath() string {
+	if c.Environment == nil {
+		return ""
+	}
+	return c.Environment.Path
+}
+
+// lookPath is exec.LookPath searching pathList instead of the server's PATH.
+// With an empty pathList, or a name containing a slash, it is exec.LookPath.
+func lookPath(name, pathList string) (string, error) {
+	if pathList == "" || strings.Contains(name, "/") {
+		return exec.LookPath(name)
+	}
+	for _, dir := range filepath.SplitList(pathList) {
+		if dir == "" {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0 {
+			return p, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// validateEnvironment checks the environment block of security.yaml.
+func validateEnvironment(env *EnvironmentConfig) error {
+	if env == nil {
+		return nil
+	}
+	for _, name := range env.Passthrough {
+		if !variableNameRe.MatchString(name) {
+			return fmt.Errorf("invalid environment.passthrough name: %q", name)
+		}
+	}
+	for name := range env.Set {
+		if !variableNameRe.MatchString(name) {
+			return fmt.Errorf("invalid environment.set name: %q", name)
+		}
+		if name == "PATH" {
+			return fmt.Errorf("environment.set cannot define PATH, use environment.path")
+		}
+	}
+	for _, dir := range filepath.SplitList(env.Path) {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("environment.path entry %q must be an absolute directory", dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChildEnvironment(t *testing.T) {
+	t.Setenv("MCP_SHELL_TEST_KEEP", "kept")
+	t.Setenv("MCP_SHELL_TEST_SECRET", "s3cret")
+	t.Setenv("TZ", "Europe/Madrid")
+
+	env := childEnvironment(&EnvironmentConfig{
+		Passthrough: []string{"MCP_SHELL_TEST_KEEP", "MCP_SHELL_TEST_UNSET", "TZ"},
+		Set:         map[string]string{"LANG": "C.UTF-8", "TZ": "UTC"},
+		Path:        "/usr/bin:/bin",
+	})
+	assert.Equal(t, []string{
+		"LANG=C.UTF-8",
+		"MCP_SHELL_TEST_KEEP=kept",
+		"PATH=/usr/bin:/bin",
+		"TZ=UTC",
+	}, env)
+	assert.Equal(t, []string{"LANG", "MCP_SHELL_TEST_KEEP", "PATH", "TZ"}, envNames(env))
+
+	// An empty block still controls the environment: nothing is inherited.
+	empty := childEnvironment(&EnvironmentConfig{})
+	assert.NotNil(t, empty)
+	assert.Empty(t, empty)
+
+	assert.Nil(t, childEnvironment(nil))
+}
+
+func TestLookPath(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(first, "tool"), []byte("data"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(second, "tool"), []byte("#!/bin/sh\n"), 0o755))
+
+	got, err := lookPath("tool", first+string(os.PathListSeparator)+second)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(second, "tool"), got, "non-executable files are skipped")
+
+	_, err = lookPath("ls", first)
+	assert.Error(t, err, "the server's PATH is not consulted")
+
+	got, err = lookPath("ls", "")
+	require.NoError(t, err)
+	assert.True(t, filepath.IsAbs(got))
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      *EnvironmentConfig
+		errorMsg string
+	}{
+		{name: "inherited", env: nil},
+		{
+			name: "valid",
+			env: &EnvironmentConfig{
+				Passthrough: []string{"HOME"},
+				Set:         map[string]string{"LANG": "C.UTF-8"},
+				Path:        "/usr/local/bin:/usr/bin:/bin",
+			},
+		},
+		{
+			name:     "bad passthrough name",
+			env:      &EnvironmentConfig{Passthrough: []string{"A=B"}},
+			errorMsg: `invalid environment.passthrough name: "A=B"`,
+		},
+		{
+			name:     "PATH under set",
+			env:      &EnvironmentConfig{Set: map[string]string{"PATH": "/bin"}},
+			errorMsg: "environment.set cannot define PATH, use environment.path",
+		},
+		{
+			name:     "relative PATH entry",
+			env:      &EnvironmentConfig{Path: "/usr/bin:bin"},
+			errorMsg: `environment.path entry "bin" must be an absolute directory`,
+		},
+		{
+			name:     "empty PATH entry",
+			env:      &EnvironmentConfig{Path: "/usr/bin::/bin"},
+			errorMsg: `environment.path entry "" must be an absolute directory`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnvironment(tt.env)
+			if tt.errorMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.errorMsg)
+			}
+		})
+	}
+}
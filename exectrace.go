@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// What a nested exec rejected by exec tracing does.
+const (
+	execTraceBlock = "block"
+	execTraceKill  = "kill"
+)
+
+// execTraceFd is the descriptor a traced stage's helper sends its seccomp
+// listener over: the first of cmd.ExtraFiles.
+const execTraceFd = 3
+
+// execTracer judges the execs a traced command's process tree makes after the
+// command itself started, with the same allowlist and argument policies its
+// top-level argv was judged by.
+type execTracer struct {
+	validator *SecurityValidator
+	kill      bool
+	logger    zerolog.Logger
+}
+
+func newExecTracer(cfg SecurityConfig, logger zerolog.Logger) *execTracer {
+	return &execTracer{
+		validator: &SecurityValidator{
+			config:   cfg,
+			logger:   logger.With().Str("component", "security").Logger(),
+			unfurler: newCommandUnfurler(cfg),
+			policies: newConfiguredPolicySet(cfg.ArgPolicies),
+			confine:  newPathConfinement(cfg),
+		},
+		kill:   cfg.ExecTracing.OnViolation == execTraceKill,
+		logger: logger,
+	}
+}
+
+// check judges an exec of path, absolute as the traced process sees it, with
+// argv, made in dir. same reports whether a path on the host is the file
+// being executed. An allowed_executables entry allows the exec when it is
+// that file: an absolute entry directly, a bare name when it resolves to it
+// through the children's PATH and has its basename. The argument policies
+// then see argv under the entry's name, whatever argv[0] the process chose.
+func (t *execTracer) check(path string, argv []string, dir string, same func(string) bool) error {
+	name := ""
+	for _, entry := range t.validator.config.AllowedExecutables {
+		candidate := entry
+		if !filepath.IsAbs(entry) {
+			if filepath.Base(path) != entry {
+				continue
+			}
+			found, err := lookPath(entry, t.validator.config.childPath())
+			if err != nil {
+				continue
+			}
+			candidate = found
+		}
+		if candidate == path || same(candidate) {
+			name = entry
+			break
+		}
+	}
+	if name == "" {
+		return reject(ruleAllowlist, fmt.Errorf("executable '%s' not in allowed list", path))
+	}
+	args := []string{name}
+	if len(argv) > 1 {
+		args = append(args, argv[1:]...)
+	}
+	return t.validator.in(dir).checkArgv(args)
+}
+
+// validateExecTracing checks the exec_tracing block of security.yaml.
+func validateExecTracing(c SecurityConfig) error {
+	switch c.ExecTracing.OnViolation {
+	case "", execTraceBlock, execTraceKill:
+	default:
+		return fmt.Errorf("exec_tracing.on_violation must be %s or %s, not %q", execTraceBlock, execTraceKill, c.ExecTracing.OnViolation)
+	}
+	if !c.ExecTracing.Enabled {
+		return nil
+	}
+	if !execTracingSupported {
+		return fmt.Errorf("exec_tracing requires Linux on amd64 or arm64")
+	}
+	if len(c.AllowedExecutables) == 0 {
+		return fmt.Errorf("exec_tracing requires allowed_executables")
+	}
+	return nil
+}
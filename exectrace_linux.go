@@ -0,0 +1,447 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const execTracingSupported = true
+
+// Caps on what is read of a traced exec's arguments, after the kernel's own
+// PATH_MAX, MAX_ARG_STRLEN and a typical ARG_MAX. An exec exceeding them is
+// rejected.
+const (
+	execTraceMaxPath  = 4096
+	execTraceMaxArg   = 128 << 10
+	execTraceMaxArgs  = 1 << 16
+	execTraceMaxTotal = 2 << 20
+)
+
+// seccompNotif and seccompNotifResp are struct seccomp_notif and struct
+// seccomp_notif_resp.
+type seccompNotif struct {
+	ID    uint64
+	Pid   uint32
+	Flags uint32
+	Nr    int32
+	Arch  uint32
+	IP    uint64
+	Args  [6]uint64
+}
+
+type seccompNotifResp struct {
+	ID    uint64
+	Val   int64
+	Error int32
+	Flags uint32
+}
+
+// installExecListener installs a seccomp filter on the calling thread that
+// suspends every execve and execveat it, and whatever it starts, makes until
+// the server answers, and sends the filter's listener to the server over
+// sock. Both descriptors are closed, so the command inherits neither. It sets
+// no_new_privs, which an unprivileged filter requires.
+func installExecListener(sock int) error {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		ret  = unix.BPF_RET | unix.BPF_K
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+	)
+	// Every check jumps to the notify at the very end, past the allow.
+	checks := []unix.SockFilter{
+		stmt(jeq, unix.SYS_EXECVE),
+		stmt(jeq, unix.SYS_EXECVEAT),
+	}
+	if seccompX32 {
+		// The server refuses x32 calls, whose numbers it does not match.
+		checks = append(checks, stmt(jge, 0x40000000))
+	}
+	for i := range checks {
+		checks[i].Jt = uint8(len(checks) - i)
+	}
+	prog := []unix.SockFilter{
+		stmt(load, seccompDataArch),
+		{Code: jeq, Jt: 1, K: seccompAuditArch},
+		stmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(load, seccompDataNr),
+	}
+	prog = append(prog, checks...)
+	prog = append(prog, stmt(ret, unix.SECCOMP_RET_ALLOW), stmt(ret, unix.SECCOMP_RET_USER_NOTIF))
+
+	defer unix.Close(sock)
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	fd, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_NEW_LISTENER, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return fmt.Errorf("install filter: %w", errno)
+	}
+	defer unix.Close(int(fd))
+	if err := unix.Sendmsg(sock, []byte{0}, unix.UnixRights(int(fd)), nil, 0); err != nil {
+		return fmt.Errorf("send listener: %w", err)
+	}
+	return nil
+}
+
+// execTrace is the server's side of one traced stage: it receives the
+// listener the stage's helper sends and answers every exec the stage's
+// process tree makes.
+type execTrace struct {
+	tracer *execTracer
+	// path is the stage's own executable, whose exec is not a nested one.
+	path       string
+	sock       int
+	child      *os.File
+	violations *nameSet
+	stopR      *os.File
+	stopW      *os.File
+	done       chan struct{}
+}
+
+// attach prepares cmd, not yet wrapped with a helper, to be traced: the
+// helper finds its end of the socket at execTraceFd. Rejected execs are
+// recorded in violations. The trace must be started once cmd has, and
+// stopped in any case.
+func (t *execTracer) attach(cmd *exec.Cmd, violations *nameSet) (*execTrace, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("create socket: %w", err)
+	}
+	stopR, stopW, err := os.Pipe()
+	if err != nil {
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+		return nil, fmt.Errorf("create pipe: %w", err)
+	}
+	path := cmd.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cmd.Dir, path)
+	}
+	trace := &execTrace{
+		tracer:     t,
+		path:       path,
+		sock:       fds[0],
+		child:      os.NewFile(uintptr(fds[1]), "exec-trace"),
+		violations: violations,
+		stopR:      stopR,
+		stopW:      stopW,
+		done:       make(chan struct{}),
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, trace.child)
+	return trace, nil
+}
+
+// start begins answering the execs of the stage, which leads process group
+// pgid.
+func (r *execTrace) start(pgid int) {
+	// Closing the helper's end here lets the supervisor see it go when the
+	// helper fails before it sends the listener.
+	r.child.Close()
+	go func() {
+		defer close(r.done)
+		r.supervise(pgid)
+	}()
+}
+
+// stop stops answering once the stage has exited. An exec a straggler makes
+// afterwards fails with ENOSYS. A trace that was never started is just
+// released.
+func (r *execTrace) stop(started bool) {
+	r.child.Close()
+	r.stopW.Close()
+	if started {
+		<-r.done
+	}
+	r.stopR.Close()
+	unix.Close(r.sock)
+}
+
+func (r *execTrace) supervise(pgid int) {
+	// The helper sends the listener before it runs the command, or closes
+	// its end without one when it fails first.
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(r.sock, make([]byte, 1), oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil || oobn == 0 {
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return
+	}
+	listener := fds[0]
+	defer unix.Close(listener)
+
+	first := true
+	for {
+		pfds := []unix.PollFd{
+			{Fd: int32(listener), Events: unix.POLLIN},
+			{Fd: int32(r.stopR.Fd()), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(pfds, -1); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return
+		}
+		if pfds[1].Revents != 0 || pfds[0].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+			return
+		}
+		var req seccompNotif
+		if err := notifIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_RECV, unsafe.Pointer(&req)); err != nil {
+			// The process died before it could be answered.
+			continue
+		}
+		if r.answer(listener, &req, pgid, first) {
+			first = false
+		}
+	}
+}
+
+// answer decides one exec and replies to it, reporting whether the reply
+// arrived: a signal makes the process withdraw the exec and make it again.
+// The stage's own exec, the first, is let through when it runs the stage's
+// executable; any other is checked and audit-logged.
+func (r *execTrace) answer(listener int, req *seccompNotif, pgid int, first bool) bool {
+	resp := seccompNotifResp{ID: req.ID, Flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}
+	exe, err := readExec(req)
+	threadsErr := checkSingleThreaded(int(req.Pid))
+	// The pid may have been reused if the process died while its memory was
+	// being read.
+	if idErr := notifIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_ID_VALID, unsafe.Pointer(&req.ID)); idErr != nil {
+		return false
+	}
+	if err == nil && first && exe.path == r.path {
+		return notifIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp)) == nil
+	}
+	if err == nil && exe.missing != 0 {
+		// Searching PATH tries every directory in turn. The attempt fails
+		// as it would have, without the kernel looking at the path again.
+		resp.Flags = 0
+		resp.Error = -int32(exe.missing)
+		return notifIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp)) == nil
+	}
+	if err == nil {
+		err = threadsErr
+	}
+	if err == nil {
+		err = r.tracer.check(exe.path, exe.argv, exe.dir, exe.same)
+	}
+
+	log := r.tracer.logger.Info()
+	decision := "allowed"
+	if err != nil {
+		resp.Flags = 0
+		resp.Error = -int32(unix.EPERM)
+		decision = execTraceBlock
+		if r.tracer.kill {
+			decision = execTraceKill
+		}
+		log = r.tracer.logger.Warn().Err(err)
+		path := exe.path
+		if path == "" {
+			path = "exec"
+		}
+		r.violations.add(path + ": " + err.Error())
+	}
+	log.
+		Int("pid", int(req.Pid)).
+		Str("path", exe.path).
+		Strs("argv", exe.argv).
+		Str("dir", exe.dir).
+		Str("decision", decision).
+		Str("audit", "nested_exec").
+		Msg("Nested exec")
+	if err != nil && r.tracer.kill {
+		// Killed before the exec fails, the tree cannot react to it.
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	return notifIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp)) == nil
+}
+
+func notifIoctl(fd int, req uint, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// checkSingleThreaded refuses an exec made by a process with other threads.
+// The kernel reads the path and argv again once the exec is let through, and
+// another thread could rewrite them after they were checked. The thread
+// making the exec is suspended, so a single thread stays single.
+func checkSingleThreaded(pid int) error {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if count, ok := strings.CutPrefix(line, "Threads:"); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil {
+				return fmt.Errorf("read thread count: %w", err)
+			}
+			if n > 1 {
+				return fmt.Errorf("exec from a process with %d threads, which could change it after the check", n)
+			}
+			return nil
+		}
+	}
+	return errors.New("read thread count: not reported")
+}
+
+// tracedExec is an exec as the traced process asked for it: the absolute
+// path, the argv and the directory it was made in. same reports whether a
+// host path is the file it executes, and missing why there is none.
+type tracedExec struct {
+	path    string
+	argv    []string
+	dir     string
+	same    func(string) bool
+	missing unix.Errno
+}
+
+// readExec reads the exec req suspended from the process's memory.
+func readExec(req *seccompNotif) (tracedExec, error) {
+	var exe tracedExec
+	proc := "/proc/" + strconv.Itoa(int(req.Pid))
+	dirfd, pathAddr, argvAddr, flags := int64(unix.AT_FDCWD), req.Args[0], req.Args[1], uint64(0)
+	switch req.Nr {
+	case unix.SYS_EXECVE:
+	case unix.SYS_EXECVEAT:
+		dirfd, pathAddr, argvAddr, flags = int64(int32(req.Args[0])), req.Args[1], req.Args[2], req.Args[4]
+	default:
+		return exe, fmt.Errorf("unexpected syscall %d", req.Nr)
+	}
+
+	mem, err := os.Open(proc + "/mem")
+	if err != nil {
+		return exe, err
+	}
+	defer mem.Close()
+	path, err := readCString(mem, pathAddr, execTraceMaxPath)
+	if err != nil {
+		return exe, fmt.Errorf("read path: %w", err)
+	}
+	if exe.argv, err = readArgv(mem, argvAddr); err != nil {
+		return exe, fmt.Errorf("read argv: %w", err)
+	}
+	if exe.dir, err = os.Readlink(proc + "/cwd"); err != nil {
+		return exe, err
+	}
+
+	base, target := exe.dir, ""
+	if dirfd != unix.AT_FDCWD {
+		fd := proc + "/fd/" + strconv.FormatInt(dirfd, 10)
+		if base, err = os.Readlink(fd); err != nil {
+			return exe, err
+		}
+		if path == "" && flags&unix.AT_EMPTY_PATH != 0 {
+			target = fd
+		}
+	}
+	switch {
+	case target != "":
+		exe.path = base
+	case filepath.IsAbs(path):
+		exe.path = filepath.Clean(path)
+	default:
+		exe.path = filepath.Join(base, path)
+	}
+	if target == "" {
+		target = proc + "/root" + exe.path
+	}
+	file, err := os.Stat(target)
+	if err != nil {
+		exe.missing = unix.ENOENT
+		errors.As(err, &exe.missing)
+	}
+	exe.same = func(candidate string) bool {
+		fi, err := os.Stat(candidate)
+		return err == nil && file != nil && os.SameFile(fi, file)
+	}
+	return exe, nil
+}
+
+// readArgv reads a NULL-terminated array of string pointers at addr.
+func readArgv(mem *os.File, addr uint64) ([]string, error) {
+	var argv []string
+	total := 0
+	ptr := make([]byte, 8)
+	for addr != 0 {
+		if len(argv) == execTraceMaxArgs {
+			return nil, errors.New("too many arguments")
+		}
+		if _, err := mem.ReadAt(ptr, int64(addr)); err != nil {
+			return nil, err
+		}
+		p := binary.LittleEndian.Uint64(ptr)
+		if p == 0 {
+			break
+		}
+		arg, err := readCString(mem, p, execTraceMaxArg)
+		if err != nil {
+			return nil, err
+		}
+		if total += len(arg) + 1; total > execTraceMaxTotal {
+			return nil, errors.New("arguments too long")
+		}
+		argv = append(argv, arg)
+		addr += 8
+	}
+	return argv, nil
+}
+
+// readCString reads the NUL-terminated string at addr, a page at a time so
+// that it never reads past the end of the mapping holding it.
+func readCString(mem *os.File, addr uint64, max int) (string, error) {
+	if addr == 0 {
+		return "", errors.New("null pointer")
+	}
+	page := uint64(os.Getpagesize())
+	var s strings.Builder
+	for s.Len() <= max {
+		chunk := make([]byte, page-addr%page)
+		if _, err := mem.ReadAt(chunk, int64(addr)); err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(chunk, 0); i >= 0 {
+			s.Write(chunk[:i])
+			if s.Len() > max {
+				break
+			}
+			return s.String(), nil
+		}
+		s.Write(chunk)
+		addr += uint64(len(chunk))
+	}
+	return "", errors.New("string too long")
+}
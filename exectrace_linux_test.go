@@ -0,0 +1,136 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandExecutor_execTracing(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+	workspace := t.TempDir()
+	cat, err := exec.LookPath("cat")
+	require.NoError(t, err)
+	find, err := exec.LookPath("find")
+	require.NoError(t, err)
+
+	newExecutor := func(shell bool, onViolation string) *CommandExecutor {
+		return newCommandExecutor(SecurityConfig{
+			Enabled:            true,
+			UseShellExecution:  shell,
+			AllowedCommands:    []string{"bash"},
+			AllowedExecutables: []string{"echo", "xargs", "find", "sleep"},
+			MaxExecutionTime:   5 * time.Second,
+			WorkingDirectory:   workspace,
+			ExecTracing:        ExecTracingConfig{Enabled: true, OnViolation: onViolation},
+		}, logger)
+	}
+
+	tests := []struct {
+		name           string
+		shell          bool
+		onViolation    string
+		command        string
+		wantStatus     string
+		wantStdout     string
+		wantStderr     string
+		wantViolations []string
+	}{
+		{
+			name:       "allowed nested exec",
+			command:    "echo hi | xargs echo nested",
+			wantStatus: "success",
+			wantStdout: "nested hi",
+		},
+		{
+			name:           "nested exec outside the allowlist is blocked",
+			command:        "echo hi | xargs cat",
+			wantStatus:     "exec_violation",
+			wantStderr:     "Operation not permitted",
+			wantViolations: []string{cat + ": executable '" + cat + "' not in allowed list"},
+		},
+		{
+			name:           "nested exec is checked against the argument policies",
+			command:        "echo . | xargs find -delete",
+			wantStatus:     "exec_violation",
+			wantViolations: []string{find + `: find: "-delete" is not allowed in secure mode`},
+		},
+		{
+			name:       "a shell's commands are checked",
+			shell:      true,
+			command:    "echo $(echo ok) && find . -maxdepth 0",
+			wantStatus: "success",
+			wantStdout: "ok\n.",
+		},
+		{
+			name:           "a shell's commands outside the allowlist are blocked",
+			shell:          true,
+			command:        "cat /etc/passwd; echo after",
+			wantStatus:     "exec_violation",
+			wantStdout:     "after",
+			wantStderr:     "Operation not permitted",
+			wantViolations: []string{cat + ": executable '" + cat + "' not in allowed list"},
+		},
+		{
+			name:           "kill stops the whole tree",
+			shell:          true,
+			onViolation:    "kill",
+			command:        "cat /etc/passwd; echo after",
+			wantStatus:     "exec_violation",
+			wantViolations: []string{cat + ": executable '" + cat + "' not in allowed list"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := execute(ctx, newExecutor(tt.shell, tt.onViolation), tt.command, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status, result.Stderr)
+			assert.Equal(t, tt.wantStdout, result.Stdout)
+			assert.Contains(t, result.Stderr, tt.wantStderr)
+			assert.Equal(t, tt.wantViolations, result.ExecViolations)
+		})
+	}
+
+	t.Run("combined with the sandbox", func(t *testing.T) {
+		requireUserNamespaces(t)
+		executor := newCommandExecutor(SecurityConfig{
+			Enabled:            true,
+			UseShellExecution:  true,
+			AllowedCommands:    []string{"bash"},
+			AllowedExecutables: []string{"echo"},
+			MaxExecutionTime:   5 * time.Second,
+			WorkingDirectory:   workspace,
+			Sandbox:            SandboxConfig{Enabled: true},
+			ExecTracing:        ExecTracingConfig{Enabled: true},
+		}, logger)
+		result, err := execute(ctx, executor, "echo $(echo ok); cat /etc/passwd", false)
+		require.NoError(t, err)
+		assert.Equal(t, "exec_violation", result.Status, result.Stderr)
+		assert.Equal(t, "ok", result.Stdout)
+		assert.Equal(t, []string{cat + ": executable '" + cat + "' not in allowed list"}, result.ExecViolations)
+	})
+}
+
+func TestCheckSingleThreaded(t *testing.T) {
+	// The Go runtime always runs more than one thread.
+	err := checkSingleThreaded(os.Getpid())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "threads, which could change it after the check")
+
+	sleep := exec.Command("sleep", "5")
+	require.NoError(t, sleep.Start())
+	t.Cleanup(func() {
+		sleep.Process.Kill()
+		sleep.Wait()
+	})
+	assert.NoError(t, checkSingleThreaded(sleep.Process.Pid))
+}
@@ -0,0 +1,27 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Exec tracing is built on seccomp user notification, and so for Linux on
+// amd64 and arm64 only; elsewhere enabling it is a configuration error.
+
+const execTracingSupported = false
+
+type execTrace struct{}
+
+func installExecListener(int) error {
+	return errors.New("requires Linux on amd64 or arm64")
+}
+
+func (*execTracer) attach(*exec.Cmd, *nameSet) (*execTrace, error) {
+	return nil, errors.New("requires Linux on amd64 or arm64")
+}
+
+func (*execTrace) start(int) {}
+
+func (*execTrace) stop(bool) {}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExecTracing(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SecurityConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{
+			name:    "unknown on_violation",
+			config:  SecurityConfig{ExecTracing: ExecTracingConfig{OnViolation: "ignore"}},
+			wantErr: `exec_tracing.on_violation must be block or kill, not "ignore"`,
+		},
+	}
+	if execTracingSupported {
+		tests = append(tests, []struct {
+			name    string
+			config  SecurityConfig
+			wantErr string
+		}{
+			{
+				name:   "enabled",
+				config: SecurityConfig{AllowedExecutables: []string{"ls"}, ExecTracing: ExecTracingConfig{Enabled: true, OnViolation: "kill"}},
+			},
+			{
+				name:    "no allowlist",
+				config:  SecurityConfig{ExecTracing: ExecTracingConfig{Enabled: true}},
+				wantErr: "exec_tracing requires allowed_executables",
+			},
+		}...)
+	} else {
+		tests = append(tests, struct {
+			name    string
+			config  SecurityConfig
+			wantErr string
+		}{
+			name:    "unsupported platform",
+			config:  SecurityConfig{AllowedExecutables: []string{"ls"}, ExecTracing: ExecTracingConfig{Enabled: true}},
+			wantErr: "exec_tracing requires Linux on amd64 or arm64",
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExecTracing(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecTracer_check(t *testing.T) {
+	tracer := newExecTracer(SecurityConfig{
+		AllowedExecutables: []string{"/opt/bin/tool", "/opt/bin/find", "/usr/bin/python3"},
+	}, zerolog.Nop())
+	linkedToTool := func(candidate string) bool { return candidate == "/opt/bin/tool" }
+	never := func(string) bool { return false }
+
+	tests := []struct {
+		name    string
+		path    string
+		argv    []string
+		same    func(string) bool
+		wantErr string
+	}{
+		{name: "allowed path", path: "/opt/bin/tool", argv: []string{"tool", "-v"}, same: never},
+		{name: "same file by another path", path: "/bin/tool", argv: []string{"tool"}, same: linkedToTool},
+		{name: "no argv", path: "/opt/bin/tool", same: never},
+		{
+			name:    "not allowed",
+			path:    "/usr/bin/curl",
+			argv:    []string{"curl"},
+			same:    never,
+			wantErr: "executable '/usr/bin/curl' not in allowed list",
+		},
+		{
+			name:    "argv[0] does not choose the executable",
+			path:    "/usr/bin/curl",
+			argv:    []string{"/opt/bin/tool"},
+			same:    never,
+			wantErr: "executable '/usr/bin/curl' not in allowed list",
+		},
+		{
+			name:    "argument policies apply",
+			path:    "/opt/bin/find",
+			argv:    []string{"find", ".", "-exec", "sh", ";"},
+			same:    never,
+			wantErr: `find: "-exec" is not allowed in secure mode`,
+		},
+		{
+			name:    "interpreters are refused",
+			path:    "/usr/bin/python3",
+			argv:    []string{"python3"},
+			same:    never,
+			wantErr: "executable '/usr/bin/python3' is an interpreter and cannot be allowed in secure mode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tracer.check(tt.path, tt.argv, "/tmp", tt.same)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
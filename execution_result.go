@@ -5,9 +5,46 @@ import "time"
 type ExecutionResult struct {
 	Status        string        `json:"status"`
 	ExitCode      int           `json:"exit_code"`
+	PipeStatus    []int         `json:"pipe_status,omitempty"`
 	Stdout        string        `json:"stdout"`
 	Stderr        string        `json:"stderr"`
 	Command       string        `json:"command"`
+	Argv          [][]string    `json:"argv,omitempty"`
 	ExecutionTime time.Duration `json:"execution_time"`
+	TimedOut      bool          `json:"timed_out"`
+	// Signal is the strongest signal sent to the command's process groups
+	// when it was timed out or cancelled, and ForceKilled whether members
+	// outlived the grace period after SIGTERM and were sent SIGKILL.
+	Signal      string `json:"signal,omitempty"`
+	ForceKilled bool   `json:"force_killed"`
+	// LimitsExceeded names the resource limits the command ran into:
+	// cpu_seconds or file_size when the kernel killed it for one, memory when
+	// the OOM killer did, pids when a fork was refused.
+	LimitsExceeded []string `json:"limits_exceeded,omitempty"`
+	// SeccompViolations names, as "executable: profile", the stages killed
+	// for a syscall their seccomp profile denies.
+	SeccompViolations []string `json:"seccomp_violations,omitempty"`
+	// ExecViolations are the execs, made by the command's process tree after
+	// it started, that exec tracing rejected, each with the reason.
+	ExecViolations []string `json:"exec_violations,omitempty"`
+	// Truncated reports that stdout or stderr exceeded max_output_size and
+	// only its start and end were kept. The byte counts are what the command
+	// wrote, and how much of it was dropped.
+	Truncated     bool          `json:"truncated"`
+	StdoutBytes   int64         `json:"stdout_bytes"`
+	StderrBytes   int64         `json:"stderr_bytes"`
+	StdoutDropped int64         `json:"stdout_dropped"`
+	StderrDropped int64         `json:"stderr_dropped"`
+	Steps         []StepResult  `json:"steps,omitempty"`
 	SecurityInfo  *SecurityInfo `json:"security_info,omitempty"`
 }
+
+// StepResult is the outcome of one executed step of a command list. Steps a
+// short-circuit skipped are not recorded.
+type StepResult struct {
+	Op         string        `json:"op,omitempty"`
+	Argv       [][]string    `json:"argv"`
+	ExitCode   int           `json:"exit_code"`
+	PipeStatus []int         `json:"pipe_status,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
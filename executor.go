@@ -1,136 +1,567 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
-	"os/user"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 type CommandExecutor struct {
-	config   SecurityConfig
-	logger   zerolog.Logger
-	unfurler *commandUnfurler
+	config SecurityConfig
+	logger zerolog.Logger
+	pins   *executablePins
+	// dir is the per-call directory commands run in, inside the working
+	// directory; empty means the working directory itself.
+	dir string
+	// timeout is the per-call timeout requested; zero means the default.
+	timeout time.Duration
+	// helper is the server's own binary, which applies the rlimits, Landlock,
+	// seccomp, exec tracing and the sandbox when configured, or helperErr why
+	// it could not be found.
+	helper    string
+	helperErr error
+	cgroups   *cgroupManager
+	// landlockABI is the Landlock ABI the kernel supports, zero if none.
+	landlockABI int
+	// seccomp resolves the seccomp profile of each executable, or
+	// seccompErr why the configured profiles could not be resolved.
+	seccomp    *seccompPolicy
+	seccompErr error
+	// tracer checks the nested execs of every command when exec tracing is
+	// enabled.
+	tracer *execTracer
+	// identity is what commands run as under privileges, or identityErr why
+	// the server cannot switch them to it.
+	identity    identity
+	identityErr error
+	// backend names the backend e runs commands on, and remote is the host
+	// the ssh backend runs them on.
+	backend string
+	remote  *sshRemote
 }
 
 func newCommandExecutor(cfg SecurityConfig, logger zerolog.Logger) *CommandExecutor {
-	return &CommandExecutor{
-		config:   cfg,
-		logger:   logger.With().Str("component", "executor").Logger(),
-		unfurler: newCommandUnfurler(),
+	e := &CommandExecutor{
+		config:  cfg,
+		logger:  logger.With().Str("component", "executor").Logger(),
+		backend: backendLocal,
 	}
+	if cfg.Sandbox.Enabled {
+		e.backend = backendSandbox
+	}
+	// Secure mode runs allowlisted executables only, each from the path it
+	// resolved to at startup.
+	if cfg.Enabled && !cfg.UseShellExecution {
+		e.pins = newExecutablePins(cfg, e.logger)
+	}
+	if cfg.Limits.rlimitSpec() != "" || cfg.Sandbox.Enabled || cfg.Landlock.Enabled || cfg.Seccomp.enabled() ||
+		cfg.ExecTracing.Enabled || cfg.Privileges.Enabled {
+		e.helper, e.helperErr = os.Executable()
+	}
+	if cfg.Privileges.Enabled {
+		e.identity, e.identityErr = resolveIdentity(cfg.RunAsUser, cfg.Privileges.Groups)
+		if e.identityErr == nil {
+			e.identityErr = checkIdentitySwitch(e.identity)
+		}
+		if e.identityErr != nil {
+			e.logger.Error().Err(e.identityErr).Msg("Commands cannot be switched to the configured identity - every command will be refused")
+		} else {
+			e.logger.Info().
+				Int("uid", e.identity.uid).
+				Int("gid", e.identity.gid).
+				Ints("groups", e.identity.groups).
+				Msg("Commands run without capabilities and with no_new_privs")
+		}
+	}
+	if cfg.ExecTracing.Enabled {
+		e.tracer = newExecTracer(cfg, e.logger)
+	}
+	e.seccomp, e.seccompErr = newSeccompPolicy(cfg.Seccomp)
+	if cfg.Landlock.Enabled {
+		e.landlockABI = landlockABI()
+		switch {
+		case e.landlockABI > 0:
+			e.logger.Info().Int("abi", e.landlockABI).Msg("Landlock filesystem restrictions enabled")
+		case cfg.Landlock.Unsupported == landlockWarn:
+			e.logger.Warn().Msg("Landlock is not supported by this kernel - commands run without filesystem restrictions")
+		default:
+			e.logger.Error().Msg("Landlock is not supported by this kernel - every command will be refused")
+		}
+	}
+	if len(cfg.Limits.cgroupControllers()) > 0 {
+		cgroups, err := newCgroupManager(cfg.Limits)
+		if err != nil {
+			e.logger.Warn().
+				Err(err).
+				Msg("no delegated cgroup v2 subtree available - the memory, pids and cpu limits are not enforced")
+		} else {
+			e.cgroups = cgroups
+		}
+	}
+	if cfg.Enabled && cfg.Environment == nil {
+		e.logger.Warn().Msg("environment.inherit is set - child processes inherit the full server environment, including any secrets passed to it")
+	}
+	return e
+}
+
+// in returns a copy of e that runs commands in dir, an absolute directory
+// inside the working directory, as resolved by resolveWorkDir.
+func (e *CommandExecutor) in(dir string) Executor {
+	c := *e
+	c.dir = dir
+	return &c
+}
+
+// withTimeout returns a copy of e whose executions run under the requested
+// timeout, capped at max_timeout.
+func (e *CommandExecutor) withTimeout(timeout time.Duration) Executor {
+	c := *e
+	c.timeout = timeout
+	return &c
+}
+
+// sandboxed returns a copy of e whose commands run in the namespace sandbox,
+// or outside it.
+func (e *CommandExecutor) sandboxed(on bool) *CommandExecutor {
+	c := *e
+	c.config.Sandbox.Enabled = on
+	c.backend = backendLocal
+	if on {
+		c.backend = backendSandbox
+	}
+	return &c
+}
+
+// executionTimeout is the timeout an execution runs under: the requested one
+// capped at max_timeout, or else max_execution_time (30s when unset).
+// max_timeout defaults to the default timeout, so by default a call can only
+// lower it.
+func (e *CommandExecutor) executionTimeout() time.Duration {
+	timeout := 30 * time.Second
+	if e.config.MaxExecutionTime > 0 {
+		timeout = e.config.MaxExecutionTime
+	}
+	if e.timeout <= 0 {
+		return timeout
+	}
+	limit := timeout
+	if e.config.MaxTimeout > 0 {
+		limit = e.config.MaxTimeout
+	}
+	return min(e.timeout, limit)
+}
+
+// workDir is the directory commands run in: the per-call directory, or else
+// the configured working directory.
+func (e *CommandExecutor) workDir() string {
+	if e.dir != "" {
+		return e.dir
+	}
+	return e.config.WorkingDirectory
+}
+
+func (e *CommandExecutor) executeValidated(
+	ctx context.Context,
+	command string,
+	plan *execPlan,
+	useBase64 bool,
+) (*ExecutionResult, error) {
+	return e.executeTimed(ctx, command, useBase64, func(ctx context.Context) (*ExecutionResult, error) {
+		return e.runValidated(ctx, command, plan, useBase64)
+	})
+}
+
+// executeArgv runs a structured argv as a single command, with no parsing and
+// no shell, even in legacy mode. The argv must already have been validated.
+func (e *CommandExecutor) executeArgv(
+	ctx context.Context,
+	argv []string,
+	useBase64 bool,
+) (*ExecutionResult, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("argv must not be empty")
+	}
+	command := quoteArgv(argv)
+	return e.executeTimed(ctx, command, useBase64, func(ctx context.Context) (*ExecutionResult, error) {
+		plan := &execPlan{Steps: []*planStep{{
+			Pipeline: &pipelinePlan{Stages: []*plannedCommand{{Argv: argv}}},
+		}}}
+		return e.executePlan(ctx, plan, command, useBase64, true)
+	})
 }
 
-func (e *CommandExecutor) execute(
+// executeTimed applies the execution timeout around run and completes its
+// result with timing and security metadata. command is only used for logging.
+func (e *CommandExecutor) executeTimed(
 	ctx context.Context,
 	command string,
 	useBase64 bool,
+	run func(ctx context.Context) (*ExecutionResult, error),
 ) (*ExecutionResult, error) {
 	start := time.Now()
 
+	timeout := e.executionTimeout()
+
 	e.logger.Info().
 		Str("command", command).
 		Bool("base64", useBase64).
+		Dur("timeout", timeout).
 		Msg("Executing command")
 
-	timeout := 30 * time.Second
-	if e.config.MaxExecutionTime > 0 {
-		timeout = e.config.MaxExecutionTime
-	}
-
 	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	result, err := e.executeSecureCommand(cmdCtx, command, useBase64)
+	result, err := run(cmdCtx)
 	if err != nil {
 		return nil, err
 	}
 
 	result.ExecutionTime = time.Since(start)
+	result.TimedOut = errors.Is(cmdCtx.Err(), context.DeadlineExceeded)
 	result.SecurityInfo = &SecurityInfo{
 		SecurityEnabled: e.config.Enabled,
 		TimeoutApplied:  true,
+		Timeout:         timeout.String(),
 	}
 
-	if e.config.WorkingDirectory != "" {
-		result.SecurityInfo.WorkingDir = e.config.WorkingDirectory
+	if dir := e.workDir(); dir != "" {
+		result.SecurityInfo.WorkingDir = dir
+	}
+	result.SecurityInfo.Backend = e.backend
+	if e.remote != nil {
+		result.SecurityInfo.WorkingDir = e.remote.remoteDir(e.workDir())
 	}
 	if e.config.RunAsUser != "" {
 		result.SecurityInfo.RunAsUser = e.config.RunAsUser
 	}
+	if env := childEnvironment(e.config.Environment); env != nil {
+		result.SecurityInfo.Environment = envNames(env)
+	}
+	result.SecurityInfo.Sandboxed = e.config.Sandbox.Enabled
+	// What a remote command runs with is up to the remote host.
+	if e.remote == nil {
+		result.SecurityInfo.Credentials = e.credentials()
+	}
+	if e.config.Landlock.Enabled {
+		result.SecurityInfo.LandlockABI = e.landlockABI
+	}
 
 	e.logger.Info().
 		Str("command", command).
 		Str("status", result.Status).
 		Int("exit_code", result.ExitCode).
+		Bool("timed_out", result.TimedOut).
 		Dur("execution_time", result.ExecutionTime).
 		Msg("Command execution completed")
 
 	return result, nil
 }
 
-func (e *CommandExecutor) executeSecureCommand(
+// runValidated runs plan or, in legacy shell mode, command through bash.
+func (e *CommandExecutor) runValidated(
 	ctx context.Context,
 	command string,
+	plan *execPlan,
 	useBase64 bool,
 ) (*ExecutionResult, error) {
-	var cmd *exec.Cmd
-
 	// Use secure execution unless legacy shell mode is explicitly enabled
 	if e.config.UseShellExecution {
 		e.logger.Warn().
 			Str("command", command).
 			Msg("Using legacy shell execution mode - vulnerable to injection attacks")
-		cmd = exec.CommandContext(ctx, "bash", "-c", command)
+		plan = &execPlan{Steps: []*planStep{{
+			Pipeline: &pipelinePlan{Stages: []*plannedCommand{{Argv: []string{"bash", "-c", command}}}},
+		}}}
+	} else if plan == nil {
+		return nil, errors.New("command has no validated plan to run")
+	}
+
+	return e.executePlan(ctx, plan, command, useBase64, !e.config.UseShellExecution)
+}
+
+// executePlan runs plan and assembles its result. echoArgv reports the argv of
+// a single-step plan back as the result's Argv; it is off for the legacy bash
+// wrapper, whose argv says nothing the command does not.
+func (e *CommandExecutor) executePlan(
+	ctx context.Context,
+	plan *execPlan,
+	command string,
+	useBase64 bool,
+	echoArgv bool,
+) (*ExecutionResult, error) {
+	if err := e.pinPlan(plan); err != nil {
+		return nil, err
+	}
+	if e.remote != nil {
+		if err := e.remote.check(plan); err != nil {
+			return nil, err
+		}
+	}
+	setup, err := e.processSetup()
+	if err != nil {
+		return nil, err
+	}
+	defer setup.cgroup.remove()
+
+	// Each stream keeps at most max_output_size bytes, its start and its
+	// end; whatever a command writes beyond that is counted and dropped.
+	tail := e.config.OutputTailSize
+	if tail <= 0 {
+		tail = e.config.MaxOutputSize / 2
+	}
+	stdoutBuf := newCappedBuffer(e.config.MaxOutputSize, tail)
+	stderrBuf := newCappedBuffer(e.config.MaxOutputSize, tail)
+	steps, err := e.runPlan(ctx, plan, setup, stdoutBuf, &lockedWriter{w: stderrBuf})
+	if err != nil {
+		return nil, err
+	}
+
+	last := steps[len(steps)-1]
+	exitCode := last.ExitCode
+	status := "success"
+	if exitCode != 0 {
+		status = "error"
+	}
+
+	var stdout, stderr string
+	if useBase64 {
+		stdout = base64.StdEncoding.EncodeToString(stdoutBuf.Bytes())
+		stderr = base64.StdEncoding.EncodeToString(stderrBuf.Bytes())
 	} else {
-		// Secure execution: parse the command into a literal argv and execute it
-		// directly, using the same unfurler the validator used.
-		res := e.unfurler.unfurl(command)
-		if !res.Allowed {
-			return nil, fmt.Errorf("command parsing failed: %s", res.Reason)
+		stdout = strings.TrimRight(stdoutBuf.String(), "\n")
+		stderr = strings.TrimRight(stderrBuf.String(), "\n")
+	}
+
+	result := &ExecutionResult{
+		Status:        status,
+		ExitCode:      exitCode,
+		Stdout:        stdout,
+		Stderr:        stderr,
+		Command:       command,
+		Truncated:     stdoutBuf.dropped() > 0 || stderrBuf.dropped() > 0,
+		StdoutBytes:   stdoutBuf.written(),
+		StderrBytes:   stderrBuf.written(),
+		StdoutDropped: stdoutBuf.dropped(),
+		StderrDropped: stderrBuf.dropped(),
+	}
+	if setup.cgroup != nil {
+		for _, name := range setup.cgroup.exceeded() {
+			setup.exceeded.add(name)
+		}
+	}
+	if exceeded := setup.exceeded.list(); len(exceeded) > 0 {
+		result.LimitsExceeded = exceeded
+		e.logger.Warn().
+			Str("command", command).
+			Strs("limits", exceeded).
+			Msg("Command ran into its resource limits")
+	}
+	if violations := setup.violations.list(); len(violations) > 0 {
+		result.Status = "seccomp_violation"
+		result.SeccompViolations = violations
+		e.logger.Warn().
+			Str("command", command).
+			Strs("violations", violations).
+			Str("audit", "seccomp_violation").
+			Msg("Command was killed for a syscall its seccomp profile denies")
+	}
+	if violations := setup.execViolations.list(); len(violations) > 0 {
+		result.Status = "exec_violation"
+		result.ExecViolations = violations
+	}
+	if sig, forced := setup.term.outcome(); sig != 0 {
+		result.Signal = signalName(sig)
+		result.ForceKilled = forced
+		e.logger.Warn().
+			Str("command", command).
+			Str("signal", result.Signal).
+			Bool("force_killed", forced).
+			Msg("Command was stopped before it finished")
+	}
+	if result.Truncated {
+		e.logger.Warn().
+			Str("command", command).
+			Int64("stdout_dropped", result.StdoutDropped).
+			Int64("stderr_dropped", result.StderrDropped).
+			Msg("Command output exceeded max_output_size and was truncated")
+	}
+	if len(plan.Steps) > 1 {
+		result.Steps = steps
+	} else {
+		result.PipeStatus = last.PipeStatus
+		if echoArgv {
+			result.Argv = last.Argv
 		}
+	}
+	return result, nil
+}
 
-		e.logger.Debug().
-			Str("executable", res.Argv[0]).
-			Strs("args", res.Argv[1:]).
-			Msg("Executing command with direct execution")
+// pinPlan points every command of plan at its pinned executable. The whole
+// plan is refused, with an audit event, if any executable is not pinned or
+// has been replaced since startup.
+func (e *CommandExecutor) pinPlan(plan *execPlan) error {
+	for _, cmd := range plan.commands() {
+		path, err := e.pinned(cmd.Argv[0])
+		if err != nil {
+			if e.pins != nil {
+				e.logger.Error().
+					Err(err).
+					Str("executable", cmd.Argv[0]).
+					Str("audit", "executable_refused").
+					Msg("Refusing to run executable")
+			}
+			return err
+		}
+		if path != "" {
+			cmd.Path = path
+		}
+	}
+	return nil
+}
+
+// pinned returns the path executable runs from: its pin, checked against
+// the file seen at startup. Without pins (legacy or disabled mode), a bare
+// name is still resolved against a configured environment.path rather than
+// the server's PATH, and otherwise left to exec.
+func (e *CommandExecutor) pinned(executable string) (string, error) {
+	if e.pins == nil {
+		pathList := e.config.childPath()
+		if pathList == "" || strings.Contains(executable, "/") {
+			return "", nil
+		}
+		path, err := lookPath(executable, pathList)
+		if err != nil {
+			return "", fmt.Errorf("executable '%s' not found in environment.path", executable)
+		}
+		return path, nil
+	}
+	pin, ok := e.pins.lookup(executable)
+	if !ok {
+		return "", fmt.Errorf("executable '%s' not in allowed list", executable)
+	}
+	if err := pin.verify(); err != nil {
+		return "", err
+	}
+	return pin.path, nil
+}
+
+// quoteArgv renders an argv as the equivalent shell command, for logs and the
+// result's command field.
+func quoteArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		q, err := syntax.Quote(arg, syntax.LangBash)
+		if err != nil {
+			// Only invalid UTF-8 or NUL bytes cannot be quoted.
+			q = strconv.Quote(arg)
+		}
+		quoted[i] = q
+	}
+	return strings.Join(quoted, " ")
+}
 
-		cmd = exec.CommandContext(ctx, res.Argv[0], res.Argv[1:]...)
+// runPlan evaluates a plan's steps in order against the previous step's exit
+// code: && runs only after success, || only after failure, ; always. Every
+// executed step is recorded; the first step always runs, later ones stop being
+// started once the context is done.
+func (e *CommandExecutor) runPlan(
+	ctx context.Context,
+	plan *execPlan,
+	setup processSetup,
+	stdout, stderr io.Writer,
+) ([]StepResult, error) {
+	var results []StepResult
+	lastExit := 0
+
+	for _, step := range plan.Steps {
+		if len(results) > 0 && ctx.Err() != nil {
+			break
+		}
+		switch step.Op {
+		case opAnd:
+			if lastExit != 0 {
+				continue
+			}
+		case opOr:
+			if lastExit == 0 {
+				continue
+			}
+		}
+
+		argvs := step.Pipeline.argvs()
+		for _, argv := range argvs {
+			e.logger.Debug().
+				Str("executable", argv[0]).
+				Strs("args", argv[1:]).
+				Msg("Executing command with direct execution")
+		}
+
+		start := time.Now()
+		pipeStatus, err := runPipeline(ctx, step.Pipeline.Stages, setup, stdout, stderr)
+		if err != nil {
+			return nil, err
+		}
+		lastExit = pipelineExitCode(pipeStatus, e.config.Pipefail)
+
+		sr := StepResult{
+			Op:       string(step.Op),
+			Argv:     argvs,
+			ExitCode: lastExit,
+			Duration: time.Since(start),
+		}
+		if len(pipeStatus) > 1 {
+			sr.PipeStatus = pipeStatus
+		}
+		results = append(results, sr)
+	}
+
+	return results, nil
+}
+
+// processSetup prepares the process context applied to every stage: it creates
+// the working directory, resolves run_as_user into credentials, prepares the
+// rlimits, Landlock ruleset, seccomp profiles, exec tracing and sandbox when
+// configured, or the ssh client on the ssh backend, and creates the
+// execution's cgroup, which the caller must remove. Any of it failing aborts the execution rather than silently running
+// unconfined.
+func (e *CommandExecutor) processSetup() (processSetup, error) {
+	setup := processSetup{
+		env:            childEnvironment(e.config.Environment),
+		term:           newGroupTermination(e.config.KillGracePeriod),
+		exceeded:       &nameSet{},
+		violations:     &nameSet{},
+		execViolations: &nameSet{},
 	}
 
 	if e.config.WorkingDirectory != "" {
 		if err := os.MkdirAll(e.config.WorkingDirectory, 0o755); err != nil {
-			return nil, fmt.Errorf("create working directory %q: %w", e.config.WorkingDirectory, err)
+			return setup, fmt.Errorf("create working directory %q: %w", e.config.WorkingDirectory, err)
 		}
-		cmd.Dir = e.config.WorkingDirectory
+		setup.dir = e.workDir()
+		setup.root = e.config.WorkingDirectory
 		e.logger.Debug().
-			Str("working_dir", e.config.WorkingDirectory).
+			Str("working_dir", setup.dir).
 			Msg("Set working directory")
 	}
 
-	if e.config.RunAsUser != "" {
-		u, err := user.Lookup(e.config.RunAsUser)
+	// Under privileges, the helper switches identity itself, after it has
+	// used the server's privileges to drop the rest.
+	if e.config.RunAsUser != "" && !e.config.Privileges.Enabled {
+		uid, gid, err := lookupRunAsUser(e.config.RunAsUser)
 		if err != nil {
-			return nil, fmt.Errorf("resolve run-as user %q: %w", e.config.RunAsUser, err)
+			return setup, err
 		}
-		uid, err := strconv.Atoi(u.Uid)
-		if err != nil {
-			return nil, fmt.Errorf("resolve run-as user %q: parse uid %q: %w", e.config.RunAsUser, u.Uid, err)
-		}
-		gid, err := strconv.Atoi(u.Gid)
-		if err != nil {
-			return nil, fmt.Errorf("resolve run-as user %q: parse gid %q: %w", e.config.RunAsUser, u.Gid, err)
-		}
-		cmd.SysProcAttr = &syscall.SysProcAttr{
+		setup.attr = &syscall.SysProcAttr{
 			Credential: &syscall.Credential{
 				Uid: uint32(uid),
 				Gid: uint32(gid),
@@ -143,46 +574,67 @@ func (e *CommandExecutor) executeSecureCommand(
 			Msg("Set process credentials")
 	}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-
-	err := cmd.Run()
-
-	if e.config.MaxOutputSize > 0 {
-		if stdoutBuf.Len() > e.config.MaxOutputSize {
-			return nil, fmt.Errorf("stdout exceeds maximum size limit")
+	child := childSpec{Rlimits: e.config.Limits.rlimitSpec()}
+	if e.config.Landlock.Enabled && e.landlockABI > 0 {
+		child.Landlock = e.config.Landlock.rules(e.landlockABI, e.config.WorkingDirectory)
+	} else if e.config.Landlock.Enabled && e.config.Landlock.Unsupported != landlockWarn {
+		return setup, fmt.Errorf("landlock is not supported by this kernel; set landlock.unsupported: %s to run commands without it", landlockWarn)
+	}
+	if e.seccompErr != nil {
+		return setup, fmt.Errorf("seccomp: %w", e.seccompErr)
+	}
+	if e.config.Privileges.Enabled {
+		if e.identityErr != nil {
+			return setup, fmt.Errorf("privileges: %w", e.identityErr)
 		}
-		if stderrBuf.Len() > e.config.MaxOutputSize {
-			return nil, fmt.Errorf("stderr exceeds maximum size limit")
+		// In the sandbox, the user namespace's mapping is the switch.
+		child.Privileges = &privilegeSpec{
+			Switch: !e.config.Sandbox.Enabled,
+			Uid:    e.identity.uid,
+			Gid:    e.identity.gid,
+			Groups: e.identity.groups,
+		}
+	}
+	if (!child.empty() || e.seccomp != nil || e.tracer != nil || e.config.Sandbox.Enabled) && e.helperErr != nil {
+		return setup, fmt.Errorf("locate the helper binary: %w", e.helperErr)
+	}
+	setup.helper, setup.child, setup.seccomp, setup.trace = e.helper, child, e.seccomp, e.tracer
+	if e.remote != nil {
+		if e.remote.clientErr != nil {
+			return setup, fmt.Errorf("ssh backend: locate the ssh client: %w", e.remote.clientErr)
 		}
+		setup.remote = e.remote
 	}
 
-	exitCode := 0
-	status := "success"
-	if err != nil {
-		status = "error"
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
-		} else {
-			exitCode = -1
+	if e.config.Sandbox.Enabled {
+		setup.sandbox = &sandboxSpec{
+			Workspace: setup.root,
+			Dir:       setup.dir,
+			ReadOnly:  e.config.Sandbox.readOnlyPaths(),
+		}
+		if setup.attr == nil {
+			setup.attr = &syscall.SysProcAttr{}
 		}
+		// The user namespace maps its root to the run-as user, who then
+		// owns everything the command does on the host.
+		uid, gid := os.Getuid(), os.Getgid()
+		if cred := setup.attr.Credential; cred != nil {
+			uid, gid = int(cred.Uid), int(cred.Gid)
+			setup.attr.Credential = nil
+		} else if e.config.Privileges.Enabled {
+			uid, gid = e.identity.uid, e.identity.gid
+		}
+		sandboxNamespaces(setup.attr, e.config.Sandbox.Network, uid, gid)
 	}
 
-	var stdout, stderr string
-	if useBase64 {
-		stdout = base64.StdEncoding.EncodeToString(stdoutBuf.Bytes())
-		stderr = base64.StdEncoding.EncodeToString(stderrBuf.Bytes())
-	} else {
-		stdout = strings.TrimRight(stdoutBuf.String(), "\n")
-		stderr = strings.TrimRight(stderrBuf.String(), "\n")
+	// Last, so no earlier failure leaves the cgroup behind.
+	if e.cgroups != nil {
+		cgroup, err := e.cgroups.create()
+		if err != nil {
+			return setup, err
+		}
+		setup.cgroup = cgroup
 	}
 
-	return &ExecutionResult{
-		Status:   status,
-		ExitCode: exitCode,
-		Stdout:   stdout,
-		Stderr:   stderr,
-		Command:  command,
-	}, nil
+	return setup, nil
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,7 +15,31 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestCommandExecutor_executeSecureCommand_secure_vs_legacy(t *testing.T) {
+// execute parses command the way the validator does and runs the plan, for
+// tests about running commands rather than judging them. A router's commands
+// are parsed as its default backend would.
+func execute(ctx context.Context, e Executor, command string, useBase64 bool) (*ExecutionResult, error) {
+	base := e
+	if router, ok := e.(*backendRouter); ok {
+		base = router.backends[router.config.defaultBackend()]
+	}
+	c := base.(*CommandExecutor)
+	var plan *execPlan
+	if !c.config.UseShellExecution {
+		unfurler := newCommandUnfurler(c.config)
+		if c.dir != "" {
+			unfurler = unfurler.in(c.dir)
+		}
+		res := unfurler.unfurl(command)
+		if !res.Allowed {
+			return nil, fmt.Errorf("command parsing failed: %s", res.Reason)
+		}
+		plan = res.Plan
+	}
+	return e.executeValidated(ctx, command, plan, useBase64)
+}
+
+func TestCommandExecutor_secure_vs_legacy(t *testing.T) {
 	logger := zerolog.New(zerolog.NewTestWriter(t))
 	ctx := context.Background()
 
@@ -36,9 +63,15 @@ func TestCommandExecutor_executeSecureCommand_secure_vs_legacy(t *testing.T) {
 			expectError:       false,
 		},
 		{
-			name:              "command with pipe - secure mode blocks",
+			name:              "command with pipe - secure mode pipes in Go",
 			command:           "echo hello | cat",
 			useShellExecution: false,
+			expectError:       false,
+		},
+		{
+			name:              "command with stderr pipe - secure mode blocks",
+			command:           "echo hello |& cat",
+			useShellExecution: false,
 			expectError:       true,
 			errorContains:     "command parsing failed",
 		},
@@ -71,7 +104,7 @@ func TestCommandExecutor_executeSecureCommand_secure_vs_legacy(t *testing.T) {
 			}
 			executor := newCommandExecutor(config, logger)
 
-			result, err := executor.executeSecureCommand(ctx, tt.command, false)
+			result, err := execute(ctx, executor, tt.command, false)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -104,14 +137,14 @@ func TestCommandExecutor_vulnerability_prevention(t *testing.T) {
 			description: "Command substitution to reconstruct 'chmod' command",
 		},
 		{
-			name:        "command injection via semicolon",
-			command:     "ls; rm -rf /",
-			description: "Command separator to execute dangerous command",
+			name:        "command injection via semicolon into subshell",
+			command:     "ls; (rm -rf /)",
+			description: "Command separator to execute dangerous command in a subshell",
 		},
 		{
-			name:        "command injection via pipe",
-			command:     "echo safe | rm -rf /",
-			description: "Pipe to execute dangerous command",
+			name:        "command injection via pipe into subshell",
+			command:     "echo safe | (rm -rf /)",
+			description: "Pipe into a subshell to execute dangerous command",
 		},
 		{
 			name:        "command injection via background",
@@ -140,7 +173,7 @@ func TestCommandExecutor_vulnerability_prevention(t *testing.T) {
 
 		for _, vt := range vulnerabilityTests {
 			t.Run(vt.name, func(t *testing.T) {
-				_, err := executor.executeSecureCommand(ctx, vt.command, false)
+				_, err := execute(ctx, executor, vt.command, false)
 				assert.Error(t, err, "Secure execution should block: %s", vt.description)
 			})
 		}
@@ -168,7 +201,7 @@ func TestCommandExecutor_vulnerability_prevention(t *testing.T) {
 
 		for _, mt := range metaTests {
 			t.Run(mt.name, func(t *testing.T) {
-				result, err := executor.executeSecureCommand(ctx, mt.command, false)
+				result, err := execute(ctx, executor, mt.command, false)
 				require.NoError(t, err)
 				require.NotNil(t, result)
 				assert.Equal(t, "success", result.Status)
@@ -189,7 +222,7 @@ func TestCommandExecutor_failsClosedOnSetupErrors(t *testing.T) {
 		}
 		executor := newCommandExecutor(config, logger)
 
-		_, err := executor.executeSecureCommand(ctx, "echo hi", false)
+		_, err := execute(ctx, executor, "echo hi", false)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "resolve run-as user")
@@ -205,22 +238,543 @@ func TestCommandExecutor_failsClosedOnSetupErrors(t *testing.T) {
 		}
 		executor := newCommandExecutor(config, logger)
 
-		_, err := executor.executeSecureCommand(ctx, "echo hi", false)
+		_, err := execute(ctx, executor, "echo hi", false)
 
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "create working directory")
 	})
+}
+
+func TestCommandExecutor_outputTruncation(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	t.Run("output exceeding max size is truncated, not an error", func(t *testing.T) {
+		config := SecurityConfig{
+			MaxOutputSize:    8,
+			MaxExecutionTime: time.Second * 5,
+		}
+		executor := newCommandExecutor(config, logger)
+
+		result, err := execute(ctx, executor, "echo abcdefghijklmnop", false)
+		require.NoError(t, err)
+
+		assert.Equal(t, "success", result.Status)
+		assert.True(t, result.Truncated)
+		assert.Equal(t, "abcd\n[... 9 bytes truncated ...]\nnop", result.Stdout)
+		assert.Equal(t, int64(17), result.StdoutBytes)
+		assert.Equal(t, int64(9), result.StdoutDropped)
+		assert.Zero(t, result.StderrDropped)
+	})
+
+	t.Run("configured tail window", func(t *testing.T) {
+		config := SecurityConfig{
+			MaxOutputSize:    8,
+			OutputTailSize:   2,
+			MaxExecutionTime: time.Second * 5,
+		}
+		executor := newCommandExecutor(config, logger)
+
+		result, err := execute(ctx, executor, "echo abcdefghijklmnop", true)
+		require.NoError(t, err)
+
+		assert.True(t, result.Truncated)
+		// Base64 output is the kept bytes alone, with no marker.
+		stdout, err := base64.StdEncoding.DecodeString(result.Stdout)
+		require.NoError(t, err)
+		assert.Equal(t, "abcdefp\n", string(stdout))
+	})
 
-	t.Run("output exceeding max size returns error", func(t *testing.T) {
+	t.Run("output within the limit is untouched", func(t *testing.T) {
 		config := SecurityConfig{
-			MaxOutputSize:    1,
+			MaxOutputSize:    64,
 			MaxExecutionTime: time.Second * 5,
 		}
 		executor := newCommandExecutor(config, logger)
 
-		_, err := executor.executeSecureCommand(ctx, "echo hello", false)
+		result, err := execute(ctx, executor, "echo hello", false)
+		require.NoError(t, err)
+
+		assert.False(t, result.Truncated)
+		assert.Equal(t, "hello", result.Stdout)
+		assert.Equal(t, int64(6), result.StdoutBytes)
+	})
+}
+
+func TestCommandExecutor_pipeline(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		command        string
+		pipefail       bool
+		wantStatus     string
+		wantExitCode   int
+		wantStdout     string
+		wantPipeStatus []int
+	}{
+		{
+			name:           "stages are wired stdout to stdin",
+			command:        "printf 'b\\na\\nb\\n' | sort | uniq -c",
+			wantStatus:     "success",
+			wantStdout:     "      1 a\n      2 b",
+			wantPipeStatus: []int{0, 0, 0},
+		},
+		{
+			name:           "without pipefail the last stage decides",
+			command:        "false | true",
+			wantStatus:     "success",
+			wantExitCode:   0,
+			wantPipeStatus: []int{1, 0},
+		},
+		{
+			name:           "with pipefail the rightmost failure decides",
+			command:        "false | true",
+			pipefail:       true,
+			wantStatus:     "error",
+			wantExitCode:   1,
+			wantPipeStatus: []int{1, 0},
+		},
+		{
+			name:           "stage that cannot start reports -1",
+			command:        "definitely_absent_zzz | cat",
+			pipefail:       true,
+			wantStatus:     "error",
+			wantExitCode:   -1,
+			wantPipeStatus: []int{-1, 0},
+		},
+		{
+			name:         "single command has no pipe status",
+			command:      "echo hi",
+			wantStatus:   "success",
+			wantStdout:   "hi",
+			wantExitCode: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SecurityConfig{
+				MaxExecutionTime: time.Second * 5,
+				Pipefail:         tt.pipefail,
+			}
+			executor := newCommandExecutor(config, logger)
+
+			result, err := execute(ctx, executor, tt.command, false)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status)
+			assert.Equal(t, tt.wantExitCode, result.ExitCode)
+			assert.Equal(t, tt.wantPipeStatus, result.PipeStatus)
+			if tt.wantStdout != "" {
+				assert.Equal(t, tt.wantStdout, result.Stdout)
+			}
+		})
+	}
+}
+
+func TestCommandExecutor_commandList(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		command      string
+		wantStatus   string
+		wantExitCode int
+		wantStdout   string
+		wantArgv     [][][]string
+		wantOps      []string
+	}{
+		{
+			name:         "and runs after success",
+			command:      "echo a && echo b",
+			wantStatus:   "success",
+			wantStdout:   "a\nb",
+			wantArgv:     [][][]string{{{"echo", "a"}}, {{"echo", "b"}}},
+			wantOps:      []string{"", "&&"},
+			wantExitCode: 0,
+		},
+		{
+			name:         "and short-circuits after failure",
+			command:      "false && echo never",
+			wantStatus:   "error",
+			wantExitCode: 1,
+			wantArgv:     [][][]string{{{"false"}}},
+			wantOps:      []string{""},
+		},
+		{
+			name:         "or runs only after failure",
+			command:      "false || echo fallback || echo never",
+			wantStatus:   "success",
+			wantStdout:   "fallback",
+			wantArgv:     [][][]string{{{"false"}}, {{"echo", "fallback"}}},
+			wantOps:      []string{"", "||"},
+			wantExitCode: 0,
+		},
+		{
+			name:         "semicolon always runs and the last step decides",
+			command:      "echo a; false",
+			wantStatus:   "error",
+			wantExitCode: 1,
+			wantStdout:   "a",
+			wantArgv:     [][][]string{{{"echo", "a"}}, {{"false"}}},
+			wantOps:      []string{"", ";"},
+		},
+		{
+			name:         "pipeline step inside a list",
+			command:      "printf 'x\\n' | cat && echo ok",
+			wantStatus:   "success",
+			wantStdout:   "x\nok",
+			wantArgv:     [][][]string{{{"printf", "x\\n"}, {"cat"}}, {{"echo", "ok"}}},
+			wantOps:      []string{"", "&&"},
+			wantExitCode: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SecurityConfig{
+				MaxExecutionTime: time.Second * 5,
+			}
+			executor := newCommandExecutor(config, logger)
+
+			result, err := execute(ctx, executor, tt.command, false)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status)
+			assert.Equal(t, tt.wantExitCode, result.ExitCode)
+			assert.Equal(t, tt.wantStdout, result.Stdout)
+			if len(tt.wantArgv) == 1 {
+				// A list that ran a single step still reports its steps
+				// because the plan itself had more than one.
+				require.Len(t, result.Steps, 1)
+			}
+			var gotArgv [][][]string
+			var gotOps []string
+			for _, st := range result.Steps {
+				gotArgv = append(gotArgv, st.Argv)
+				gotOps = append(gotOps, st.Op)
+				assert.Positive(t, st.Duration)
+			}
+			assert.Equal(t, tt.wantArgv, gotArgv)
+			assert.Equal(t, tt.wantOps, gotOps)
+		})
+	}
+}
+
+func TestCommandExecutor_redirects(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	workDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "data.txt"), []byte("b\na\nc\n"), 0o644))
+
+	config := SecurityConfig{
+		WorkingDirectory: workDir,
+		MaxExecutionTime: time.Second * 5,
+	}
+	executor := newCommandExecutor(config, logger)
+
+	t.Run("output redirection writes the file, not stdout", func(t *testing.T) {
+		result, err := execute(ctx, executor, "sort data.txt > sorted.txt", false)
+		require.NoError(t, err)
+		assert.Equal(t, "success", result.Status)
+		assert.Empty(t, result.Stdout)
+
+		got, err := os.ReadFile(filepath.Join(workDir, "sorted.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "a\nb\nc\n", string(got))
+	})
+
+	t.Run("append keeps existing content", func(t *testing.T) {
+		_, err := execute(ctx, executor, "echo one > log.txt; echo two >> log.txt", false)
+		require.NoError(t, err)
+
+		got, err := os.ReadFile(filepath.Join(workDir, "log.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "one\ntwo\n", string(got))
+	})
+
+	t.Run("input redirection feeds stdin", func(t *testing.T) {
+		result, err := execute(ctx, executor, "wc -l < data.txt", false)
+		require.NoError(t, err)
+		assert.Equal(t, "3", strings.TrimSpace(result.Stdout))
+	})
+
+	t.Run("2>&1 after a file redirect sends both streams to the file", func(t *testing.T) {
+		_, err := execute(ctx, executor, "ls data.txt absent_zzz > both.txt 2>&1", false)
+		require.NoError(t, err)
+
+		got, err := os.ReadFile(filepath.Join(workDir, "both.txt"))
+		require.NoError(t, err)
+		assert.Contains(t, string(got), "data.txt")
+		assert.Contains(t, string(got), "absent_zzz")
+	})
+
+	t.Run("2>&1 before a pipe sends stderr down the pipe", func(t *testing.T) {
+		result, err := execute(ctx, executor, "ls absent_zzz 2>&1 | cat", false)
+		require.NoError(t, err)
+		assert.Contains(t, result.Stdout, "absent_zzz")
+		assert.Empty(t, result.Stderr)
+	})
+
+	t.Run("missing input file fails the stage like a shell", func(t *testing.T) {
+		result, err := execute(ctx, executor, "cat < missing.txt", false)
+		require.NoError(t, err)
+		assert.Equal(t, "error", result.Status)
+		assert.Equal(t, 1, result.ExitCode)
+		assert.Contains(t, result.Stderr, "missing.txt")
+	})
+
+	t.Run("redirect outside the workspace is rejected before running", func(t *testing.T) {
+		_, err := execute(ctx, executor, "echo x > ../escaped.txt", false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the working directory")
+	})
+}
+
+func TestCommandExecutor_inlineEnv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	executor := newCommandExecutor(SecurityConfig{MaxExecutionTime: time.Second * 5}, logger)
+
+	result, err := execute(ctx, executor, "MCP_SHELL_TEST_VAR='a b' printenv MCP_SHELL_TEST_VAR; printenv MCP_SHELL_TEST_VAR", false)
+	require.NoError(t, err)
+
+	// The assignment applies to its own command only, never to the next one
+	// or to the server.
+	assert.Equal(t, "a b", result.Stdout)
+	require.Len(t, result.Steps, 2)
+	assert.Equal(t, 0, result.Steps[0].ExitCode)
+	assert.Equal(t, 1, result.Steps[1].ExitCode)
+	_, set := os.LookupEnv("MCP_SHELL_TEST_VAR")
+	assert.False(t, set)
+
+	t.Run("overrides an inherited variable", func(t *testing.T) {
+		t.Setenv("MCP_SHELL_TEST_VAR", "inherited")
+		result, err := execute(ctx, executor, "MCP_SHELL_TEST_VAR=override printenv MCP_SHELL_TEST_VAR", false)
+		require.NoError(t, err)
+		assert.Equal(t, "override", result.Stdout)
+	})
+}
+
+func TestCommandExecutor_environment(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
 
+	t.Setenv("MCP_SHELL_TEST_SECRET", "s3cret")
+	t.Setenv("MCP_SHELL_TEST_KEEP", "kept")
+
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%v", legacy), func(t *testing.T) {
+			executor := newCommandExecutor(SecurityConfig{
+				UseShellExecution: legacy,
+				MaxExecutionTime:  time.Second * 5,
+				Environment: &EnvironmentConfig{
+					Passthrough: []string{"MCP_SHELL_TEST_KEEP"},
+					Set:         map[string]string{"TZ": "UTC"},
+					Path:        "/usr/bin:/bin",
+				},
+			}, logger)
+
+			result, err := execute(ctx, executor, "env", false)
+			require.NoError(t, err)
+			assert.Contains(t, result.Stdout, "MCP_SHELL_TEST_KEEP=kept")
+			assert.Contains(t, result.Stdout, "TZ=UTC")
+			assert.Contains(t, result.Stdout, "PATH=/usr/bin:/bin")
+			assert.NotContains(t, result.Stdout, "s3cret")
+			assert.Equal(t, []string{"MCP_SHELL_TEST_KEEP", "PATH", "TZ"}, result.SecurityInfo.Environment)
+		})
+	}
+
+	t.Run("inline assignments still apply", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{
+			MaxExecutionTime: time.Second * 5,
+			Environment:      &EnvironmentConfig{Path: "/usr/bin:/bin"},
+		}, logger)
+
+		result, err := execute(ctx, executor, "MCP_SHELL_TEST_VAR=x env", false)
+		require.NoError(t, err)
+		assert.Equal(t, "PATH=/usr/bin:/bin\nMCP_SHELL_TEST_VAR=x", result.Stdout)
+	})
+
+	t.Run("bare names resolve in the configured PATH", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{
+			MaxExecutionTime: time.Second * 5,
+			Environment:      &EnvironmentConfig{Path: t.TempDir()},
+		}, logger)
+
+		_, err := execute(ctx, executor, "ls", false)
 		require.Error(t, err)
-		assert.Contains(t, err.Error(), "exceeds maximum size limit")
+		assert.Contains(t, err.Error(), "executable 'ls' not found in environment.path")
+	})
+
+	t.Run("inherited without an environment block", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{MaxExecutionTime: time.Second * 5}, logger)
+
+		result, err := execute(ctx, executor, "env", false)
+		require.NoError(t, err)
+		assert.Contains(t, result.Stdout, "MCP_SHELL_TEST_SECRET=s3cret")
+		assert.Nil(t, result.SecurityInfo.Environment)
 	})
 }
+
+func TestCommandExecutor_heredocs(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	executor := newCommandExecutor(SecurityConfig{MaxExecutionTime: time.Second * 5}, logger)
+
+	tests := []struct {
+		name       string
+		command    string
+		wantStdout string
+	}{
+		{
+			name:       "here-document feeds stdin",
+			command:    "sort <<'EOF'\nb\na\nc\nEOF",
+			wantStdout: "a\nb\nc",
+		},
+		{
+			name:       "here-document overrides the pipe into its stage",
+			command:    "echo ignored | cat <<EOF\nfrom heredoc\nEOF",
+			wantStdout: "from heredoc",
+		},
+		{
+			name:       "here-document into the first stage of a pipeline",
+			command:    "cat <<EOF | wc -l\none\ntwo\nEOF",
+			wantStdout: "2",
+		},
+		{
+			name:       "here-string",
+			command:    "tr a-z A-Z <<< 'hello world'",
+			wantStdout: "HELLO WORLD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := execute(ctx, executor, tt.command, false)
+			require.NoError(t, err)
+			assert.Equal(t, "success", result.Status, result.Stderr)
+			assert.Equal(t, tt.wantStdout, strings.TrimSpace(result.Stdout))
+		})
+	}
+}
+
+func TestCommandExecutor_executeArgv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%v", legacy), func(t *testing.T) {
+			executor := newCommandExecutor(SecurityConfig{
+				UseShellExecution: legacy,
+				MaxExecutionTime:  time.Second * 5,
+			}, logger)
+
+			// Never handed to a shell, even in legacy mode.
+			result, err := executor.executeArgv(ctx, []string{"echo", "$HOME", "a;b"}, false)
+			require.NoError(t, err)
+			assert.Equal(t, "success", result.Status)
+			assert.Equal(t, "$HOME a;b", result.Stdout)
+			assert.Equal(t, [][]string{{"echo", "$HOME", "a;b"}}, result.Argv)
+			assert.Equal(t, `echo '$HOME' 'a;b'`, result.Command)
+			require.NotNil(t, result.SecurityInfo)
+		})
+	}
+
+	t.Run("empty argv", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{}, logger)
+		_, err := executor.executeArgv(ctx, nil, false)
+		require.Error(t, err)
+	})
+}
+
+func TestCommandExecutor_executeValidated(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644))
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"echo"},
+		WorkingDirectory:   dir,
+		MaxExecutionTime:   time.Second * 5,
+	}
+	plan, err := newSecurityValidator(config, logger).validateCommand("echo *.txt")
+	require.NoError(t, err)
+
+	// A file appearing after validation does not change what runs: the
+	// glob is not expanded again.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644))
+	executor := newCommandExecutor(config, logger)
+	result, err := executor.executeValidated(ctx, "echo *.txt", plan, false)
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", result.Stdout)
+	assert.Equal(t, [][]string{{"echo", "a.txt"}}, result.Argv)
+
+	_, err = executor.executeValidated(ctx, "echo *.txt", nil, false)
+	assert.EqualError(t, err, "command has no validated plan to run")
+
+	// Legacy shell mode has no plan and hands the command to bash.
+	config.UseShellExecution = true
+	result, err = newCommandExecutor(config, logger).executeValidated(ctx, "echo *.txt", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt b.txt", result.Stdout)
+}
+
+func TestCommandExecutor_executionTimeout(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	tests := []struct {
+		name      string
+		config    SecurityConfig
+		requested time.Duration
+		want      time.Duration
+	}{
+		{name: "built-in default", want: 30 * time.Second},
+		{name: "configured default", config: SecurityConfig{MaxExecutionTime: time.Minute}, want: time.Minute},
+		{name: "request lowers the default", config: SecurityConfig{MaxExecutionTime: time.Minute}, requested: 5 * time.Second, want: 5 * time.Second},
+		{name: "request capped at the default without max_timeout", config: SecurityConfig{MaxExecutionTime: time.Minute}, requested: time.Hour, want: time.Minute},
+		{name: "request raised up to max_timeout", config: SecurityConfig{MaxExecutionTime: time.Minute, MaxTimeout: 10 * time.Minute}, requested: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "request capped at max_timeout", config: SecurityConfig{MaxExecutionTime: time.Minute, MaxTimeout: 10 * time.Minute}, requested: time.Hour, want: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := newCommandExecutor(tt.config, logger)
+			if tt.requested > 0 {
+				executor = executor.withTimeout(tt.requested).(*CommandExecutor)
+			}
+			assert.Equal(t, tt.want, executor.executionTimeout())
+		})
+	}
+}
+
+func TestCommandExecutor_timedOut(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	executor := newCommandExecutor(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"sleep", "echo"},
+		MaxExecutionTime:   5 * time.Second,
+	}, logger)
+
+	result, err := execute(ctx, executor.withTimeout(100*time.Millisecond), "sleep 5", false)
+	require.NoError(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, "error", result.Status)
+	assert.Less(t, result.ExecutionTime, 5*time.Second)
+	require.NotNil(t, result.SecurityInfo)
+	assert.Equal(t, "100ms", result.SecurityInfo.Timeout)
+
+	result, err = execute(ctx, executor, "echo done", false)
+	require.NoError(t, err)
+	assert.False(t, result.TimedOut)
+	assert.Equal(t, "5s", result.SecurityInfo.Timeout)
+}
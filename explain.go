@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Modes a validator can be in, as reported by shell_explain.
+const (
+	modeSecure   = "secure"
+	modeLegacy   = "legacy"
+	modeDisabled = "disabled"
+)
+
+// CommandExplanation is shell_explain's dry-run verdict on a command: whether
+// shell_exec would run it and, if not, the rule and message that reject it,
+// plus how every command it contains was parsed and judged. Nothing is
+// executed to produce it.
+type CommandExplanation struct {
+	Command  string             `json:"command"`
+	Mode     string             `json:"mode"`
+	Allowed  bool               `json:"allowed"`
+	Rule     string             `json:"rule,omitempty"`
+	Reason   string             `json:"reason,omitempty"`
+	Commands []CommandJudgement `json:"commands,omitempty"`
+}
+
+// CommandJudgement explains one simple command of a secure-mode plan: its
+// resolved argv, the executable it would run, the allowlist entry and
+// argument policy that apply to it, and the first rule it breaks, if any.
+type CommandJudgement struct {
+	Argv         []string `json:"argv"`
+	Env          []string `json:"env,omitempty"`
+	ResolvedPath string   `json:"resolved_path,omitempty"`
+	AllowedBy    string   `json:"allowed_by,omitempty"`
+	Policy       string   `json:"policy,omitempty"`
+	Allowed      bool     `json:"allowed"`
+	Rule         string   `json:"rule,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+}
+
+// explain judges command exactly as validateCommand does, without executing
+// it. The verdict is validateCommand's own, so it cannot drift from what
+// shell_exec enforces; the per-command breakdown is added on top, from the
+// very plan the verdict was reached on.
+func (v *SecurityValidator) explain(command string) *CommandExplanation {
+	exp := &CommandExplanation{Command: command, Mode: v.mode()}
+	plan, err := v.validateCommand(command)
+	exp.setVerdict(err)
+
+	if plan != nil {
+		for _, cmd := range plan.commands() {
+			exp.Commands = append(exp.Commands, v.judge(cmd.Argv, cmd.Env))
+		}
+	}
+	return exp
+}
+
+// explainArgv is explain for a structured argv, judged as validateArgv does.
+func (v *SecurityValidator) explainArgv(argv []string) *CommandExplanation {
+	exp := &CommandExplanation{Command: quoteArgv(argv), Mode: v.mode()}
+	exp.setVerdict(v.validateArgv(argv))
+
+	if len(argv) > 0 && argv[0] != "" {
+		exp.Commands = []CommandJudgement{v.judge(argv, nil)}
+	}
+	return exp
+}
+
+// pin reports the path every command runs from as pinned by e, and refuses
+// the command where running it would be: at the first executable e cannot
+// run, once the validator has let it through.
+func (exp *CommandExplanation) pin(e Executor) {
+	for i := range exp.Commands {
+		j := &exp.Commands[i]
+		path, err := e.pinned(j.Argv[0])
+		if path != "" {
+			j.ResolvedPath = path
+		}
+		if err == nil || !j.Allowed {
+			continue
+		}
+		j.ResolvedPath = ""
+		j.Allowed = false
+		j.Rule = ruleExecutablePin
+		j.Reason = err.Error()
+		if exp.Allowed {
+			exp.setVerdict(reject(ruleExecutablePin, err))
+		}
+	}
+}
+
+func (exp *CommandExplanation) setVerdict(err error) {
+	exp.Allowed = err == nil
+	if err != nil {
+		exp.Rule = ruleOf(err)
+		exp.Reason = err.Error()
+	}
+}
+
+func (v *SecurityValidator) mode() string {
+	switch {
+	case !v.config.Enabled:
+		return modeDisabled
+	case v.config.UseShellExecution:
+		return modeLegacy
+	default:
+		return modeSecure
+	}
+}
+
+// judge explains one argv. The allowlist and policy verdicts only apply in
+// secure mode; otherwise the command is just described. ResolvedPath is what
+// PATH resolves argv[0] to now, until pin replaces it with the executable
+// that would actually run.
+func (v *SecurityValidator) judge(argv, env []string) CommandJudgement {
+	j := CommandJudgement{Argv: argv, Env: env, Allowed: true}
+	if path, err := resolveExecutable(argv[0], v.workDir(), v.config.childPath()); err == nil {
+		j.ResolvedPath = path
+	}
+	if v.mode() != modeSecure {
+		return j
+	}
+
+	j.AllowedBy, _ = v.allowlistEntry(argv[0])
+	if v.policies.governs(argv[0]) {
+		j.Policy = filepath.Base(argv[0])
+	}
+	err := v.checkEnv(env)
+	if err == nil {
+		err = v.checkArgv(argv)
+	}
+	if err != nil {
+		j.Allowed = false
+		j.Rule = ruleOf(err)
+		j.Reason = err.Error()
+	}
+	return j
+}
+
+// resolveExecutable returns the path exec would run for name: a bare name is
+// looked up in pathList (the server's PATH when empty), a relative path is
+// taken relative to the working directory dir, like a command started there.
+func resolveExecutable(name, dir, pathList string) (string, error) {
+	if strings.Contains(name, "/") && !filepath.IsAbs(name) && dir != "" {
+		name = filepath.Join(dir, name)
+	}
+	return lookPath(name, pathList)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityValidator_explain(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	secure := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"ls", "sort", "find", "bash"},
+		BlockedCommands:    []string{"/etc/shadow"},
+		InlineEnv:          map[string]string{"LC_ALL": "C"},
+	}
+
+	tests := []struct {
+		name         string
+		config       SecurityConfig
+		command      string
+		wantAllowed  bool
+		wantRule     string
+		wantReason   string
+		wantCommands []CommandJudgement
+	}{
+		{
+			name:        "allowed pipeline",
+			config:      secure,
+			command:     "ls -l | LC_ALL=C sort",
+			wantAllowed: true,
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"ls", "-l"}, AllowedBy: "ls", Allowed: true},
+				{Argv: []string{"sort"}, Env: []string{"LC_ALL=C"}, AllowedBy: "sort", Policy: "sort", Allowed: true},
+			},
+		},
+		{
+			name:       "unparseable structure",
+			config:     secure,
+			command:    "ls $(id)",
+			wantRule:   ruleSyntax,
+			wantReason: "command rejected in secure mode: arguments must be constant literals",
+		},
+		{
+			name:       "not allowlisted",
+			config:     secure,
+			command:    "ls && rm -rf /",
+			wantRule:   ruleAllowlist,
+			wantReason: "executable 'rm' not in allowed list",
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"ls"}, AllowedBy: "ls", Allowed: true},
+				{Argv: []string{"rm", "-rf", "/"}, Allowed: false, Rule: ruleAllowlist, Reason: "executable 'rm' not in allowed list"},
+			},
+		},
+		{
+			name:       "argument policy",
+			config:     secure,
+			command:    "find . -delete",
+			wantRule:   rulePolicyPrefix + "find",
+			wantReason: `"-delete" is not allowed`,
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"find", ".", "-delete"}, AllowedBy: "find", Policy: "find", Allowed: false, Rule: rulePolicyPrefix + "find"},
+			},
+		},
+		{
+			name:       "interpreter",
+			config:     secure,
+			command:    "bash -c id",
+			wantRule:   ruleInterpreter,
+			wantReason: "is an interpreter",
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"bash", "-c", "id"}, AllowedBy: "bash", Allowed: false, Rule: ruleInterpreter},
+			},
+		},
+		{
+			name:       "inline assignment",
+			config:     secure,
+			command:    "LD_PRELOAD=x.so ls",
+			wantRule:   ruleInlineEnv,
+			wantReason: "inline assignment of LD_PRELOAD is never allowed",
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"ls"}, Env: []string{"LD_PRELOAD=x.so"}, AllowedBy: "ls", Allowed: false, Rule: ruleInlineEnv},
+			},
+		},
+		{
+			name:       "blocked keyword is judged on the whole command",
+			config:     secure,
+			command:    "ls /etc/shadow",
+			wantRule:   ruleBlockedCommand,
+			wantReason: "command contains blocked keyword: /etc/shadow",
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"ls", "/etc/shadow"}, AllowedBy: "ls", Allowed: true},
+			},
+		},
+		{
+			name:       "empty allowlist",
+			config:     SecurityConfig{Enabled: true},
+			command:    "ls",
+			wantRule:   ruleNoAllowlist,
+			wantReason: "no allowed executables configured",
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"ls"}, Allowed: false, Rule: ruleAllowlist},
+			},
+		},
+		{
+			name:        "security disabled",
+			config:      SecurityConfig{Enabled: false},
+			command:     "rm -rf /tmp/x",
+			wantAllowed: true,
+			wantCommands: []CommandJudgement{
+				{Argv: []string{"rm", "-rf", "/tmp/x"}, Allowed: true},
+			},
+		},
+		{
+			name:       "legacy mode",
+			config:     SecurityConfig{Enabled: true, UseShellExecution: true, AllowedCommands: []string{"ls"}},
+			command:    "cat /etc/passwd",
+			wantRule:   ruleAllowedCommands,
+			wantReason: "command not in allowed list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := newSecurityValidator(tt.config, logger)
+			exp := validator.explain(tt.command)
+
+			assert.Equal(t, tt.command, exp.Command)
+			assert.Equal(t, validator.mode(), exp.Mode)
+			assert.Equal(t, tt.wantAllowed, exp.Allowed)
+			assert.Equal(t, tt.wantRule, exp.Rule)
+			assert.Contains(t, exp.Reason, tt.wantReason)
+
+			// The verdict is always the one shell_exec would enforce, and the
+			// breakdown is of the plan it was reached on.
+			plan, err := validator.validateCommand(tt.command)
+			assert.Equal(t, err == nil, exp.Allowed)
+			var argvs [][]string
+			if plan != nil {
+				for _, cmd := range plan.commands() {
+					argvs = append(argvs, cmd.Argv)
+				}
+			}
+			var explained [][]string
+			for _, j := range exp.Commands {
+				explained = append(explained, j.Argv)
+			}
+			assert.Equal(t, argvs, explained)
+
+			require.Len(t, exp.Commands, len(tt.wantCommands))
+			for i, want := range tt.wantCommands {
+				got := exp.Commands[i]
+				assert.Equal(t, want.Argv, got.Argv)
+				assert.Equal(t, want.Env, got.Env)
+				assert.Equal(t, want.AllowedBy, got.AllowedBy)
+				assert.Equal(t, want.Policy, got.Policy)
+				assert.Equal(t, want.Allowed, got.Allowed)
+				assert.Equal(t, want.Rule, got.Rule)
+				assert.Contains(t, got.Reason, want.Reason)
+			}
+		})
+	}
+}
+
+func TestSecurityValidator_explainArgv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	validator := newSecurityValidator(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"echo"},
+	}, logger)
+
+	exp := validator.explainArgv([]string{"echo", "a b"})
+	assert.True(t, exp.Allowed)
+	assert.Equal(t, "echo 'a b'", exp.Command)
+	require.Len(t, exp.Commands, 1)
+	assert.Equal(t, "echo", exp.Commands[0].AllowedBy)
+	assert.NotEmpty(t, exp.Commands[0].ResolvedPath)
+
+	exp = validator.explainArgv([]string{"rm", "x"})
+	assert.False(t, exp.Allowed)
+	assert.Equal(t, ruleAllowlist, exp.Rule)
+
+	exp = validator.explainArgv(nil)
+	assert.False(t, exp.Allowed)
+	assert.Equal(t, ruleSyntax, exp.Rule)
+	assert.Empty(t, exp.Commands)
+}
+
+func TestResolveExecutable(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "bin", "tool")
+	require.NoError(t, os.MkdirAll(filepath.Dir(script), 0o755))
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"), 0o755))
+
+	got, err := resolveExecutable("./bin/tool", dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, script, got)
+
+	got, err = resolveExecutable("ls", dir, "")
+	require.NoError(t, err)
+	assert.True(t, filepath.IsAbs(got))
+
+	_, err = resolveExecutable("definitely_absent_zzz", dir, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/pattern"
+)
+
+// defaultMaxGlobMatches caps one glob's expansion when max_glob_matches is unset.
+const defaultMaxGlobMatches = 1000
+
+// globMode mirrors bash's defaults: * and ? match neither / nor a leading dot,
+// and ** is an ordinary * (no globstar).
+const globMode = pattern.Filenames | pattern.EntireString | pattern.NoGlobStar
+
+// isValidGlob reports whether pat compiles as a pattern. Like a shell, an
+// invalid pattern (an unterminated "[") is not a glob at all but a literal.
+func isValidGlob(pat string) bool {
+	_, err := pattern.Regexp(pat, globMode)
+	return err == nil
+}
+
+// expandGlob expands a relative shell pattern against the filesystem under
+// dir (root itself when dir is empty), one path component at a time, and
+// returns the matches sorted as the shell would, in the same relative form as
+// the pattern. It never reads a
+// directory that resolves outside root and refuses the whole pattern if any
+// match does. limit caps the matches at every level, so a broad pattern fails
+// fast instead of building an enormous argv. A pattern with no matches is an
+// error (bash's failglob): passing the pattern through literally would only
+// hand the command a confusing argument.
+func expandGlob(root, dir, pat string, limit int) ([]string, error) {
+	if root == "" {
+		return nil, errors.New("glob expansion requires a configured working_directory")
+	}
+	if strings.HasPrefix(pat, "/") {
+		return nil, fmt.Errorf("glob %q must be relative to the working directory", pat)
+	}
+	base := root
+	if dir != "" {
+		base = dir
+	}
+
+	matches := []string{""}
+	for i, comp := range strings.Split(pat, "/") {
+		sep := "/"
+		if i == 0 {
+			sep = ""
+		}
+		if !pattern.HasMeta(comp, 0) {
+			lit := unescapeUnquoted(comp)
+			for j := range matches {
+				matches[j] += sep + lit
+			}
+			continue
+		}
+
+		expr, err := pattern.Regexp(comp, globMode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pat, err)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pat, err)
+		}
+
+		var next []string
+		for _, m := range matches {
+			parent := filepath.Join(base, m)
+			if _, err := resolveInRoot(root, parent); err != nil {
+				return nil, fmt.Errorf("glob %q: %w", pat, err)
+			}
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				// Not a directory or unreadable: no matches here, as in a shell.
+				continue
+			}
+			for _, ent := range entries {
+				if !re.MatchString(ent.Name()) {
+					continue
+				}
+				if len(next) == limit {
+					return nil, fmt.Errorf("glob %q matches more than %d entries", pat, limit)
+				}
+				next = append(next, m+sep+ent.Name())
+			}
+		}
+		matches = next
+	}
+
+	// Literal components after the last wildcard were appended unchecked, so
+	// keep only matches that exist; every survivor must stay inside root.
+	wantDir := strings.HasSuffix(pat, "/")
+	var out []string
+	for _, m := range matches {
+		full := filepath.Join(base, m)
+		info, err := os.Lstat(full)
+		if err != nil {
+			continue
+		}
+		real, err := resolveInRoot(root, full)
+		if err != nil {
+			return nil, fmt.Errorf("glob %q: %w", pat, err)
+		}
+		if wantDir && !info.IsDir() {
+			if info, err = os.Stat(real); err != nil || !info.IsDir() {
+				continue
+			}
+		}
+		out = append(out, m)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("glob %q matches no files", pat)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// unescapeUnquoted removes the backslashes of an unquoted word: outside quotes
+// a backslash makes the next character literal and is itself dropped.
+func unescapeUnquoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// unescapeDoubleQuoted removes the backslashes that are escapes inside double
+// quotes: only before $, `, ", \ and newline; anywhere else it is literal.
+func unescapeDoubleQuoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '$', '`', '"', '\\':
+				i++
+			case '\n':
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandGlob(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, f := range []string{"a.go", "b.go", "c.txt", ".hidden.go", "logs/x.log", "logs/y.log", "logs/z.txt", "sub/deep/d.go"} {
+		full := filepath.Join(root, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, nil, 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.go"), nil, 0o644))
+
+	tests := []struct {
+		name          string
+		pattern       string
+		limit         int
+		want          []string
+		errorContains string
+	}{
+		{name: "star", pattern: "*.go", want: []string{"a.go", "b.go"}},
+		{name: "leading dot must be explicit", pattern: ".*.go", want: []string{".hidden.go"}},
+		{name: "question mark", pattern: "?.txt", want: []string{"c.txt"}},
+		{name: "bracket class", pattern: "[ab].go", want: []string{"a.go", "b.go"}},
+		{name: "negated class", pattern: "[!a].go", want: []string{"b.go"}},
+		{name: "in a subdirectory", pattern: "logs/*.log", want: []string{"logs/x.log", "logs/y.log"}},
+		{name: "wildcard directory", pattern: "*/*.txt", want: []string{"logs/z.txt"}},
+		{name: "trailing literal must exist", pattern: "*/deep/d.go", want: []string{"sub/deep/d.go"}},
+		{name: "trailing slash keeps directories", pattern: "*/", want: []string{"logs/", "sub/"}},
+		{name: "dot slash prefix is kept", pattern: "./*.txt", want: []string{"./c.txt"}},
+		{name: "double star is a plain star", pattern: "**/*.log", want: []string{"logs/x.log", "logs/y.log"}},
+		{name: "escaped star is literal", pattern: `\*.go`, errorContains: "matches no files"},
+		{name: "no match fails", pattern: "*.rs", errorContains: "matches no files"},
+		{name: "absolute pattern refused", pattern: "/etc/*", errorContains: "relative to the working directory"},
+		{name: "parent directory refused", pattern: "../*", errorContains: "outside the working directory"},
+		{name: "cap exceeded", pattern: "*", limit: 2, errorContains: "more than 2 entries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			if limit == 0 {
+				limit = defaultMaxGlobMatches
+			}
+			got, err := expandGlob(root, "", tt.pattern, limit)
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("match through a symlink out of the root is refused", func(t *testing.T) {
+		linked := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(linked, "a.go"), nil, 0o644))
+		require.NoError(t, os.Symlink(outside, filepath.Join(linked, "out")))
+
+		_, err := expandGlob(linked, "", "*", defaultMaxGlobMatches)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the working directory")
+
+		_, err = expandGlob(linked, "", "out/*.go", defaultMaxGlobMatches)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the working directory")
+	})
+
+	t.Run("relative to a directory inside the root", func(t *testing.T) {
+		dir := filepath.Join(root, "logs")
+
+		got, err := expandGlob(root, dir, "*.log", defaultMaxGlobMatches)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"x.log", "y.log"}, got)
+
+		got, err = expandGlob(root, dir, "../*.go", defaultMaxGlobMatches)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"../a.go", "../b.go"}, got)
+
+		_, err = expandGlob(root, dir, "../../*", defaultMaxGlobMatches)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the working directory")
+	})
+
+	t.Run("no working directory", func(t *testing.T) {
+		_, err := expandGlob("", "", "*.go", defaultMaxGlobMatches)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "working_directory")
+	})
+}
+
+func TestUnescape(t *testing.T) {
+	assert.Equal(t, "a b", unescapeUnquoted(`a\ b`))
+	assert.Equal(t, `*`, unescapeUnquoted(`\*`))
+	assert.Equal(t, `a\`, unescapeUnquoted(`a\`))
+	assert.Equal(t, `a"b$c\d`, unescapeDoubleQuoted(`a\"b\$c\d`))
+	assert.Equal(t, `a\b`, unescapeDoubleQuoted(`a\\b`))
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/rs/zerolog"
@@ -11,13 +14,13 @@ import (
 
 type ShellHandler struct {
 	validator *SecurityValidator
-	executor  *CommandExecutor
+	executor  Executor
 	logger    zerolog.Logger
 }
 
 func newShellHandler(
 	validator *SecurityValidator,
-	executor *CommandExecutor,
+	executor Executor,
 	logger zerolog.Logger,
 ) *ShellHandler {
 	return &ShellHandler{
@@ -31,14 +34,24 @@ func (h *ShellHandler) handle(
 	ctx context.Context,
 	request mcp.CallToolRequest,
 ) (*mcp.CallToolResult, error) {
-	command, err := request.RequireString("command")
+	in, err := parseToolInput(request)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Missing command parameter")
-		return mcp.NewToolResultError("Missing 'command' parameter"), nil
+		h.logger.Error().Err(err).Msg("Invalid tool input")
+		return mcp.NewToolResultError(err.Error()), nil
 	}
+	command := in.command
 
 	h.logger.Info().Str("command", command).Msg("Received shell command request")
 
+	validator, executor, err := h.scoped(in.cwd)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("cwd", in.cwd).Msg("Invalid working directory")
+		return mcp.NewToolResultError(fmt.Sprintf("Invalid 'cwd' parameter: %s", err.Error())), nil
+	}
+	if in.timeout > 0 {
+		executor = executor.withTimeout(in.timeout)
+	}
+
 	if h.validator.isEnabled() {
 		h.logger.Info().
 			Str("command", command).
@@ -46,7 +59,15 @@ func (h *ShellHandler) handle(
 			Msg("Command execution requested")
 	}
 
-	if err := h.validator.validateCommand(command); err != nil {
+	// The plan validated is the plan run: nothing is parsed or expanded
+	// again between the two.
+	var plan *execPlan
+	if in.structured {
+		err = validator.validateArgv(in.argv)
+	} else {
+		plan, err = validator.validateCommand(command)
+	}
+	if err != nil {
 		h.logger.Warn().
 			Err(err).
 			Str("command", command).
@@ -56,7 +77,12 @@ func (h *ShellHandler) handle(
 
 	useBase64 := request.GetBool("base64", false)
 
-	result, err := h.executor.execute(ctx, command, useBase64)
+	var result *ExecutionResult
+	if in.structured {
+		result, err = executor.executeArgv(ctx, in.argv, useBase64)
+	} else {
+		result, err = executor.executeValidated(ctx, command, plan, useBase64)
+	}
 	if err != nil {
 		h.logger.Error().
 			Err(err).
@@ -72,6 +98,57 @@ func (h *ShellHandler) handle(
 		"stderr":         result.Stderr,
 		"command":        result.Command,
 		"execution_time": result.ExecutionTime.String(),
+		"timed_out":      result.TimedOut,
+		"truncated":      result.Truncated,
+		"force_killed":   result.ForceKilled,
+	}
+
+	if result.Signal != "" {
+		response["signal"] = result.Signal
+	}
+
+	if len(result.LimitsExceeded) > 0 {
+		response["limits_exceeded"] = result.LimitsExceeded
+	}
+	if len(result.SeccompViolations) > 0 {
+		response["seccomp_violations"] = result.SeccompViolations
+	}
+	if len(result.ExecViolations) > 0 {
+		response["exec_violations"] = result.ExecViolations
+	}
+
+	if result.Truncated {
+		response["stdout_bytes"] = result.StdoutBytes
+		response["stderr_bytes"] = result.StderrBytes
+		response["stdout_dropped"] = result.StdoutDropped
+		response["stderr_dropped"] = result.StderrDropped
+	}
+
+	if len(result.Argv) > 0 {
+		response["argv"] = result.Argv
+	}
+
+	if len(result.PipeStatus) > 0 {
+		response["pipe_status"] = result.PipeStatus
+	}
+
+	if len(result.Steps) > 0 {
+		steps := make([]map[string]interface{}, len(result.Steps))
+		for i, st := range result.Steps {
+			step := map[string]interface{}{
+				"argv":      st.Argv,
+				"exit_code": st.ExitCode,
+				"duration":  st.Duration.String(),
+			}
+			if st.Op != "" {
+				step["op"] = st.Op
+			}
+			if len(st.PipeStatus) > 0 {
+				step["pipe_status"] = st.PipeStatus
+			}
+			steps[i] = step
+		}
+		response["steps"] = steps
 	}
 
 	if result.SecurityInfo != nil {
@@ -91,3 +168,101 @@ func (h *ShellHandler) handle(
 
 	return mcp.NewToolResultText(string(jsonBytes)), nil
 }
+
+// explain handles shell_explain: it judges a command or argv the way
+// shell_exec would, without running anything.
+func (h *ShellHandler) explain(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+) (*mcp.CallToolResult, error) {
+	in, err := parseToolInput(request)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	validator, executor, err := h.scoped(in.cwd)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("Invalid 'cwd' parameter: %s", err.Error())), nil
+	}
+
+	var exp *CommandExplanation
+	if in.structured {
+		exp = validator.explainArgv(in.argv)
+	} else {
+		exp = validator.explain(in.command)
+	}
+	exp.pin(executor)
+
+	h.logger.Info().
+		Str("command", exp.Command).
+		Bool("allowed", exp.Allowed).
+		Str("rule", exp.Rule).
+		Msg("Explained command")
+
+	jsonBytes, err := json.Marshal(exp)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to marshal explanation")
+		return mcp.NewToolResultError("Failed to marshal result to JSON"), nil
+	}
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// scoped returns the validator and executor for a call made with the given
+// cwd: the handler's own when cwd is empty, else copies bound to cwd resolved
+// inside the working directory.
+func (h *ShellHandler) scoped(cwd string) (*SecurityValidator, Executor, error) {
+	if cwd == "" {
+		return h.validator, h.executor, nil
+	}
+	dir, err := h.validator.resolveWorkDir(cwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.validator.in(dir), h.executor.in(dir), nil
+}
+
+// toolInput is the command a tool call names: a shell command string, or a
+// structured argv when structured is set, the directory it runs in and the
+// timeout requested for it (zero for the default).
+type toolInput struct {
+	command    string
+	argv       []string
+	structured bool
+	cwd        string
+	timeout    time.Duration
+}
+
+// parseToolInput reads the mutually exclusive command and argv parameters and
+// the optional cwd and timeout. For an argv, command is set to its
+// shell-quoted rendering for logging.
+func parseToolInput(request mcp.CallToolRequest) (toolInput, error) {
+	in := toolInput{cwd: request.GetString("cwd", "")}
+	if _, ok := request.GetArguments()["timeout"]; ok {
+		seconds, err := request.RequireFloat("timeout")
+		if err != nil {
+			return toolInput{}, fmt.Errorf("Invalid 'timeout' parameter: %s", err.Error())
+		}
+		if seconds <= 0 || math.IsNaN(seconds) || seconds > math.MaxInt64/float64(time.Second) {
+			return toolInput{}, errors.New("Invalid 'timeout' parameter: must be a positive number of seconds")
+		}
+		in.timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	command := request.GetString("command", "")
+	_, hasArgv := request.GetArguments()["argv"]
+	switch {
+	case command != "" && hasArgv:
+		return toolInput{}, errors.New("'command' and 'argv' are mutually exclusive")
+	case command == "" && !hasArgv:
+		return toolInput{}, errors.New("Missing 'command' parameter")
+	case !hasArgv:
+		in.command = command
+		return in, nil
+	}
+
+	argv, err := request.RequireStringSlice("argv")
+	if err != nil {
+		return toolInput{}, fmt.Errorf("Invalid 'argv' parameter: %s", err.Error())
+	}
+	in.command, in.argv, in.structured = quoteArgv(argv), argv, true
+	return in, nil
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -250,12 +252,12 @@ func TestShellHandler_direct_security_tests(t *testing.T) {
 		executor := newCommandExecutor(config, logger)
 
 		// Test validation - should pass (this is the vulnerability)
-		err := validator.validateCommand("echo $(rm -rf /)")
+		_, err := validator.validateCommand("echo $(rm -rf /)")
 		assert.Error(t, err, "Should block command with shell metacharacters")
 
-		// Test parsing - the executor's unfurler rejects it as well
-		res := executor.unfurler.unfurl("echo $(rm -rf /)")
-		assert.False(t, res.Allowed, "Executor should reject command with command substitution")
+		// The executor runs nothing it was not handed a validated plan for
+		_, err = executor.executeValidated(context.Background(), "echo $(rm -rf /)", nil, false)
+		assert.Error(t, err, "Executor should refuse a command without a plan")
 	})
 
 	t.Run("legacy_execution_allows_injection", func(t *testing.T) {
@@ -268,7 +270,7 @@ func TestShellHandler_direct_security_tests(t *testing.T) {
 		validator := newSecurityValidator(config, logger)
 
 		// Test validation - should pass (this is the vulnerability)
-		err := validator.validateCommand("echo $(rm -rf /)")
+		_, err := validator.validateCommand("echo $(rm -rf /)")
 		assert.NoError(t, err, "Legacy mode without blocks allows dangerous commands")
 	})
 
@@ -282,7 +284,7 @@ func TestShellHandler_direct_security_tests(t *testing.T) {
 			AllowedExecutables: []string{"echo"},
 		}
 		secureValidator := newSecurityValidator(secureConfig, logger)
-		err := secureValidator.validateCommand(vulnCommand)
+		_, err := secureValidator.validateCommand(vulnCommand)
 		assert.Error(t, err, "Secure mode should block VULN.md example")
 
 		// Legacy mode without proper blocks (vulnerable)
@@ -291,7 +293,7 @@ func TestShellHandler_direct_security_tests(t *testing.T) {
 			UseShellExecution: true,
 		}
 		vulnerableValidator := newSecurityValidator(vulnerableConfig, logger)
-		err = vulnerableValidator.validateCommand(vulnCommand)
+		_, err = vulnerableValidator.validateCommand(vulnCommand)
 		assert.NoError(t, err, "Legacy mode without blocks is vulnerable")
 
 		// Legacy mode with proper blocks - still vulnerable to obfuscated commands
@@ -301,7 +303,455 @@ func TestShellHandler_direct_security_tests(t *testing.T) {
 			BlockedCommands:   []string{"chmod"},
 		}
 		protectedValidator := newSecurityValidator(protectedConfig, logger)
-		err = protectedValidator.validateCommand(vulnCommand)
+		_, err = protectedValidator.validateCommand(vulnCommand)
 		assert.NoError(t, err, "Legacy mode cannot detect obfuscated commands even with blocks")
 	})
 }
+
+func TestShellHandler_commandList_response(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	config := SecurityConfig{
+		Enabled:            true,
+		UseShellExecution:  false,
+		AllowedExecutables: []string{"echo", "false"},
+		MaxExecutionTime:   time.Second * 5,
+	}
+	handler := newShellHandler(newSecurityValidator(config, logger), newCommandExecutor(config, logger), logger)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"command": "false || echo recovered",
+	}
+
+	result, err := handler.handle(ctx, request)
+	require.NoError(t, err)
+	require.False(t, result.IsError)
+
+	textContent, ok := mcp.AsTextContent(result.Content[0])
+	require.True(t, ok)
+
+	var response struct {
+		Status string `json:"status"`
+		Stdout string `json:"stdout"`
+		Steps  []struct {
+			Op       string     `json:"op"`
+			Argv     [][]string `json:"argv"`
+			ExitCode int        `json:"exit_code"`
+			Duration string     `json:"duration"`
+		} `json:"steps"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(textContent.Text), &response))
+
+	assert.Equal(t, "success", response.Status)
+	assert.Equal(t, "recovered", response.Stdout)
+	require.Len(t, response.Steps, 2)
+	assert.Equal(t, [][]string{{"false"}}, response.Steps[0].Argv)
+	assert.Equal(t, 1, response.Steps[0].ExitCode)
+	assert.Equal(t, "||", response.Steps[1].Op)
+	assert.Equal(t, [][]string{{"echo", "recovered"}}, response.Steps[1].Argv)
+	assert.NotEmpty(t, response.Steps[1].Duration)
+}
+
+func TestShellHandler_expandedArgv_response(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	config := SecurityConfig{
+		Enabled:             true,
+		AllowedExecutables:  []string{"echo"},
+		MaxExecutionTime:    time.Second * 5,
+		ExpandableVariables: []string{"GREETING"},
+		Variables:           map[string]string{"GREETING": "hello there"},
+	}
+	handler := newShellHandler(newSecurityValidator(config, logger), newCommandExecutor(config, logger), logger)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"command": "echo $GREETING {a,b}",
+	}
+
+	result, err := handler.handle(context.Background(), request)
+	require.NoError(t, err)
+	require.False(t, result.IsError)
+
+	textContent, ok := mcp.AsTextContent(result.Content[0])
+	require.True(t, ok)
+
+	var response struct {
+		Stdout string     `json:"stdout"`
+		Argv   [][]string `json:"argv"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(textContent.Text), &response))
+
+	assert.Equal(t, "hello there a b", response.Stdout)
+	assert.Equal(t, [][]string{{"echo", "hello there", "a", "b"}}, response.Argv)
+}
+
+func TestShellHandler_argv_response(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"printf"},
+		MaxExecutionTime:   time.Second * 5,
+	}
+	handler := newShellHandler(newSecurityValidator(config, logger), newCommandExecutor(config, logger), logger)
+
+	// Nothing in an argv element is shell syntax: no quoting, expansion or
+	// operators, so it reaches the process byte for byte.
+	argv := []interface{}{"printf", "%s|", "a b", "$(id)", "*", "'; rm -rf /"}
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"argv": argv}
+
+	result, err := handler.handle(context.Background(), request)
+	require.NoError(t, err)
+	require.False(t, result.IsError)
+
+	textContent, ok := mcp.AsTextContent(result.Content[0])
+	require.True(t, ok)
+
+	var response struct {
+		Status  string     `json:"status"`
+		Stdout  string     `json:"stdout"`
+		Command string     `json:"command"`
+		Argv    [][]string `json:"argv"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(textContent.Text), &response))
+
+	assert.Equal(t, "success", response.Status)
+	assert.Equal(t, "a b|$(id)|*|'; rm -rf /|", response.Stdout)
+	assert.Equal(t, [][]string{{"printf", "%s|", "a b", "$(id)", "*", "'; rm -rf /"}}, response.Argv)
+	assert.Equal(t, `printf '%s|' 'a b' '$(id)' '*' "'; rm -rf /"`, response.Command)
+}
+
+func TestShellHandler_cwd(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	root := t.TempDir()
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "a.txt"), []byte("in sub\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "top.txt"), []byte("at top\n"), 0o644))
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(root, "escape")))
+
+	config := SecurityConfig{
+		Enabled:             true,
+		AllowedExecutables:  []string{"cat", "pwd", "echo"},
+		MaxExecutionTime:    time.Second * 5,
+		WorkingDirectory:    root,
+		ConfinePaths:        true,
+		ExpandableVariables: []string{"PWD"},
+	}
+	handler := newShellHandler(newSecurityValidator(config, logger), newCommandExecutor(config, logger), logger)
+
+	tests := []struct {
+		name          string
+		args          map[string]interface{}
+		wantStdout    string
+		wantDir       string
+		errorContains string
+	}{
+		{
+			name:       "runs in the subdirectory",
+			args:       map[string]interface{}{"command": "pwd", "cwd": "sub"},
+			wantStdout: filepath.Join(realRoot, "sub"),
+			wantDir:    filepath.Join(realRoot, "sub"),
+		},
+		{
+			name:       "globs and relative paths are taken from cwd",
+			args:       map[string]interface{}{"command": "cat *.txt ../top.txt", "cwd": "sub"},
+			wantStdout: "in sub\nat top",
+			wantDir:    filepath.Join(realRoot, "sub"),
+		},
+		{
+			name:       "PWD expands to cwd",
+			args:       map[string]interface{}{"command": "echo $PWD", "cwd": "sub"},
+			wantStdout: filepath.Join(realRoot, "sub"),
+			wantDir:    filepath.Join(realRoot, "sub"),
+		},
+		{
+			name:       "argv runs in cwd",
+			args:       map[string]interface{}{"argv": []interface{}{"cat", "a.txt"}, "cwd": "sub"},
+			wantStdout: "in sub",
+			wantDir:    filepath.Join(realRoot, "sub"),
+		},
+		{
+			name:       "default is the working directory",
+			args:       map[string]interface{}{"command": "cat top.txt"},
+			wantStdout: "at top",
+			wantDir:    root,
+		},
+		{
+			name:          "confinement roots stay at the working directory",
+			args:          map[string]interface{}{"command": "cat ../../etc/passwd", "cwd": "sub"},
+			errorContains: "Security violation",
+		},
+		{
+			name:          "dot-dot escape rejected",
+			args:          map[string]interface{}{"command": "pwd", "cwd": ".."},
+			errorContains: "Invalid 'cwd' parameter",
+		},
+		{
+			name:          "symlink escape rejected",
+			args:          map[string]interface{}{"command": "pwd", "cwd": "escape"},
+			errorContains: "outside the working directory",
+		},
+		{
+			name:          "missing directory rejected",
+			args:          map[string]interface{}{"command": "pwd", "cwd": "nope"},
+			errorContains: "Invalid 'cwd' parameter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := handler.handle(context.Background(), request)
+			require.NoError(t, err)
+
+			textContent, ok := mcp.AsTextContent(result.Content[0])
+			require.True(t, ok)
+			if tt.errorContains != "" {
+				assert.True(t, result.IsError)
+				assert.Contains(t, textContent.Text, tt.errorContains)
+				return
+			}
+			require.False(t, result.IsError, textContent.Text)
+
+			var response struct {
+				Stdout       string `json:"stdout"`
+				SecurityInfo struct {
+					WorkingDir string `json:"working_dir"`
+				} `json:"security_info"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(textContent.Text), &response))
+			assert.Equal(t, tt.wantStdout, response.Stdout)
+			assert.Equal(t, tt.wantDir, response.SecurityInfo.WorkingDir)
+		})
+	}
+}
+
+func TestShellHandler_timeout(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"sleep"},
+		MaxExecutionTime:   time.Second * 5,
+	}
+	handler := newShellHandler(newSecurityValidator(config, logger), newCommandExecutor(config, logger), logger)
+
+	tests := []struct {
+		name          string
+		timeout       interface{}
+		wantTimeout   string
+		wantTimedOut  bool
+		errorContains string
+	}{
+		{name: "fires", timeout: 0.1, wantTimeout: "100ms", wantTimedOut: true},
+		{name: "capped at the maximum", timeout: 3600, wantTimeout: "5s"},
+		{name: "zero rejected", timeout: 0, errorContains: "Invalid 'timeout' parameter"},
+		{name: "negative rejected", timeout: -1, errorContains: "Invalid 'timeout' parameter"},
+		{name: "not a number rejected", timeout: "soon", errorContains: "Invalid 'timeout' parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := "sleep 1"
+			if tt.wantTimedOut {
+				command = "sleep 5"
+			}
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = map[string]interface{}{"command": command, "timeout": tt.timeout}
+
+			result, err := handler.handle(context.Background(), request)
+			require.NoError(t, err)
+
+			textContent, ok := mcp.AsTextContent(result.Content[0])
+			require.True(t, ok)
+			if tt.errorContains != "" {
+				assert.True(t, result.IsError)
+				assert.Contains(t, textContent.Text, tt.errorContains)
+				return
+			}
+			require.False(t, result.IsError, textContent.Text)
+
+			var response struct {
+				TimedOut     bool `json:"timed_out"`
+				SecurityInfo struct {
+					Timeout string `json:"timeout"`
+				} `json:"security_info"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(textContent.Text), &response))
+			assert.Equal(t, tt.wantTimedOut, response.TimedOut)
+			assert.Equal(t, tt.wantTimeout, response.SecurityInfo.Timeout)
+		})
+	}
+}
+
+func TestShellHandler_argv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	tests := []struct {
+		name            string
+		config          SecurityConfig
+		requestArgs     map[string]interface{}
+		expectError     bool
+		expectErrorText string
+	}{
+		{
+			name: "argv runs an allowed executable",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"echo"},
+			},
+			requestArgs: map[string]interface{}{
+				"argv": []interface{}{"echo", "hello"},
+			},
+			expectError: false,
+		},
+		{
+			name: "argv is checked against the allowlist",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"echo"},
+			},
+			requestArgs: map[string]interface{}{
+				"argv": []interface{}{"rm", "-rf", "/"},
+			},
+			expectError:     true,
+			expectErrorText: "not in allowed list",
+		},
+		{
+			name: "command and argv together",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"echo"},
+			},
+			requestArgs: map[string]interface{}{
+				"command": "echo a",
+				"argv":    []interface{}{"echo", "b"},
+			},
+			expectError:     true,
+			expectErrorText: "mutually exclusive",
+		},
+		{
+			name: "argv with a non-string item",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"echo"},
+			},
+			requestArgs: map[string]interface{}{
+				"argv": []interface{}{"echo", 1},
+			},
+			expectError:     true,
+			expectErrorText: "is not a string",
+		},
+		{
+			name: "empty argv",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"echo"},
+			},
+			requestArgs: map[string]interface{}{
+				"argv": []interface{}{},
+			},
+			expectError:     true,
+			expectErrorText: "must name an executable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newShellHandler(newSecurityValidator(tt.config, logger), newCommandExecutor(tt.config, logger), logger)
+
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.requestArgs
+
+			result, err := handler.handle(context.Background(), request)
+			require.NoError(t, err)
+			require.NotNil(t, result)
+
+			if !tt.expectError {
+				assert.False(t, result.IsError)
+				return
+			}
+			assert.True(t, result.IsError)
+			textContent, ok := mcp.AsTextContent(result.Content[0])
+			require.True(t, ok)
+			assert.Contains(t, textContent.Text, tt.expectErrorText)
+		})
+	}
+}
+
+func TestShellHandler_explain(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "marker")
+	tool := filepath.Join(t.TempDir(), "tool")
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\necho v1\n"), 0o755))
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"ls", "touch", tool},
+		WorkingDirectory:   dir,
+		MaxExecutionTime:   time.Second * 5,
+	}
+	executor := newCommandExecutor(config, logger)
+	handler := newShellHandler(newSecurityValidator(config, logger), executor, logger)
+
+	call := func(args map[string]interface{}) (*mcp.CallToolResult, CommandExplanation) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handler.explain(context.Background(), request)
+		require.NoError(t, err)
+
+		var exp CommandExplanation
+		if !result.IsError {
+			textContent, ok := mcp.AsTextContent(result.Content[0])
+			require.True(t, ok)
+			require.NoError(t, json.Unmarshal([]byte(textContent.Text), &exp))
+		}
+		return result, exp
+	}
+
+	// An allowed command is judged, not run.
+	result, exp := call(map[string]interface{}{"command": "touch " + marker})
+	require.False(t, result.IsError)
+	assert.True(t, exp.Allowed)
+	assert.Equal(t, modeSecure, exp.Mode)
+	require.Len(t, exp.Commands, 1)
+	assert.Equal(t, []string{"touch", marker}, exp.Commands[0].Argv)
+	assert.Equal(t, "touch", exp.Commands[0].AllowedBy)
+	assert.Equal(t, executor.pins.byEntry["touch"].path, exp.Commands[0].ResolvedPath)
+	assert.NoFileExists(t, marker)
+
+	// An executable replaced since startup is refused, as shell_exec would.
+	require.NoError(t, os.Remove(tool))
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\necho v2\n"), 0o755))
+	result, exp = call(map[string]interface{}{"command": "ls | " + tool})
+	require.False(t, result.IsError)
+	assert.False(t, exp.Allowed)
+	assert.Equal(t, ruleExecutablePin, exp.Rule)
+	assert.Contains(t, exp.Reason, "has been replaced since startup")
+	require.Len(t, exp.Commands, 2)
+	assert.True(t, exp.Commands[0].Allowed)
+	assert.False(t, exp.Commands[1].Allowed)
+	assert.Empty(t, exp.Commands[1].ResolvedPath)
+	_, err := execute(context.Background(), executor, "ls | "+tool, false)
+	assert.EqualError(t, err, exp.Reason)
+
+	// A rejected command is still a successful tool call.
+	result, exp = call(map[string]interface{}{"argv": []interface{}{"rm", marker}})
+	require.False(t, result.IsError)
+	assert.False(t, exp.Allowed)
+	assert.Equal(t, ruleAllowlist, exp.Rule)
+	assert.Equal(t, "executable 'rm' not in allowed list", exp.Reason)
+
+	result, _ = call(map[string]interface{}{})
+	assert.True(t, result.IsError)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// execHelperArg, as the first argument of the server's own binary, makes it
+// run as the exec helper instead of the server; see runExecHelper.
+const execHelperArg = "-mcp-shell-exec"
+
+// childSpec is what a helper applies to itself before it runs a command, so
+// that it is in force from the command's first instruction: Go cannot run
+// code in a child between fork and exec.
+type childSpec struct {
+	Rlimits    string         `json:"rlimits,omitempty"` // as encoded by rlimitSpec
+	Privileges *privilegeSpec `json:"privileges,omitempty"`
+	Landlock   *landlockRules `json:"landlock,omitempty"`
+	Seccomp    *seccompRules  `json:"seccomp,omitempty"`
+	// TraceExec has every exec after the command's own suspended until the
+	// server, listening at execTraceFd, has checked it.
+	TraceExec bool `json:"trace_exec,omitempty"`
+}
+
+func (s childSpec) empty() bool {
+	return s.Rlimits == "" && s.Privileges == nil && s.Landlock == nil && s.Seccomp == nil && !s.TraceExec
+}
+
+func (s childSpec) encode() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// apply applies s to the calling process. The Landlock ruleset and the
+// seccomp filter only bind the calling thread and what it starts, so that
+// thread must be locked and be the one that runs the command. Privileges
+// are dropped once the rlimits, which may need them, are set. The seccomp
+// profile comes last, as it may deny the syscalls the rest needs.
+func (s childSpec) apply() error {
+	if s.Rlimits != "" {
+		if err := applyRlimits(s.Rlimits); err != nil {
+			return err
+		}
+	}
+	if s.Privileges != nil {
+		if err := s.Privileges.apply(); err != nil {
+			return fmt.Errorf("privileges: %w", err)
+		}
+	}
+	if s.Landlock != nil {
+		if err := s.Landlock.restrictSelf(); err != nil {
+			return fmt.Errorf("landlock: %w", err)
+		}
+	}
+	if s.TraceExec {
+		if err := installExecListener(execTraceFd); err != nil {
+			return fmt.Errorf("exec tracing: %w", err)
+		}
+	}
+	if s.Seccomp != nil {
+		if err := s.Seccomp.install(); err != nil {
+			return fmt.Errorf("seccomp: %w", err)
+		}
+	}
+	return nil
+}
+
+// wrapWithHelper rewrites cmd to start through one of the helpers, the
+// server's own binary at helper re-executed with arg and spec, which sets the
+// process up and then executes what cmd would have.
+func wrapWithHelper(cmd *exec.Cmd, helper, arg, spec string) {
+	cmd.Args = append([]string{helper, arg, spec, cmd.Path}, cmd.Args...)
+	cmd.Path = helper
+}
+
+// runHelperIfRequested runs the exec or sandbox helper instead of the server
+// when the binary was started as one, and never returns then. main and
+// TestMain call it before anything else.
+func runHelperIfRequested() {
+	if len(os.Args) < 2 {
+		return
+	}
+	switch os.Args[1] {
+	case execHelperArg:
+		os.Exit(runExecHelper(os.Args[2:]))
+	case sandboxHelperArg:
+		os.Exit(runSandboxHelper(os.Args[2:]))
+	}
+}
+
+// runExecHelper is the exec helper. args are the encoded childSpec, the path
+// to run and its argv. It applies the spec to itself and replaces itself with
+// path. Limits above the current hard limit are lowered to it. It only
+// returns on failure, with the exit code a shell uses for a command it could
+// not run.
+func runExecHelper(args []string) int {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "mcp-shell: exec helper: missing arguments")
+		return 126
+	}
+	var spec childSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: exec helper: invalid spec: %v\n", err)
+		return 126
+	}
+	path, argv := args[1], args[2:]
+	runtime.LockOSThread()
+	if err := spec.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: exec helper: %v\n", err)
+		return 126
+	}
+	err := unix.Exec(path, argv, os.Environ())
+	fmt.Fprintf(os.Stderr, "mcp-shell: %s: %v\n", argv[0], err)
+	return 127
+}
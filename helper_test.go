@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChildSpec(t *testing.T) {
+	assert.True(t, childSpec{}.empty())
+	assert.False(t, childSpec{Rlimits: "nofile=64"}.empty())
+	assert.False(t, childSpec{Landlock: &landlockRules{ABI: 1}}.empty())
+
+	assert.Equal(t, `{}`, childSpec{}.encode())
+	assert.Equal(t,
+		`{"rlimits":"nofile=64","landlock":{"abi":3,"read_only":["/usr"],"read_write":["/work"]}}`,
+		childSpec{Rlimits: "nofile=64", Landlock: &landlockRules{ABI: 3, ReadOnly: []string{"/usr"}, ReadWrite: []string{"/work"}}}.encode())
+}
+
+func TestRunExecHelper_invalidArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing arguments", args: []string{`{}`}},
+		{name: "invalid spec", args: []string{`nofile=64`, "/bin/true", "true"}},
+		{name: "invalid rlimit", args: []string{`{"rlimits":"bogus=1"}`, "/bin/true", "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, 126, runExecHelper(tt.args))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// defaultMaxStdinSize caps a here-document or here-string when max_stdin_size
+// is unset.
+const defaultMaxStdinSize = 1048576 // 1MB
+
+// stdinData resolves a here-document (<<, <<-) or here-string (<<<) into the
+// bytes the command reads on stdin. A here-document whose delimiter is quoted
+// is taken verbatim; with a bare delimiter its body must be expansion-free,
+// and only the backslash escapes bash honours there (\$, \`, \\) are removed.
+// <<- strips leading tabs from every line. A here-string is a literal word
+// (expandable variables included, never glob- or brace-expanded) plus the
+// newline bash appends.
+func (u *commandUnfurler) stdinData(r *syntax.Redirect) ([]byte, error) {
+	var data string
+	if r.Op == syntax.WordHdoc {
+		var lit, pat strings.Builder
+		if _, err := u.literalParts(r.Word.Parts, false, &lit, &pat); err != nil {
+			return nil, fmt.Errorf("here-string: %w", err)
+		}
+		data = lit.String() + "\n"
+	} else {
+		body, err := heredocBody(r)
+		if err != nil {
+			return nil, err
+		}
+		data = body
+	}
+
+	if len(data) > u.maxStdinSize {
+		return nil, fmt.Errorf("here-document or here-string exceeds %d bytes", u.maxStdinSize)
+	}
+	return []byte(data), nil
+}
+
+// heredocBody returns a here-document's body as the command will read it.
+func heredocBody(r *syntax.Redirect) (string, error) {
+	var body strings.Builder
+	if r.Hdoc != nil {
+		quoted := delimiterQuoted(r.Word)
+		for _, part := range r.Hdoc.Parts {
+			lit, ok := part.(*syntax.Lit)
+			if !ok {
+				return "", errors.New("here-document bodies must be literal (quote the delimiter, as in <<'EOF', to disable expansion)")
+			}
+			if quoted {
+				body.WriteString(lit.Value)
+			} else {
+				body.WriteString(unescapeHeredoc(lit.Value))
+			}
+		}
+	}
+
+	if r.Op != syntax.DashHdoc {
+		return body.String(), nil
+	}
+	lines := strings.SplitAfter(body.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, "\t")
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// delimiterQuoted reports whether any part of a here-document delimiter is
+// quoted or escaped, which makes bash take the body verbatim.
+func delimiterQuoted(word *syntax.Word) bool {
+	for _, part := range word.Parts {
+		lit, ok := part.(*syntax.Lit)
+		if !ok || strings.Contains(lit.Value, `\`) {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeHeredoc removes the backslashes that escape $, ` and \ in the body
+// of an unquoted here-document; unlike inside double quotes, \" stays as is.
+// Line continuations are already removed by the parser.
+func unescapeHeredoc(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '$', '`', '\\':
+				i++
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
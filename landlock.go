@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Landlock unsupported policies: what the executor does when the kernel has
+// no Landlock.
+const (
+	landlockRefuse = "refuse"
+	landlockWarn   = "warn"
+)
+
+// landlockDevices are the device nodes Landlock leaves readable by default,
+// and landlockWritableDevices the ones it leaves writable too.
+var (
+	landlockDevices         = []string{"/dev/zero", "/dev/random", "/dev/urandom"}
+	landlockWritableDevices = []string{"/dev/null"}
+)
+
+// landlockRules is the Landlock ruleset a helper restricts itself with
+// before running a command: ReadOnly may be read and executed, ReadWrite
+// anything, and nothing else at all, with the access rights of ABI.
+type landlockRules struct {
+	ABI       int      `json:"abi"`
+	ReadOnly  []string `json:"read_only"`
+	ReadWrite []string `json:"read_write"`
+}
+
+// rules returns the ruleset for c, enforced with abi, around the working
+// directory workDir. Default paths missing on this host are left out.
+func (c LandlockConfig) rules(abi int, workDir string) *landlockRules {
+	readOnly := c.ReadOnlyPaths
+	if len(readOnly) == 0 {
+		readOnly = existingPaths(slices.Concat(defaultSandboxPaths, landlockDevices))
+	}
+	return &landlockRules{
+		ABI:       abi,
+		ReadOnly:  readOnly,
+		ReadWrite: append([]string{workDir}, existingPaths(landlockWritableDevices)...),
+	}
+}
+
+// existingPaths returns the paths that exist on this host.
+func existingPaths(paths []string) []string {
+	var out []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// validateLandlock checks the landlock block of security.yaml.
+func validateLandlock(cfg SecurityConfig) error {
+	for _, path := range cfg.Landlock.ReadOnlyPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("landlock.read_only_paths entry %q must be an absolute path", path)
+		}
+	}
+	switch cfg.Landlock.Unsupported {
+	case "", landlockRefuse, landlockWarn:
+	default:
+		return fmt.Errorf("landlock.unsupported must be %s or %s, not %q", landlockRefuse, landlockWarn, cfg.Landlock.Unsupported)
+	}
+	if cfg.Landlock.Enabled && cfg.WorkingDirectory == "" {
+		return fmt.Errorf("landlock requires a working_directory")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestLandlockHandledAccess(t *testing.T) {
+	abi1 := uint64(0x1fff)
+	tests := []struct {
+		abi  int
+		want uint64
+	}{
+		{abi: 1, want: abi1},
+		{abi: 2, want: abi1 | unix.LANDLOCK_ACCESS_FS_REFER},
+		{abi: 4, want: abi1 | unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE},
+		{abi: 7, want: abi1 | unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, landlockHandledAccess(tt.abi), "ABI %d", tt.abi)
+	}
+}
+
+func TestCommandExecutor_landlock(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("s3cret"), 0o644))
+
+	newExecutor := func(landlock LandlockConfig) *CommandExecutor {
+		landlock.Enabled = true
+		return newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			WorkingDirectory:  workspace,
+			Landlock:          landlock,
+		}, logger)
+	}
+
+	t.Run("unsupported kernel", func(t *testing.T) {
+		refusing := newExecutor(LandlockConfig{})
+		refusing.landlockABI = 0
+		_, err := execute(ctx, refusing, "true", false)
+		assert.ErrorContains(t, err, "landlock is not supported by this kernel")
+
+		warning := newExecutor(LandlockConfig{Unsupported: landlockWarn})
+		warning.landlockABI = 0
+		result, err := execute(ctx, warning, "cat "+filepath.Join(outside, "secret"), false)
+		require.NoError(t, err)
+		assert.Equal(t, "s3cret", result.Stdout)
+		assert.Zero(t, result.SecurityInfo.LandlockABI)
+	})
+
+	if landlockABI() == 0 {
+		t.Skip("Landlock is not supported by this kernel")
+	}
+	executor := newExecutor(LandlockConfig{})
+
+	tests := []struct {
+		name       string
+		command    string
+		wantStatus string
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "the working directory is writable",
+			command:    "echo hello > note && mkdir sub && mv note sub/ && cat sub/note",
+			wantStatus: "success",
+			wantStdout: "hello",
+		},
+		{
+			name:       "system paths are readable",
+			command:    "head -c 4 /etc/passwd > /dev/null && ls /usr > /dev/null",
+			wantStatus: "success",
+		},
+		{
+			name:       "system paths are not writable",
+			command:    "touch /etc/mcp-shell-probe",
+			wantStatus: "error",
+			wantStderr: "Permission denied",
+		},
+		{
+			name:       "other paths are not readable",
+			command:    "cat " + filepath.Join(outside, "secret"),
+			wantStatus: "error",
+			wantStderr: "Permission denied",
+		},
+		{
+			name:       "other paths are not writable",
+			command:    "echo x > " + filepath.Join(outside, "new"),
+			wantStatus: "error",
+			wantStderr: "Permission denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := execute(ctx, executor, tt.command, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status, result.Stderr)
+			assert.Equal(t, tt.wantStdout, result.Stdout)
+			assert.Contains(t, result.Stderr, tt.wantStderr)
+			assert.Equal(t, landlockABI(), result.SecurityInfo.LandlockABI)
+		})
+	}
+
+	t.Run("combined with the sandbox", func(t *testing.T) {
+		requireUserNamespaces(t)
+		sandboxed := newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			WorkingDirectory:  workspace,
+			Sandbox:           SandboxConfig{Enabled: true},
+			Landlock:          LandlockConfig{Enabled: true},
+		}, logger)
+		result, err := execute(ctx, sandboxed, "echo ok > note2 && cat note2 && touch /tmp/x", false)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", result.Stdout)
+		assert.Contains(t, result.Stderr, "Permission denied")
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Landlock exists on Linux only; elsewhere the kernel always counts as not
+// supporting it.
+
+func landlockABI() int { return 0 }
+
+func (*landlockRules) restrictSelf() error {
+	return errors.New("requires Linux")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLandlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SecurityConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{
+			name:   "enabled",
+			config: SecurityConfig{WorkingDirectory: "/tmp", Landlock: LandlockConfig{Enabled: true, Unsupported: "warn"}},
+		},
+		{
+			name:    "relative read-only path",
+			config:  SecurityConfig{Landlock: LandlockConfig{ReadOnlyPaths: []string{"etc"}}},
+			wantErr: `landlock.read_only_paths entry "etc" must be an absolute path`,
+		},
+		{
+			name:    "unknown unsupported policy",
+			config:  SecurityConfig{Landlock: LandlockConfig{Unsupported: "ignore"}},
+			wantErr: `landlock.unsupported must be refuse or warn, not "ignore"`,
+		},
+		{
+			name:    "no working directory",
+			config:  SecurityConfig{Landlock: LandlockConfig{Enabled: true}},
+			wantErr: "landlock requires a working_directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLandlock(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLandlockConfig_rules(t *testing.T) {
+	configured := LandlockConfig{ReadOnlyPaths: []string{"/opt/tools"}}.rules(3, "/work")
+	assert.Equal(t, 3, configured.ABI)
+	assert.Equal(t, []string{"/opt/tools"}, configured.ReadOnly)
+	assert.Equal(t, "/work", configured.ReadWrite[0])
+
+	defaults := LandlockConfig{}.rules(1, "/work")
+	assert.Equal(t, existingPaths(slices.Concat(defaultSandboxPaths, landlockDevices)), defaults.ReadOnly)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// rlimitSpec encodes the rlimits of l for the helper's command line, as
+// "as=1073741824,cpu=10", or returns "" when l sets none.
+func (l ResourceLimits) rlimitSpec() string {
+	var parts []string
+	for _, r := range []struct {
+		name  string
+		value int64
+	}{
+		{"as", l.AddressSpace},
+		{"cpu", l.CPUSeconds},
+		{"nproc", l.Processes},
+		{"fsize", l.FileSize},
+		{"nofile", l.OpenFiles},
+	} {
+		if r.value > 0 {
+			parts = append(parts, r.name+"="+strconv.FormatInt(r.value, 10))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cgroupControllers returns the cgroup v2 controllers l's cgroup limits need.
+func (l ResourceLimits) cgroupControllers() []string {
+	var out []string
+	if l.Memory > 0 {
+		out = append(out, "memory")
+	}
+	if l.Pids > 0 {
+		out = append(out, "pids")
+	}
+	if l.CPU > 0 {
+		out = append(out, "cpu")
+	}
+	return out
+}
+
+// validateLimits checks the limits block of security.yaml.
+func validateLimits(l ResourceLimits) error {
+	for _, v := range []struct {
+		name  string
+		value int64
+	}{
+		{"address_space", l.AddressSpace},
+		{"cpu_seconds", l.CPUSeconds},
+		{"processes", l.Processes},
+		{"file_size", l.FileSize},
+		{"open_files", l.OpenFiles},
+		{"memory", l.Memory},
+		{"pids", l.Pids},
+	} {
+		if v.value < 0 {
+			return fmt.Errorf("limits.%s cannot be negative", v.name)
+		}
+	}
+	if l.CPU < 0 {
+		return fmt.Errorf("limits.cpu cannot be negative")
+	}
+	if l.rlimitSpec() != "" && !rlimitsSupported {
+		return fmt.Errorf("limits: address_space, cpu_seconds, processes, file_size and open_files require Linux")
+	}
+	if l.CgroupParent != "" && !filepath.IsAbs(l.CgroupParent) {
+		return fmt.Errorf("limits.cgroup_parent %q must be an absolute path", l.CgroupParent)
+	}
+	return nil
+}
+
+// nameSet collects names, each once, in the order they were first added:
+// the limits an execution ran into, the seccomp profiles it violated. It is
+// safe for concurrent use.
+type nameSet struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (x *nameSet) add(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !slices.Contains(x.names, name) {
+		x.names = append(x.names, name)
+	}
+}
+
+func (x *nameSet) list() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return slices.Clone(x.names)
+}
+
+// limitExceededBy returns the limit a process killed by sig ran into, if
+// any: the kernel kills with SIGXCPU for cpu_seconds and SIGXFSZ for
+// file_size. Memory and pids are read from the execution's cgroup instead.
+func limitExceededBy(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGXCPU:
+		return "cpu_seconds"
+	case syscall.SIGXFSZ:
+		return "file_size"
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const rlimitsSupported = true
+
+// rlimitResources maps the names used in an rlimit spec to the
+// resources they limit.
+var rlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"cpu":    unix.RLIMIT_CPU,
+	"nproc":  unix.RLIMIT_NPROC,
+	"fsize":  unix.RLIMIT_FSIZE,
+	"nofile": unix.RLIMIT_NOFILE,
+}
+
+// applyRlimits sets the limits of spec, as encoded by rlimitSpec, on the
+// calling process, lowering any above the current hard limit to it.
+func applyRlimits(spec string) error {
+	for _, kv := range strings.Split(spec, ",") {
+		name, value, _ := strings.Cut(kv, "=")
+		resource, ok := rlimitResources[name]
+		n, err := strconv.ParseUint(value, 10, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("invalid limit %q", kv)
+		}
+		var cur unix.Rlimit
+		if err := unix.Getrlimit(resource, &cur); err != nil {
+			return fmt.Errorf("getrlimit %s: %w", name, err)
+		}
+		lim := unix.Rlimit{Cur: min(n, cur.Max), Max: min(n, cur.Max)}
+		if resource == unix.RLIMIT_CPU && lim.Max < cur.Max {
+			// SIGXCPU at the soft limit says why the command died; the
+			// hard limit's SIGKILL would not.
+			lim.Max++
+		}
+		if err := unix.Setrlimit(resource, &lim); err != nil {
+			return fmt.Errorf("setrlimit %s: %w", name, err)
+		}
+	}
+	return nil
+}
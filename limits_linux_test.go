@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyRlimits_invalidSpec(t *testing.T) {
+	assert.EqualError(t, applyRlimits("bogus=1"), `invalid limit "bogus=1"`)
+	assert.EqualError(t, applyRlimits("nofile=lots"), `invalid limit "nofile=lots"`)
+}
+
+func TestCommandExecutor_rlimits(t *testing.T) {
+	if _, err := os.Stat("/proc/self/limits"); err != nil {
+		t.Skip("needs /proc")
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	t.Run("limits are in force in the child", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{
+			Enabled:            true,
+			AllowedExecutables: []string{"cat"},
+			MaxExecutionTime:   5 * time.Second,
+			Limits:             ResourceLimits{OpenFiles: 64, CPUSeconds: 5, FileSize: 1 << 20},
+		}, logger)
+
+		result, err := execute(ctx, executor, "cat /proc/self/limits", false)
+		require.NoError(t, err)
+		require.Equal(t, "success", result.Status, result.Stderr)
+
+		limits := map[string][]string{}
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 {
+				limits[strings.Join(fields[:len(fields)-3], " ")] = fields[len(fields)-3 : len(fields)-1]
+			}
+		}
+		assert.Equal(t, []string{"64", "64"}, limits["Max open files"])
+		assert.Equal(t, []string{"5", "6"}, limits["Max cpu time"])
+		assert.Equal(t, []string{"1048576", "1048576"}, limits["Max file size"])
+		assert.Empty(t, result.LimitsExceeded)
+	})
+
+	t.Run("a process killed for a limit is reported", func(t *testing.T) {
+		dir := t.TempDir()
+		executor := newCommandExecutor(SecurityConfig{
+			Enabled:            true,
+			AllowedExecutables: []string{"head"},
+			MaxExecutionTime:   5 * time.Second,
+			WorkingDirectory:   dir,
+			Limits:             ResourceLimits{FileSize: 1024},
+		}, logger)
+
+		result, err := execute(ctx, executor, "head -c 4096 /dev/zero > big", false)
+		require.NoError(t, err)
+		assert.Equal(t, "error", result.Status)
+		assert.Equal(t, []string{"file_size"}, result.LimitsExceeded)
+
+		info, err := os.Stat(filepath.Join(dir, "big"))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(1024))
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// The rlimits are Linux only: the resources and the types limiting them
+// differ from one platform to the next.
+const rlimitsSupported = false
+
+func applyRlimits(string) error {
+	return errors.New("rlimits require Linux")
+}
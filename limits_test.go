@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary serve as the rlimit and sandbox helpers,
+// since that is what os.Executable returns under go test.
+func TestMain(m *testing.M) {
+	runHelperIfRequested()
+	os.Exit(m.Run())
+}
+
+func TestResourceLimits(t *testing.T) {
+	tests := []struct {
+		name            string
+		limits          ResourceLimits
+		wantSpec        string
+		wantControllers []string
+	}{
+		{name: "none"},
+		{
+			name:     "rlimits",
+			limits:   ResourceLimits{AddressSpace: 1 << 30, CPUSeconds: 10, Processes: 64, FileSize: 1 << 20, OpenFiles: 256},
+			wantSpec: "as=1073741824,cpu=10,nproc=64,fsize=1048576,nofile=256",
+		},
+		{
+			name:            "cgroup limits",
+			limits:          ResourceLimits{Memory: 1 << 28, Pids: 32, CPU: 0.5},
+			wantControllers: []string{"memory", "pids", "cpu"},
+		},
+		{
+			name:            "some of each",
+			limits:          ResourceLimits{CPUSeconds: 5, Pids: 32},
+			wantSpec:        "cpu=5",
+			wantControllers: []string{"pids"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantSpec, tt.limits.rlimitSpec())
+			assert.Equal(t, tt.wantControllers, tt.limits.cgroupControllers())
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  ResourceLimits
+		wantErr string
+	}{
+		{name: "empty"},
+		{name: "valid", limits: ResourceLimits{AddressSpace: 1 << 30, Memory: 1 << 28, CPU: 1.5, CgroupParent: "/sys/fs/cgroup/mcp"}},
+		{name: "negative rlimit", limits: ResourceLimits{OpenFiles: -1}, wantErr: "limits.open_files cannot be negative"},
+		{name: "negative cgroup limit", limits: ResourceLimits{Pids: -1}, wantErr: "limits.pids cannot be negative"},
+		{name: "negative cpu", limits: ResourceLimits{CPU: -0.5}, wantErr: "limits.cpu cannot be negative"},
+		{name: "relative cgroup parent", limits: ResourceLimits{CgroupParent: "mcp"}, wantErr: `limits.cgroup_parent "mcp" must be an absolute path`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !rlimitsSupported && tt.limits.rlimitSpec() != "" && tt.wantErr == "" {
+				tt.wantErr = "limits: address_space, cpu_seconds, processes, file_size and open_files require Linux"
+			}
+			err := validateLimits(tt.limits)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 var version = "dev"
 
 func main() {
+	runHelperIfRequested()
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -75,7 +76,7 @@ func run() error {
 	}
 
 	validator := newSecurityValidator(cfg.Security, log)
-	executor := newCommandExecutor(cfg.Security, log)
+	executor := newExecutor(cfg.Security, log)
 	shellHandler := newShellHandler(validator, executor, log)
 
 	s := server.NewMCPServer(
@@ -90,8 +91,17 @@ func run() error {
 			"Execute shell commands with configurable security constraints. Returns structured JSON with stdout, stderr, exit code and execution metadata.",
 		),
 		mcp.WithString("command",
-			mcp.Required(),
-			mcp.Description("Shell command to execute"),
+			mcp.Description("Shell command to execute. Exactly one of command or argv is required"),
+		),
+		mcp.WithArray("argv",
+			mcp.WithStringItems(),
+			mcp.Description("Executable and arguments to run as is, with no shell parsing or quoting. Alternative to command"),
+		),
+		mcp.WithString("cwd",
+			mcp.Description("Directory to run in, relative to the configured working directory, which it cannot leave. Defaults to the working directory"),
+		),
+		mcp.WithNumber("timeout",
+			mcp.Description("Timeout in seconds. Defaults to the server's max_execution_time and is capped at its max_timeout"),
 		),
 		mcp.WithBoolean(
 			"base64",
@@ -102,7 +112,25 @@ func run() error {
 		),
 	)
 
+	explainTool := mcp.NewTool(
+		"shell_explain",
+		mcp.WithDescription(
+			"Dry run: report how shell_exec would judge a command without executing it. Returns the parsed argv, resolved executable path, the allowlist entry and argument policy that apply, and the exact rule that would reject it.",
+		),
+		mcp.WithString("command",
+			mcp.Description("Shell command to judge. Exactly one of command or argv is required"),
+		),
+		mcp.WithArray("argv",
+			mcp.WithStringItems(),
+			mcp.Description("Executable and arguments to judge as a structured argv. Alternative to command"),
+		),
+		mcp.WithString("cwd",
+			mcp.Description("Directory to judge the command as running in, as for shell_exec"),
+		),
+	)
+
 	s.AddTool(shellTool, shellHandler.handle)
+	s.AddTool(explainTool, shellHandler.explain)
 
 	log.Info().Msg("MCP server initialized, serving on stdio")
 
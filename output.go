@@ -0,0 +1,98 @@
+package main
+
+import "fmt"
+
+// cappedBuffer is an io.Writer that keeps a bounded window of what is written
+// to it: the first headSize bytes and the last tailSize bytes. Everything in
+// between is counted and dropped, so however much a command writes, its
+// output never costs the server more than the cap.
+type cappedBuffer struct {
+	headSize int
+	tailSize int
+	head     []byte
+	// ring holds the tail once head is full; pos is where the next byte goes
+	// and filled how much of it is in use.
+	ring   []byte
+	pos    int
+	filled int
+	total  int64
+}
+
+// newCappedBuffer returns a buffer keeping at most limit bytes, tail of them
+// from the end of the output and the rest from its start. A limit of zero or
+// less keeps everything.
+func newCappedBuffer(limit, tail int) *cappedBuffer {
+	if limit <= 0 {
+		return &cappedBuffer{headSize: -1}
+	}
+	tail = min(max(tail, 0), limit)
+	return &cappedBuffer{headSize: limit - tail, tailSize: tail}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+
+	if b.headSize < 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+	if room := b.headSize - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	if len(p) == 0 || b.tailSize == 0 {
+		return n, nil
+	}
+
+	if b.ring == nil {
+		b.ring = make([]byte, b.tailSize)
+	}
+	if len(p) >= b.tailSize {
+		copy(b.ring, p[len(p)-b.tailSize:])
+		b.pos, b.filled = 0, b.tailSize
+		return n, nil
+	}
+	k := copy(b.ring[b.pos:], p)
+	copy(b.ring, p[k:])
+	b.pos = (b.pos + len(p)) % b.tailSize
+	b.filled = min(b.filled+len(p), b.tailSize)
+	return n, nil
+}
+
+// written is the number of bytes written, kept or not.
+func (b *cappedBuffer) written() int64 {
+	return b.total
+}
+
+// dropped is the number of bytes written but not kept.
+func (b *cappedBuffer) dropped() int64 {
+	return b.total - int64(len(b.head)+b.filled)
+}
+
+// Bytes returns the kept output: the head followed by the tail, with the
+// dropped middle simply missing.
+func (b *cappedBuffer) Bytes() []byte {
+	out := make([]byte, 0, len(b.head)+b.filled)
+	out = append(out, b.head...)
+	return append(out, b.tailBytes()...)
+}
+
+// String returns the kept output as text, with a marker where the dropped
+// bytes were.
+func (b *cappedBuffer) String() string {
+	if b.dropped() == 0 {
+		return string(b.Bytes())
+	}
+	return fmt.Sprintf("%s\n[... %d bytes truncated ...]\n%s", b.head, b.dropped(), b.tailBytes())
+}
+
+func (b *cappedBuffer) tailBytes() []byte {
+	if b.filled < b.tailSize {
+		return b.ring[:b.filled]
+	}
+	out := make([]byte, 0, b.tailSize)
+	out = append(out, b.ring[b.pos:]...)
+	return append(out, b.ring[:b.pos]...)
+}
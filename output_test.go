@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		tail        int
+		writes      []string
+		wantBytes   string
+		wantString  string
+		wantDropped int64
+	}{
+		{name: "under the limit", limit: 10, tail: 5, writes: []string{"abc", "def"}, wantBytes: "abcdef", wantString: "abcdef"},
+		{name: "exactly the limit", limit: 6, tail: 3, writes: []string{"abcdef"}, wantBytes: "abcdef", wantString: "abcdef"},
+		{
+			name: "head and tail kept", limit: 6, tail: 3, writes: []string{"abcdefghij"},
+			wantBytes: "abchij", wantString: "abc\n[... 4 bytes truncated ...]\nhij", wantDropped: 4,
+		},
+		{
+			name: "tail wraps across small writes", limit: 4, tail: 2, writes: []string{"a", "b", "c", "d", "e", "f", "g"},
+			wantBytes: "abfg", wantString: "ab\n[... 3 bytes truncated ...]\nfg", wantDropped: 3,
+		},
+		{
+			name: "write straddling head and tail", limit: 4, tail: 2, writes: []string{"a", "bcdefg", "h"},
+			wantBytes: "abgh", wantString: "ab\n[... 4 bytes truncated ...]\ngh", wantDropped: 4,
+		},
+		{
+			name: "head only", limit: 3, tail: 0, writes: []string{"abcdef"},
+			wantBytes: "abc", wantString: "abc\n[... 3 bytes truncated ...]\n", wantDropped: 3,
+		},
+		{
+			name: "tail only", limit: 3, tail: 3, writes: []string{"abcdef"},
+			wantBytes: "def", wantString: "\n[... 3 bytes truncated ...]\ndef", wantDropped: 3,
+		},
+		{name: "no limit", limit: 0, writes: []string{"abc", "def"}, wantBytes: "abcdef", wantString: "abcdef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCappedBuffer(tt.limit, tt.tail)
+			var total int64
+			for _, w := range tt.writes {
+				n, err := b.Write([]byte(w))
+				require.NoError(t, err)
+				assert.Equal(t, len(w), n)
+				total += int64(len(w))
+			}
+			assert.Equal(t, tt.wantBytes, string(b.Bytes()))
+			assert.Equal(t, tt.wantString, b.String())
+			assert.Equal(t, tt.wantDropped, b.dropped())
+			assert.Equal(t, total, b.written())
+		})
+	}
+
+	t.Run("memory stays bounded", func(t *testing.T) {
+		b := newCappedBuffer(1024, 512)
+		chunk := []byte(strings.Repeat("x", 4096))
+		for range 1000 {
+			_, _ = b.Write(chunk)
+		}
+		assert.Len(t, b.Bytes(), 1024)
+		assert.LessOrEqual(t, cap(b.head), 1024)
+		assert.Len(t, b.ring, 512)
+		assert.Equal(t, int64(4096*1000-1024), b.dropped())
+	})
+}
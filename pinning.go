@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+var sha256HexRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// executablePin is an allowed_executables entry resolved once, at startup, to
+// the absolute path secure mode will execute for it, together with the file's
+// identity and optionally its SHA-256 at that moment.
+type executablePin struct {
+	entry string
+	path  string
+	info  os.FileInfo
+	sum   string
+	// err is why the entry cannot be executed at all: it did not resolve, or
+	// its content did not match the pinned hash.
+	err error
+}
+
+// executablePins maps every allowed_executables entry to its pin. Commands are
+// matched against entries the way matchesExecutable does, but run the pinned
+// path instead of whatever PATH resolves argv[0] to at execution time.
+type executablePins struct {
+	byEntry map[string]*executablePin
+	order   []string
+}
+
+// newExecutablePins resolves cfg.AllowedExecutables. A bare name is looked up
+// now and never again, in environment.path or else the server's PATH. Hashes are recorded when
+// verify_executable_hashes is on, or taken from executable_hashes, in which
+// case the binary must match the pinned hash already at startup.
+func newExecutablePins(cfg SecurityConfig, logger zerolog.Logger) *executablePins {
+	pins := &executablePins{byEntry: make(map[string]*executablePin, len(cfg.AllowedExecutables))}
+	for _, entry := range cfg.AllowedExecutables {
+		if _, dup := pins.byEntry[entry]; dup {
+			continue
+		}
+		pin := pinExecutable(entry, cfg.childPath(), cfg.VerifyExecutableHashes, cfg.ExecutableHashes[entry])
+		pins.byEntry[entry] = pin
+		pins.order = append(pins.order, entry)
+
+		if pin.err != nil {
+			logger.Warn().
+				Err(pin.err).
+				Str("executable", entry).
+				Str("audit", "executable_unpinned").
+				Msg("Allowed executable cannot be pinned and will not be run")
+			continue
+		}
+		logger.Debug().
+			Str("executable", entry).
+			Str("path", pin.path).
+			Str("sha256", pin.sum).
+			Msg("Pinned allowed executable")
+	}
+	return pins
+}
+
+// pinExecutable pins one entry, resolving a bare name in pathList (the
+// server's PATH when empty).
+func pinExecutable(entry, pathList string, hash bool, pinned string) *executablePin {
+	pin := &executablePin{entry: entry}
+
+	path := entry
+	if !filepath.IsAbs(path) {
+		found, err := lookPath(entry, pathList)
+		if err != nil {
+			pin.err = fmt.Errorf("resolve %q: %w", entry, err)
+			return pin
+		}
+		if path, err = filepath.Abs(found); err != nil {
+			pin.err = fmt.Errorf("resolve %q: %w", entry, err)
+			return pin
+		}
+	}
+	pin.path = path
+
+	info, err := os.Stat(path)
+	if err != nil {
+		pin.err = fmt.Errorf("stat %q: %w", path, err)
+		return pin
+	}
+	if !info.Mode().IsRegular() {
+		pin.err = fmt.Errorf("%q is not a regular file", path)
+		return pin
+	}
+	pin.info = info
+
+	if !hash && pinned == "" {
+		return pin
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		pin.err = fmt.Errorf("hash %q: %w", path, err)
+		return pin
+	}
+	if pinned != "" && sum != pinned {
+		pin.err = fmt.Errorf("%q has SHA-256 %s, not the pinned %s", path, sum, pinned)
+		return pin
+	}
+	pin.sum = sum
+	return pin
+}
+
+// lookup returns the pin of the first entry executable matches, with the
+// matching rules of matchesExecutable: the entry itself, an absolute entry
+// naming the same path, or a bare entry naming the same basename.
+func (p *executablePins) lookup(executable string) (*executablePin, bool) {
+	for _, entry := range p.order {
+		pin := p.byEntry[entry]
+		switch {
+		case executable == entry:
+			return pin, true
+		case filepath.IsAbs(entry):
+			if abs, err := filepath.Abs(executable); err == nil && abs == entry {
+				return pin, true
+			}
+		case !filepath.IsAbs(executable) && filepath.Base(executable) == entry:
+			return pin, true
+		}
+	}
+	return nil, false
+}
+
+// verify checks, right before execution, that the pinned path is still the
+// file seen at startup and, when a hash was recorded, that its content is
+// unchanged.
+func (pin *executablePin) verify() error {
+	if pin.err != nil {
+		return fmt.Errorf("executable %q cannot be run: %w", pin.entry, pin.err)
+	}
+	info, err := os.Stat(pin.path)
+	if err != nil {
+		return fmt.Errorf("executable %q (%s) has been replaced since startup: %w", pin.entry, pin.path, err)
+	}
+	if !os.SameFile(pin.info, info) || info.Size() != pin.info.Size() ||
+		!info.ModTime().Equal(pin.info.ModTime()) || info.Mode() != pin.info.Mode() {
+		return fmt.Errorf("executable %q (%s) has been replaced since startup", pin.entry, pin.path)
+	}
+	if pin.sum == "" {
+		return nil
+	}
+	sum, err := fileSHA256(pin.path)
+	if err != nil {
+		return fmt.Errorf("hash executable %q (%s): %w", pin.entry, pin.path, err)
+	}
+	if sum != pin.sum {
+		return fmt.Errorf("executable %q (%s) has been modified since startup", pin.entry, pin.path)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeScript writes an executable shell script and returns its path.
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(p, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+	return p
+}
+
+func TestExecutablePins_lookup(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	dir := t.TempDir()
+	tool := writeScript(t, dir, "tool", "true")
+
+	pins := newExecutablePins(SecurityConfig{
+		AllowedExecutables: []string{"ls", tool, "definitely_absent_zzz"},
+	}, logger)
+
+	lsPath, err := exec.LookPath("ls")
+	require.NoError(t, err)
+	lsPath, err = filepath.Abs(lsPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		executable string
+		wantEntry  string
+		wantPath   string
+	}{
+		{name: "bare entry", executable: "ls", wantEntry: "ls", wantPath: lsPath},
+		{name: "relative path to a bare entry runs the pinned file", executable: "./ls", wantEntry: "ls", wantPath: lsPath},
+		{name: "absolute entry", executable: tool, wantEntry: tool, wantPath: tool},
+		{name: "absolute path not on the list", executable: "/usr/bin/ls"},
+		{name: "unknown name", executable: "rm"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin, ok := pins.lookup(tt.executable)
+			if tt.wantEntry == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.wantEntry, pin.entry)
+			assert.Equal(t, tt.wantPath, pin.path)
+			assert.NoError(t, pin.verify())
+		})
+	}
+
+	pin, ok := pins.lookup("definitely_absent_zzz")
+	require.True(t, ok)
+	assert.ErrorContains(t, pin.verify(), `executable "definitely_absent_zzz" cannot be run`)
+}
+
+func TestExecutablePin_verify(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("replaced by rename", func(t *testing.T) {
+		tool := writeScript(t, dir, "renamed", "echo one")
+		pin := pinExecutable(tool, "", false, "")
+		require.NoError(t, pin.verify())
+
+		other := writeScript(t, dir, "other", "echo two")
+		require.NoError(t, os.Rename(other, tool))
+		assert.ErrorContains(t, pin.verify(), "has been replaced since startup")
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		tool := writeScript(t, dir, "removed", "true")
+		pin := pinExecutable(tool, "", false, "")
+		require.NoError(t, os.Remove(tool))
+		assert.ErrorContains(t, pin.verify(), "has been replaced since startup")
+	})
+
+	t.Run("rewritten in place with the same size and mtime", func(t *testing.T) {
+		tool := writeScript(t, dir, "inplace", "echo aaa")
+		info, err := os.Stat(tool)
+		require.NoError(t, err)
+		pin := pinExecutable(tool, "", true, "")
+		require.NoError(t, pin.verify())
+		require.Len(t, pin.sum, 64)
+
+		require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\necho bbb\n"), 0o755))
+		require.NoError(t, os.Chtimes(tool, info.ModTime(), info.ModTime()))
+		assert.ErrorContains(t, pin.verify(), "has been modified since startup")
+	})
+
+	t.Run("pinned hash", func(t *testing.T) {
+		tool := writeScript(t, dir, "pinned", "true")
+		sum, err := fileSHA256(tool)
+		require.NoError(t, err)
+
+		assert.NoError(t, pinExecutable(tool, "", false, sum).verify())
+
+		wrong := "0000000000000000000000000000000000000000000000000000000000000000"
+		err = pinExecutable(tool, "", false, wrong).verify()
+		assert.ErrorContains(t, err, "not the pinned "+wrong)
+	})
+}
+
+func TestCommandExecutor_pinnedExecutables(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	dir := t.TempDir()
+	tool := writeScript(t, dir, "tool", "echo original")
+
+	executor := newCommandExecutor(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"echo", tool},
+		WorkingDirectory:   dir,
+		MaxExecutionTime:   5 * time.Second,
+	}, logger)
+
+	// A PATH change after startup cannot redirect a bare name.
+	hijack := t.TempDir()
+	writeScript(t, hijack, "echo", "echo hijacked")
+	t.Setenv("PATH", hijack+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	result, err := execute(context.Background(), executor, "echo pinned", false)
+	require.NoError(t, err)
+	assert.Equal(t, "pinned", result.Stdout)
+
+	result, err = executor.executeArgv(context.Background(), []string{tool}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "original", result.Stdout)
+
+	replacement := writeScript(t, dir, "replacement", "echo replaced")
+	require.NoError(t, os.Rename(replacement, tool))
+
+	_, err = execute(context.Background(), executor, "echo ok && "+tool, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has been replaced since startup")
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// processSetup is the per-execution process context shared by every stage of a
+// pipeline: the working directory, the root redirections are confined to,
+// the environment (nil inherits the server's), when run_as_user is set,
+// credentials, and the termination that stops every stage's process group.
+// With rlimits, Landlock or seccomp profiles configured, stages start through
+// the exec helper with child, plus the stage's profile from seccomp, or with
+// the sandbox enabled through the sandbox helper with sandbox. With exec
+// tracing, trace checks the execs their process trees make. With cgroup
+// limits they start inside cgroup. The limits they run into are recorded in
+// exceeded, the seccomp profiles they violate in violations, and the execs
+// trace rejects in execViolations. On the ssh backend, remote runs every
+// stage on the remote host instead.
+type processSetup struct {
+	dir            string
+	root           string
+	env            []string
+	attr           *syscall.SysProcAttr
+	term           *groupTermination
+	helper         string
+	child          childSpec
+	seccomp        *seccompPolicy
+	sandbox        *sandboxSpec
+	trace          *execTracer
+	remote         *sshRemote
+	cgroup         *execCgroup
+	exceeded       *nameSet
+	violations     *nameSet
+	execViolations *nameSet
+}
+
+// lockedWriter serialises writes from concurrently running stages that share
+// one destination (every stage of a pipeline writes to the same stderr).
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// runPipeline starts every stage with its stdout wired to the next stage's
+// stdin through an os.Pipe, the way a shell would, but without one. The last
+// stage writes to stdout; all stages share stderr, which must be safe for
+// concurrent use. Each stage's redirections are then applied on top. It
+// returns each stage's exit code in pipeline order; a stage that could not be
+// started reports -1 (or 1 when a redirection failed, as in a shell) and the
+// rest still run, reading EOF or hitting EPIPE where it would have been.
+//
+// Every stage leads a process group of its own. When ctx is done, setup.term
+// signals the groups, so what a stage forked is stopped with it, and the
+// WaitDelay keeps a descendant that escaped its group and still holds an
+// output pipe from blocking the wait.
+func runPipeline(ctx context.Context, stages []*plannedCommand, setup processSetup, stdout, stderr io.Writer) ([]int, error) {
+	cmds := make([]*exec.Cmd, len(stages))
+	profiles := make([]*seccompRules, len(stages))
+	traces := make([]*execTrace, len(stages))
+	signals := make([]*os.File, len(stages))
+	started := make([]bool, len(stages))
+	defer func() {
+		for i, trace := range traces {
+			if trace != nil {
+				trace.stop(started[i])
+			}
+		}
+		for _, r := range signals {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+
+	// The parent's copies of every pipe end and redirected file must be closed
+	// once the stages have started, otherwise readers never observe EOF.
+	var parentFiles []*os.File
+	closeParentFiles := func() {
+		for _, f := range parentFiles {
+			f.Close()
+		}
+		parentFiles = nil
+	}
+	defer closeParentFiles()
+
+	for i, stage := range stages {
+		argv := stage.Argv
+		cmd := exec.Command(argv[0], argv[1:]...)
+		if stage.Path != "" {
+			// Run the pinned file, keeping argv[0] as typed for tools that
+			// look at the name they were invoked by.
+			cmd = exec.Command(stage.Path, argv[1:]...)
+			cmd.Args[0] = argv[0]
+		}
+		cmd.Dir = setup.dir
+		var attr syscall.SysProcAttr
+		if setup.attr != nil {
+			attr = *setup.attr
+		}
+		attr.Setpgid = true
+		if setup.cgroup != nil {
+			setup.cgroup.apply(&attr)
+		}
+		cmd.SysProcAttr = &attr
+		child := setup.child
+		child.Seccomp = setup.seccomp.rulesFor(argv[0])
+		profiles[i] = child.Seccomp
+		if setup.trace != nil {
+			trace, err := setup.trace.attach(cmd, setup.execViolations)
+			if err != nil {
+				return nil, fmt.Errorf("exec tracing: %w", err)
+			}
+			traces[i] = trace
+			child.TraceExec = true
+		}
+		switch {
+		case setup.sandbox != nil:
+			spec := *setup.sandbox
+			spec.Child = child
+			r, w, err := os.Pipe()
+			if err != nil {
+				return nil, fmt.Errorf("create pipe: %w", err)
+			}
+			signals[i] = r
+			parentFiles = append(parentFiles, w)
+			spec.SignalFd = execTraceFd + len(cmd.ExtraFiles)
+			cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+			wrapWithHelper(cmd, setup.helper, sandboxHelperArg, spec.encode())
+		case !child.empty():
+			wrapWithHelper(cmd, setup.helper, execHelperArg, child.encode())
+		}
+		cmd.WaitDelay = setup.term.grace
+		cmd.Stderr = stderr
+		switch {
+		case setup.remote != nil:
+			// The stage's assignments are made by the remote command, never
+			// in the client's environment.
+			setup.remote.wrap(cmd, stage, setup.dir)
+		case setup.env != nil || len(stage.Env) > 0:
+			base := setup.env
+			if base == nil {
+				base = os.Environ()
+			}
+			// Later entries win, so the assignments override the base
+			// environment for this stage only. The slice is always non-nil:
+			// a nil Env would inherit the server's.
+			env := make([]string, 0, len(base)+len(stage.Env))
+			cmd.Env = append(append(env, base...), stage.Env...)
+		}
+		cmds[i] = cmd
+	}
+
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("create pipe: %w", err)
+		}
+		parentFiles = append(parentFiles, r, w)
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+	}
+	cmds[len(cmds)-1].Stdout = stdout
+
+	status := make([]int, len(cmds))
+	var pgids []int
+	for i, cmd := range cmds {
+		opened, err := applyRedirects(cmd, stages[i].Redirs, setup.root)
+		parentFiles = append(parentFiles, opened...)
+		if err != nil {
+			fmt.Fprintf(stderr, "mcp-shell: %v\n", err)
+			status[i] = 1
+			continue
+		}
+		if ctx.Err() != nil {
+			status[i] = -1
+			continue
+		}
+		if err := cmd.Start(); err != nil {
+			status[i] = -1
+			continue
+		}
+		started[i] = true
+		pgids = append(pgids, cmd.Process.Pid)
+		if traces[i] != nil {
+			traces[i].start(cmd.Process.Pid)
+		}
+	}
+	closeParentFiles()
+
+	stop := setup.term.watch(ctx, pgids)
+	for i, cmd := range cmds {
+		if started[i] {
+			status[i] = exitCodeOf(cmd.Wait())
+			sig := exitSignal(cmd.ProcessState)
+			if signals[i] != nil {
+				// The sandbox helper survives the command and reports the
+				// signal that killed it.
+				if reported := sandboxSignal(signals[i]); reported != 0 {
+					sig, status[i] = reported, -1
+				}
+			}
+			if limit := limitExceededBy(sig); limit != "" {
+				setup.exceeded.add(limit)
+			}
+			if sig == syscall.SIGSYS && profiles[i].kills() {
+				setup.violations.add(stages[i].Argv[0] + ": " + profiles[i].Profile)
+			}
+			if traces[i] != nil {
+				traces[i].stop(true)
+				traces[i] = nil
+			}
+		}
+	}
+	stop()
+	return status, nil
+}
+
+// applyRedirects rewires a stage's stdio in source order, the way a shell
+// processes redirections left to right, so `> f 2>&1` and `2>&1 > f` differ as
+// they should. Every file is opened here by mcp-shell, re-confined to root at
+// the moment of opening. The opened files are returned for the caller to close
+// once the stage has started.
+func applyRedirects(cmd *exec.Cmd, redirs []plannedRedirect, root string) ([]*os.File, error) {
+	if len(redirs) == 0 {
+		return nil, nil
+	}
+
+	out := [3]io.Writer{nil, cmd.Stdout, cmd.Stderr}
+	var opened []*os.File
+	for _, r := range redirs {
+		switch r.Op {
+		case redirIn:
+			f, err := openInRoot(root, r.Path, os.O_RDONLY)
+			if err != nil {
+				return opened, err
+			}
+			opened = append(opened, f)
+			cmd.Stdin = f
+		case redirOut, redirAppend:
+			flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if r.Op == redirAppend {
+				flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			f, err := openInRoot(root, r.Path, flag)
+			if err != nil {
+				return opened, err
+			}
+			opened = append(opened, f)
+			out[r.Fd] = f
+		case redirDup:
+			out[r.Fd] = out[r.DupFd]
+		case redirData:
+			cmd.Stdin = bytes.NewReader(r.Data)
+		}
+	}
+	cmd.Stdout, cmd.Stderr = out[1], out[2]
+	return opened, nil
+}
+
+// exitSignal returns the signal that killed a process, or zero.
+func exitSignal(state *os.ProcessState) syscall.Signal {
+	if state == nil {
+		return 0
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal()
+	}
+	return 0
+}
+
+// exitCodeOf maps a process error to its exit code: 0 on success, the
+// process's own code when it exited, and -1 when it never ran or was killed.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// pipelineExitCode folds per-stage exit codes into the pipeline's single exit
+// code. Without pipefail it is the last stage's code, as in a POSIX shell; with
+// it, the rightmost non-zero code, so an upstream failure is not masked.
+func pipelineExitCode(status []int, pipefail bool) int {
+	if !pipefail {
+		return status[len(status)-1]
+	}
+	for i := len(status) - 1; i >= 0; i-- {
+		if status[i] != 0 {
+			return status[i]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// privilegeSpec is what a helper gives up before it runs a command: with
+// Switch, its identity for Uid, Gid and exactly Groups, and in any case every
+// capability it holds, with no_new_privs set so that none can be regained.
+type privilegeSpec struct {
+	Switch bool  `json:"switch,omitempty"`
+	Uid    int   `json:"uid"`
+	Gid    int   `json:"gid"`
+	Groups []int `json:"groups"`
+}
+
+// identity is the uid, gid and supplementary groups commands run with.
+type identity struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+// lookupRunAsUser resolves run_as_user into its uid and primary gid.
+func lookupRunAsUser(name string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("resolve run-as user %q: %w", name, err)
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, fmt.Errorf("resolve run-as user %q: parse uid %q: %w", name, u.Uid, err)
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, fmt.Errorf("resolve run-as user %q: parse gid %q: %w", name, u.Gid, err)
+	}
+	return uid, gid, nil
+}
+
+// resolveIdentity resolves the identity commands run with under privileges:
+// run_as_user, or else the server's own uid and gid, with groups, by name or
+// id, as the only supplementary groups.
+func resolveIdentity(runAsUser string, groups []string) (identity, error) {
+	id := identity{uid: os.Getuid(), gid: os.Getgid(), groups: []int{}}
+	if runAsUser != "" {
+		var err error
+		if id.uid, id.gid, err = lookupRunAsUser(runAsUser); err != nil {
+			return id, err
+		}
+	}
+	for _, name := range groups {
+		gid, err := strconv.Atoi(name)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(name)
+			if lookupErr != nil {
+				return id, fmt.Errorf("resolve group %q: %w", name, lookupErr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return id, fmt.Errorf("resolve group %q: parse gid %q: %w", name, g.Gid, err)
+			}
+		}
+		if !slices.Contains(id.groups, gid) {
+			id.groups = append(id.groups, gid)
+		}
+	}
+	return id, nil
+}
+
+// credentials returns the credentials e's commands run with. Without
+// privileges, or with only the sandbox dropping capabilities, the command
+// keeps whatever supplementary groups the server has; with run_as_user alone,
+// Go's switch leaves it none.
+func (e *CommandExecutor) credentials() *Credentials {
+	c := &Credentials{Uid: os.Getuid(), Gid: os.Getgid()}
+	switch {
+	case e.config.Privileges.Enabled:
+		c.Uid, c.Gid, c.Groups = e.identity.uid, e.identity.gid, e.identity.groups
+		c.NoNewPrivs, c.CapabilitiesDropped = true, true
+	case e.config.RunAsUser != "":
+		uid, gid, err := lookupRunAsUser(e.config.RunAsUser)
+		if err != nil {
+			return nil
+		}
+		c.Uid, c.Gid, c.Groups = uid, gid, []int{}
+	}
+	if c.Groups == nil || e.config.Sandbox.Enabled {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return nil
+		}
+		c.Groups = groups
+	}
+	c.CapabilitiesDropped = c.CapabilitiesDropped || e.config.Sandbox.Enabled
+	// Landlock and exec tracing's filter set no_new_privs for every command;
+	// seccomp profiles only for the executables assigned one.
+	c.NoNewPrivs = c.NoNewPrivs || e.config.Landlock.Enabled && e.landlockABI > 0 || e.tracer != nil
+	return c
+}
+
+// validatePrivileges checks the privileges block of security.yaml. Whether
+// the identity switch is possible is only known where the server runs, so it
+// is checked at startup instead.
+func validatePrivileges(c SecurityConfig) error {
+	if !c.Privileges.Enabled {
+		if len(c.Privileges.Groups) > 0 {
+			return fmt.Errorf("privileges.groups requires privileges.enabled")
+		}
+		return nil
+	}
+	if !privilegesSupported {
+		return fmt.Errorf("privileges requires Linux")
+	}
+	if c.usesBackend(backendSandbox) && len(c.Privileges.Groups) > 0 {
+		return fmt.Errorf("privileges.groups cannot be combined with the sandbox, whose user namespace maps a single group")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const privilegesSupported = true
+
+// hasCapability reports whether the calling thread has capability c in its
+// effective set.
+func hasCapability(c int) bool {
+	var data [2]unix.CapUserData
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+	return data[c/32].Effective&(1<<(c%32)) != 0
+}
+
+// checkIdentitySwitch reports why the server could not switch a child to id:
+// changing uid needs CAP_SETUID, and changing gid or setting the groups,
+// unless they already are what id asks for, CAP_SETGID.
+func checkIdentitySwitch(id identity) error {
+	if id.uid != os.Geteuid() && !hasCapability(unix.CAP_SETUID) {
+		return fmt.Errorf("switching to uid %d needs CAP_SETUID, which the server does not have", id.uid)
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	slices.Sort(groups)
+	wanted := slices.Sorted(slices.Values(id.groups))
+	if (id.gid != os.Getegid() || !slices.Equal(groups, wanted)) && !hasCapability(unix.CAP_SETGID) {
+		return fmt.Errorf("switching to gid %d and groups %v needs CAP_SETGID, which the server does not have", id.gid, id.groups)
+	}
+	return nil
+}
+
+// apply switches the calling process to p's identity, when it is to, and
+// leaves it without capabilities and with no_new_privs set. The bounding set
+// is emptied first, while the process may still have the CAP_SETPCAP that
+// takes; without it, no_new_privs alone keeps the command from gaining any.
+func (p *privilegeSpec) apply() error {
+	if hasCapability(unix.CAP_SETPCAP) {
+		for c := 0; ; c++ {
+			in, err := unix.PrctlRetInt(unix.PR_CAPBSET_READ, uintptr(c), 0, 0, 0)
+			if errors.Is(err, unix.EINVAL) {
+				break
+			}
+			if err != nil || in == 0 {
+				continue
+			}
+			if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+				return fmt.Errorf("drop capability %d from the bounding set: %w", c, err)
+			}
+		}
+	}
+	if p.Switch {
+		if err := syscall.Setgroups(p.Groups); err != nil {
+			return fmt.Errorf("set groups: %w", err)
+		}
+		if err := syscall.Setresgid(p.Gid, p.Gid, p.Gid); err != nil {
+			return fmt.Errorf("set gid: %w", err)
+		}
+		if err := syscall.Setresuid(p.Uid, p.Uid, p.Uid); err != nil {
+			return fmt.Errorf("set uid: %w", err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear ambient capabilities: %w", err)
+	}
+	var data [2]unix.CapUserData
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("drop capabilities: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// credentialsProbe prints the uid, the groups, and the capability sets and
+// no_new_privs flag of the process running it.
+const credentialsProbe = "id -u; id -G; grep -E '^(Cap(Inh|Prm|Eff|Bnd|Amb)|NoNewPrivs):' /proc/self/status"
+
+func TestCommandExecutor_privileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching identity needs root")
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	newExecutor := func(privileges PrivilegesConfig, sandbox bool) *CommandExecutor {
+		privileges.Enabled = true
+		return newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			WorkingDirectory:  os.TempDir(),
+			RunAsUser:         "nobody",
+			Privileges:        privileges,
+			Sandbox:           SandboxConfig{Enabled: sandbox},
+		}, logger)
+	}
+	dropped := "CapInh:\t0000000000000000\nCapPrm:\t0000000000000000\nCapEff:\t0000000000000000\n" +
+		"CapBnd:\t0000000000000000\nCapAmb:\t0000000000000000\nNoNewPrivs:\t1"
+
+	t.Run("identity switched and capabilities dropped", func(t *testing.T) {
+		executor := newExecutor(PrivilegesConfig{Groups: []string{"daemon"}}, false)
+		result, err := execute(ctx, executor, credentialsProbe, false)
+		require.NoError(t, err)
+		assert.Equal(t, "success", result.Status, result.Stderr)
+		assert.Equal(t, "65534\n65534 1\n"+dropped, result.Stdout)
+		assert.Equal(t, &Credentials{
+			Uid:                 65534,
+			Gid:                 65534,
+			Groups:              []int{1},
+			NoNewPrivs:          true,
+			CapabilitiesDropped: true,
+		}, result.SecurityInfo.Credentials)
+	})
+
+	t.Run("in the sandbox", func(t *testing.T) {
+		requireUserNamespaces(t)
+		executor := newExecutor(PrivilegesConfig{}, true)
+		// The sandbox helper starts as nobody, who cannot reach the test
+		// binary in the build cache.
+		executor.helper = reachableHelper(t)
+		result, err := execute(ctx, executor, credentialsProbe, false)
+		require.NoError(t, err)
+		assert.Equal(t, "success", result.Status, result.Stderr)
+		// The namespace's root is nobody on the host.
+		assert.Contains(t, result.Stdout, dropped)
+		assert.Equal(t, 65534, result.SecurityInfo.Credentials.Uid)
+		assert.True(t, result.SecurityInfo.Credentials.CapabilitiesDropped)
+	})
+
+	t.Run("impossible switch refuses every command", func(t *testing.T) {
+		executor := newExecutor(PrivilegesConfig{}, false)
+		executor.identityErr = errors.New("switching to uid 65534 needs CAP_SETUID, which the server does not have")
+		_, err := execute(ctx, executor, "true", false)
+		assert.EqualError(t, err, "privileges: switching to uid 65534 needs CAP_SETUID, which the server does not have")
+	})
+}
+
+// reachableHelper copies the test binary where any user can execute it.
+func reachableHelper(t *testing.T) string {
+	t.Helper()
+	self, err := os.Executable()
+	require.NoError(t, err)
+	data, err := os.ReadFile(self)
+	require.NoError(t, err)
+	dir, err := os.MkdirTemp("", "mcp-shell-helper")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	require.NoError(t, os.Chmod(dir, 0o755))
+	helper := filepath.Join(dir, "mcp-shell")
+	require.NoError(t, os.WriteFile(helper, data, 0o755))
+	return helper
+}
+
+func TestCheckIdentitySwitch(t *testing.T) {
+	groups, err := os.Getgroups()
+	require.NoError(t, err)
+	own := identity{uid: os.Geteuid(), gid: os.Getegid(), groups: groups}
+	assert.NoError(t, checkIdentitySwitch(own), "staying the same needs no capability")
+
+	other := identity{uid: own.uid + 1, gid: own.gid, groups: groups}
+	if hasCapability(unix.CAP_SETUID) {
+		assert.NoError(t, checkIdentitySwitch(other))
+	} else {
+		assert.ErrorContains(t, checkIdentitySwitch(other), "needs CAP_SETUID")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Dropping privileges relies on Linux capabilities and no_new_privs;
+// elsewhere enabling it is a configuration error.
+
+const privilegesSupported = false
+
+func checkIdentitySwitch(identity) error {
+	return errors.New("requires Linux")
+}
+
+func (*privilegeSpec) apply() error {
+	return errors.New("requires Linux")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePrivileges(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SecurityConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{
+			name:    "groups without privileges",
+			config:  SecurityConfig{Privileges: PrivilegesConfig{Groups: []string{"daemon"}}},
+			wantErr: "privileges.groups requires privileges.enabled",
+		},
+	}
+	if privilegesSupported {
+		tests = append(tests, []struct {
+			name    string
+			config  SecurityConfig
+			wantErr string
+		}{
+			{
+				name:   "enabled",
+				config: SecurityConfig{Privileges: PrivilegesConfig{Enabled: true, Groups: []string{"daemon"}}},
+			},
+			{
+				name:   "sandboxed",
+				config: SecurityConfig{Sandbox: SandboxConfig{Enabled: true}, Privileges: PrivilegesConfig{Enabled: true}},
+			},
+			{
+				name: "groups in the sandbox",
+				config: SecurityConfig{
+					Sandbox:    SandboxConfig{Enabled: true},
+					Privileges: PrivilegesConfig{Enabled: true, Groups: []string{"daemon"}},
+				},
+				wantErr: "privileges.groups cannot be combined with the sandbox, whose user namespace maps a single group",
+			},
+		}...)
+	} else {
+		tests = append(tests, struct {
+			name    string
+			config  SecurityConfig
+			wantErr string
+		}{
+			name:    "unsupported platform",
+			config:  SecurityConfig{Privileges: PrivilegesConfig{Enabled: true}},
+			wantErr: "privileges requires Linux",
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePrivileges(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		runAsUser string
+		groups    []string
+		want      identity
+		wantErr   string
+	}{
+		{
+			name: "the server's own user",
+			want: identity{uid: os.Getuid(), gid: os.Getgid(), groups: []int{}},
+		},
+		{
+			name:      "run-as user with groups by name and id",
+			runAsUser: "nobody",
+			groups:    []string{"daemon", "4", "1"},
+			want:      identity{uid: 65534, gid: 65534, groups: []int{1, 4}},
+		},
+		{
+			name:      "unknown user",
+			runAsUser: "no-such-user",
+			wantErr:   `resolve run-as user "no-such-user"`,
+		},
+		{
+			name:    "unknown group",
+			groups:  []string{"no-such-group"},
+			wantErr: `resolve group "no-such-group"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveIdentity(tt.runAsUser, tt.groups)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
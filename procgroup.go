@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultKillGrace is how long a cancelled command's process groups get
+// between SIGTERM and SIGKILL when kill_grace_period is unset.
+const defaultKillGrace = 2 * time.Second
+
+// groupPollInterval is how often a terminating group is checked for members.
+const groupPollInterval = 20 * time.Millisecond
+
+// groupTermination stops the process groups of one execution once its context
+// is done, and records how: every stage runs in a process group of its own,
+// so whatever a command forked is signalled along with it. It is shared by
+// every pipeline of the execution.
+type groupTermination struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	signal syscall.Signal
+	forced bool
+}
+
+func newGroupTermination(grace time.Duration) *groupTermination {
+	if grace <= 0 {
+		grace = defaultKillGrace
+	}
+	return &groupTermination{grace: grace}
+}
+
+// watch stops the process groups pgids when ctx is done: SIGTERM to every
+// group, then SIGKILL to the groups that still have members after the grace
+// period. The returned function stops watching; when ctx is already done it
+// first waits for the termination to finish, so its outcome is recorded.
+func (t *groupTermination) watch(ctx context.Context, pgids []int) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		t.terminate(pgids)
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (t *groupTermination) terminate(pgids []int) {
+	t.kill(pgids, syscall.SIGTERM)
+
+	deadline := time.NewTimer(t.grace)
+	defer deadline.Stop()
+	tick := time.NewTicker(groupPollInterval)
+	defer tick.Stop()
+	for groupsAlive(pgids) {
+		select {
+		case <-deadline.C:
+			if t.kill(pgids, syscall.SIGKILL) {
+				t.mu.Lock()
+				t.forced = true
+				t.mu.Unlock()
+			}
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// kill signals every group in pgids and reports whether any of them still
+// existed to receive it.
+func (t *groupTermination) kill(pgids []int, sig syscall.Signal) bool {
+	delivered := false
+	for _, pgid := range pgids {
+		if err := syscall.Kill(-pgid, sig); err == nil {
+			delivered = true
+		}
+	}
+	if delivered {
+		t.mu.Lock()
+		t.signal = sig
+		t.mu.Unlock()
+	}
+	return delivered
+}
+
+// outcome returns the strongest signal delivered, zero if none was, and
+// whether stragglers had to be force-killed.
+func (t *groupTermination) outcome() (syscall.Signal, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.signal, t.forced
+}
+
+// groupsAlive reports whether any of the process groups still has a member.
+func groupsAlive(pgids []int) bool {
+	for _, pgid := range pgids {
+		if groupHasMembers(pgid) {
+			return true
+		}
+	}
+	return false
+}
+
+// signalName renders a signal as SIGTERM rather than "terminated".
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	case 0:
+		return ""
+	}
+	return sig.String()
+}
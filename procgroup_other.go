@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// groupHasMembers reports whether process group pgid has a member. Zombies
+// count, so an unreaped orphan keeps its group alive until SIGKILL.
+func groupHasMembers(pgid int) bool {
+	return !errors.Is(syscall.Kill(-pgid, 0), syscall.ESRCH)
+}
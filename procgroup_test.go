@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processGone reports whether pid has exited: it no longer exists, or is a
+// zombie waiting for a reaper that is not the server.
+func processGone(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestCommandExecutor_processGroupKill(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc")
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		command     string
+		wantSignal  string
+		wantForced  bool
+		wantTimeout bool
+	}{
+		{
+			name:        "forked children are terminated with the command",
+			command:     "sleep 30 & echo $!; wait",
+			wantSignal:  "SIGTERM",
+			wantTimeout: true,
+		},
+		{
+			name:        "children ignoring SIGTERM are force-killed",
+			command:     "trap '' TERM; sleep 30 & echo $!; wait; wait",
+			wantSignal:  "SIGKILL",
+			wantForced:  true,
+			wantTimeout: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := newCommandExecutor(SecurityConfig{
+				UseShellExecution: true,
+				MaxExecutionTime:  300 * time.Millisecond,
+				KillGracePeriod:   300 * time.Millisecond,
+			}, logger)
+
+			start := time.Now()
+			result, err := execute(ctx, executor, tt.command, false)
+			require.NoError(t, err)
+			assert.Less(t, time.Since(start), 10*time.Second)
+
+			assert.Equal(t, tt.wantTimeout, result.TimedOut)
+			assert.Equal(t, tt.wantSignal, result.Signal)
+			assert.Equal(t, tt.wantForced, result.ForceKilled)
+
+			pid, err := strconv.Atoi(strings.TrimSpace(result.Stdout))
+			require.NoError(t, err, result.Stdout)
+			assert.Eventually(t, func() bool { return processGone(pid) }, 2*time.Second, 20*time.Millisecond,
+				"forked child %d outlived the command", pid)
+		})
+	}
+
+	t.Run("a command that finishes in time is not signalled", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{
+			UseShellExecution: true,
+			MaxExecutionTime:  5 * time.Second,
+		}, logger)
+
+		result, err := execute(ctx, executor, "echo done", false)
+		require.NoError(t, err)
+		assert.False(t, result.TimedOut)
+		assert.Empty(t, result.Signal)
+		assert.False(t, result.ForceKilled)
+	})
+}
+
+func TestGroupTermination_defaults(t *testing.T) {
+	assert.Equal(t, defaultKillGrace, newGroupTermination(0).grace)
+	assert.Equal(t, time.Second, newGroupTermination(time.Second).grace)
+
+	term := newGroupTermination(0)
+	stop := term.watch(context.Background(), nil)
+	stop()
+	sig, forced := term.outcome()
+	assert.Zero(t, sig)
+	assert.False(t, forced)
+}
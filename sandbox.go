@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// sandboxHelperArg, as the first argument of the server's own binary, makes it
+// run as the sandbox helper instead of the server; see runSandboxHelper.
+const sandboxHelperArg = "-mcp-shell-sandbox-exec"
+
+// defaultSandboxPaths are the host paths a sandboxed command sees read-only
+// when sandbox.read_only_paths is unset: enough to run the usual tools. Those
+// missing on the host are left out.
+var defaultSandboxPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc"}
+
+// sandboxSpec tells the sandbox helper how to build a command's filesystem:
+// Workspace bind-mounted read-write, ReadOnly bind-mounted read-only, each at
+// its own path, with the command started in Dir. The helper applies Child
+// too, as the exec helper cannot run inside the sandbox. It reports the
+// signal that killed the command on descriptor SignalFd: init of a PID
+// namespace cannot die of a signal it raises on itself.
+type sandboxSpec struct {
+	Workspace string    `json:"workspace"`
+	Dir       string    `json:"dir"`
+	ReadOnly  []string  `json:"read_only"`
+	Child     childSpec `json:"child"`
+	SignalFd  int       `json:"signal_fd"`
+}
+
+func (s sandboxSpec) encode() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// readOnlyPaths returns the host paths to expose read-only: read_only_paths
+// when set, otherwise the default paths that exist on this host.
+func (c SandboxConfig) readOnlyPaths() []string {
+	if len(c.ReadOnlyPaths) > 0 {
+		return c.ReadOnlyPaths
+	}
+	return existingPaths(defaultSandboxPaths)
+}
+
+// validateSandbox checks the sandbox block of security.yaml.
+func validateSandbox(cfg SecurityConfig) error {
+	for _, path := range cfg.Sandbox.ReadOnlyPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("sandbox.read_only_paths entry %q must be an absolute path", path)
+		}
+	}
+	if !cfg.usesBackend(backendSandbox) {
+		return nil
+	}
+	if !sandboxSupported {
+		return errors.New("sandbox requires Linux")
+	}
+	if cfg.WorkingDirectory == "" {
+		return errors.New("sandbox requires a working_directory")
+	}
+	return nil
+}
+
+// sandboxSignal reads the signal a sandbox helper reported on r once it has
+// exited, or returns zero when the command was not killed by one.
+func sandboxSignal(r *os.File) syscall.Signal {
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n == 0 {
+		return 0
+	}
+	return syscall.Signal(b[0])
+}
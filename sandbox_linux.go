@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxSupported = true
+
+// sandboxHostname is the hostname inside the sandbox's UTS namespace.
+const sandboxHostname = "mcp-shell"
+
+// sandboxDevices are the device nodes a sandboxed command gets in its /dev.
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom"}
+
+// Securebits that keep root of the user namespace from regaining
+// capabilities at exec or by changing uid, locked so they stay set.
+const (
+	secbitNoRoot                = 1 << 0
+	secbitNoRootLocked          = 1 << 1
+	secbitNoSetuidFixup         = 1 << 2
+	secbitNoSetuidFixupLocked   = 1 << 3
+	secbitKeepCapsLocked        = 1 << 5
+	secbitNoCapAmbientRaise     = 1 << 6
+	secbitNoCapAmbientRaiseLock = 1 << 7
+	sandboxSecurebits           = secbitNoRoot | secbitNoRootLocked | secbitNoSetuidFixup | secbitNoSetuidFixupLocked | secbitKeepCapsLocked | secbitNoCapAmbientRaise | secbitNoCapAmbientRaiseLock
+)
+
+// sandboxNamespaces makes processes started with attr begin in new user,
+// mount, PID, IPC, UTS and, unless network is set, network namespaces, as
+// root of the user namespace mapped to uid and gid on the host. Mapping a
+// single id to itself needs no privileges; mapping another one, the server's
+// CAP_SETUID and CAP_SETGID.
+func sandboxNamespaces(attr *syscall.SysProcAttr, network bool, uid, gid int) {
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+	// Become the namespace's root even when the host ids the process
+	// started with are not the ones mapped; setgroups is denied in it.
+	attr.Credential = &syscall.Credential{NoSetGroups: true}
+}
+
+// runSandboxHelper is the sandbox helper, started in the sandbox's fresh
+// namespaces. args are the encoded sandboxSpec, the path to run and its argv.
+// It builds the command's filesystem, gives up its capabilities and then, as
+// init of the PID namespace, forks the command, reaps whatever gets orphaned
+// and exits with the command's exit code. If a signal killed it, the helper
+// writes its number to spec.SignalFd and exits 128 plus the number, as a
+// shell would. Its exit takes down anything left in the namespace. It
+// exits 126 when the sandbox cannot be set up and 127 when path cannot be
+// run.
+func runSandboxHelper(args []string) int {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "mcp-shell: sandbox helper: missing arguments")
+		return 126
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: sandbox helper: invalid spec: %v\n", err)
+		return 126
+	}
+	path, argv := args[1], args[2:]
+	// The command must not inherit the descriptor the signal is reported on.
+	syscall.CloseOnExec(spec.SignalFd)
+
+	// Capabilities belong to a thread, and the command is forked from the
+	// one that dropped them.
+	runtime.LockOSThread()
+	if err := enterSandbox(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: sandbox: %v\n", err)
+		return 126
+	}
+	if err := spec.Child.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: sandbox: %v\n", err)
+		return 126
+	}
+
+	// Init of a PID namespace only receives the signals it handles. The
+	// server signals the whole process group, the command included, so the
+	// helper just has to survive them to report how the command ended.
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
+
+	pid, err := syscall.ForkExec(path, argv, &syscall.ProcAttr{
+		Env:   os.Environ(),
+		Files: []uintptr{0, 1, 2},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mcp-shell: %s: %v\n", argv[0], err)
+		return 127
+	}
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mcp-shell: sandbox: wait: %v\n", err)
+			return 126
+		}
+		if wpid != pid {
+			continue
+		}
+		if ws.Signaled() {
+			unix.Write(spec.SignalFd, []byte{byte(ws.Signal())})
+			return 128 + int(ws.Signal())
+		}
+		return ws.ExitStatus()
+	}
+}
+
+// sandboxBind is a host path to expose in the sandbox: a symlink, recreated
+// as such, or anything else, held open by fd to be bind-mounted.
+type sandboxBind struct {
+	path     string
+	link     string
+	fd       int
+	dir      bool
+	writable bool
+}
+
+// enterSandbox turns the helper's fresh mount namespace into the command's
+// filesystem and switches into it: a read-only tmpfs root holding the binds,
+// private /tmp and /dev, and /proc of the new PID namespace. It then sets the
+// hostname, changes into spec.Dir and drops every capability.
+func enterSandbox(spec sandboxSpec) error {
+	// Nothing mounted from here on may propagate back to the host.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	// The new root is a tmpfs mounted over /tmp, which may hide the very
+	// paths to bind into it, so every source is opened first and bound
+	// through /proc/self/fd. Parents sort before their children, so the
+	// workspace lands on top of a read-only path containing it.
+	binds := make([]sandboxBind, 0, len(spec.ReadOnly)+1)
+	for _, path := range spec.ReadOnly {
+		binds = append(binds, sandboxBind{path: path})
+	}
+	binds = append(binds, sandboxBind{path: spec.Workspace, writable: true})
+	sort.SliceStable(binds, func(i, j int) bool { return binds[i].path < binds[j].path })
+	for _, path := range sandboxDevices {
+		if _, err := os.Stat(path); err == nil {
+			binds = append(binds, sandboxBind{path: path})
+		}
+	}
+	for i := range binds {
+		b := &binds[i]
+		fi, err := os.Lstat(b.path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if b.link, err = os.Readlink(b.path); err != nil {
+				return err
+			}
+			continue
+		}
+		b.dir = fi.IsDir()
+		if b.fd, err = unix.Open(b.path, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+			return fmt.Errorf("open %s: %w", b.path, err)
+		}
+		defer unix.Close(b.fd)
+	}
+
+	const root = "/tmp"
+	mounts := []struct {
+		target, fstype string
+		flags          uintptr
+		data           string
+	}{
+		{root, "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, "mode=0755"},
+		{root + "/tmp", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, "mode=1777"},
+		{root + "/dev", "tmpfs", unix.MS_NOSUID | unix.MS_NOEXEC, "mode=0755"},
+	}
+	for _, m := range mounts {
+		if err := os.MkdirAll(m.target, 0o755); err != nil {
+			return err
+		}
+		if err := unix.Mount("tmpfs", m.target, m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("mount %s: %w", m.target, err)
+		}
+	}
+	for name, target := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(target, root+"/dev/"+name); err != nil {
+			return err
+		}
+	}
+	// Where the host's /proc is partly masked, as in many containers, the
+	// kernel refuses a new one; the command then has no /proc at all.
+	if err := os.Mkdir(root+"/proc", 0o555); err != nil {
+		return err
+	}
+	_ = unix.Mount("proc", root+"/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	for _, b := range binds {
+		if err := bindIntoSandbox(root, b); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Chdir(root); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach the host root: %w", err)
+	}
+	if err := unix.MountSetattr(-1, "/", 0, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}); err != nil {
+		return fmt.Errorf("make the root read-only: %w", err)
+	}
+	if err := unix.Sethostname([]byte(sandboxHostname)); err != nil {
+		return fmt.Errorf("set hostname: %w", err)
+	}
+	if err := unix.Chdir(spec.Dir); err != nil {
+		return err
+	}
+	return dropCapabilities()
+}
+
+// bindIntoSandbox exposes b at the same path under root.
+func bindIntoSandbox(root string, b sandboxBind) error {
+	target := filepath.Join(root, b.path)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if b.link != "" {
+		return os.Symlink(b.link, target)
+	}
+	if b.dir {
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+	} else if f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
+		return err
+	} else {
+		f.Close()
+	}
+	// Recursive, as the kernel refuses to bind a mount without the mounts
+	// below it from inside a user namespace.
+	source := "/proc/self/fd/" + strconv.Itoa(b.fd)
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", b.path, err)
+	}
+	if b.writable {
+		return nil
+	}
+	err := unix.MountSetattr(-1, target, unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY})
+	if err != nil {
+		return fmt.Errorf("make %s read-only: %w", b.path, err)
+	}
+	return nil
+}
+
+// dropCapabilities leaves the calling thread, and every process it forks,
+// without capabilities for good: the bounding set is emptied, and the
+// securebits stop root of the user namespace from regaining any at exec.
+// Without it, the command could simply remount its read-only paths.
+func dropCapabilities() error {
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("drop capability %d: %w", c, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_SECUREBITS, sandboxSecurebits, 0, 0, 0); err != nil {
+		return fmt.Errorf("set securebits: %w", err)
+	}
+	var data [2]unix.CapUserData
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("drop capabilities: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireUserNamespaces skips the test where this process may not create a
+// user namespace.
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	sandboxNamespaces(cmd.SysProcAttr, false, os.Getuid(), os.Getgid())
+	if err := cmd.Run(); err != nil {
+		t.Skipf("user namespaces unavailable: %v", err)
+	}
+}
+
+func TestCommandExecutor_sandbox(t *testing.T) {
+	requireUserNamespaces(t)
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("s3cret"), 0o644))
+
+	newExecutor := func(sandbox SandboxConfig) *CommandExecutor {
+		sandbox.Enabled = true
+		return newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			KillGracePeriod:   time.Second,
+			WorkingDirectory:  workspace,
+			Sandbox:           sandbox,
+		}, logger)
+	}
+	executor := newExecutor(SandboxConfig{})
+
+	tests := []struct {
+		name       string
+		command    string
+		wantStatus string
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "the workspace is writable and shared with the host",
+			command:    "echo hello > note && cat note && pwd",
+			wantStatus: "success",
+			wantStdout: "hello\n" + workspace,
+		},
+		{
+			name:       "read-only paths cannot be written",
+			command:    "touch /usr/mcp-shell-probe",
+			wantStatus: "error",
+			wantStderr: "Read-only file system",
+		},
+		{
+			name:       "the root cannot be written",
+			command:    "mkdir /mcp-shell-probe",
+			wantStatus: "error",
+			wantStderr: "Read-only file system",
+		},
+		{
+			name:       "paths outside are invisible",
+			command:    "cat " + filepath.Join(outside, "secret"),
+			wantStatus: "error",
+			wantStderr: "No such file or directory",
+		},
+		{
+			name:       "own PID namespace",
+			command:    "echo $PPID",
+			wantStatus: "success",
+			wantStdout: "1",
+		},
+		{
+			name:       "own UTS namespace",
+			command:    "echo $HOSTNAME",
+			wantStatus: "success",
+			wantStdout: sandboxHostname,
+		},
+		{
+			name:       "no network",
+			command:    "echo > /dev/tcp/127.0.0.1/9",
+			wantStatus: "error",
+			wantStderr: "Network is unreachable",
+		},
+		{
+			name:       "device nodes",
+			command:    "head -c 4 /dev/zero | wc -c",
+			wantStatus: "success",
+			wantStdout: "4",
+		},
+		{
+			name:       "exit codes pass through",
+			command:    "exit 3",
+			wantStatus: "error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := execute(ctx, executor, tt.command, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status, result.Stderr)
+			if tt.wantStdout != "" {
+				assert.Equal(t, tt.wantStdout, result.Stdout)
+			}
+			assert.Contains(t, result.Stderr, tt.wantStderr)
+			assert.True(t, result.SecurityInfo.Sandboxed)
+		})
+	}
+
+	t.Run("note written in the sandbox is on the host", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(workspace, "note"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", string(data))
+	})
+
+	t.Run("capabilities are dropped", func(t *testing.T) {
+		result, err := execute(ctx, executor, "grep -E '^Cap(Eff|Prm|Bnd)' /proc/self/status", false)
+		require.NoError(t, err)
+		if result.Status != "success" {
+			t.Skip("no /proc in the sandbox")
+		}
+		assert.Equal(t, "CapPrm:\t0000000000000000\nCapEff:\t0000000000000000\nCapBnd:\t0000000000000000", result.Stdout)
+	})
+
+	t.Run("network", func(t *testing.T) {
+		result, err := execute(ctx, newExecutor(SandboxConfig{Network: true}), "echo > /dev/tcp/127.0.0.1/9", false)
+		require.NoError(t, err)
+		assert.NotContains(t, result.Stderr, "Network is unreachable")
+	})
+
+	t.Run("configured read-only paths", func(t *testing.T) {
+		paths := append(SandboxConfig{}.readOnlyPaths(), outside)
+		result, err := execute(ctx, newExecutor(SandboxConfig{ReadOnlyPaths: paths}),
+			"cat "+filepath.Join(outside, "secret")+" && rm "+filepath.Join(outside, "secret"), false)
+		require.NoError(t, err)
+		assert.Equal(t, "s3cret", result.Stdout)
+		assert.Contains(t, result.Stderr, "Read-only file system")
+	})
+
+	t.Run("rlimits apply inside", func(t *testing.T) {
+		executor := newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			WorkingDirectory:  workspace,
+			Limits:            ResourceLimits{OpenFiles: 64, FileSize: 1024},
+			Sandbox:           SandboxConfig{Enabled: true},
+		}, logger)
+		result, err := execute(ctx, executor, "ulimit -n; exec head -c 4096 /dev/zero > big", false)
+		require.NoError(t, err)
+		assert.Equal(t, "64", result.Stdout)
+		assert.Equal(t, -1, result.ExitCode)
+		assert.Equal(t, []string{"file_size"}, result.LimitsExceeded)
+
+		// Only a signal counts: an exit code above 128 is just a code.
+		result, err = execute(ctx, executor, "exit 153", false)
+		require.NoError(t, err)
+		assert.Equal(t, 153, result.ExitCode)
+		assert.Empty(t, result.LimitsExceeded)
+	})
+
+	t.Run("timeout stops the command with SIGTERM", func(t *testing.T) {
+		result, err := execute(ctx, executor.withTimeout(200*time.Millisecond), "sleep 30", false)
+		require.NoError(t, err)
+		assert.True(t, result.TimedOut)
+		assert.Equal(t, "SIGTERM", result.Signal)
+		assert.False(t, result.ForceKilled)
+	})
+}
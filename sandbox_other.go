@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// The namespace sandbox exists on Linux only; elsewhere enabling it is a
+// configuration error.
+
+const sandboxSupported = false
+
+func sandboxNamespaces(*syscall.SysProcAttr, bool, int, int) {}
+
+func runSandboxHelper([]string) int {
+	fmt.Fprintln(os.Stderr, "mcp-shell: sandbox helper: requires Linux")
+	return 126
+}
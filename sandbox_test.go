@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSandbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SecurityConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{
+			name:   "enabled",
+			config: SecurityConfig{WorkingDirectory: "/tmp", Sandbox: SandboxConfig{Enabled: true, ReadOnlyPaths: []string{"/usr"}}},
+		},
+		{
+			name:    "relative read-only path",
+			config:  SecurityConfig{Sandbox: SandboxConfig{ReadOnlyPaths: []string{"usr"}}},
+			wantErr: `sandbox.read_only_paths entry "usr" must be an absolute path`,
+		},
+		{
+			name:    "no working directory",
+			config:  SecurityConfig{Sandbox: SandboxConfig{Enabled: true}},
+			wantErr: "sandbox requires a working_directory",
+		},
+		{
+			name:    "backend of one executable without a working directory",
+			config:  SecurityConfig{Backends: BackendsConfig{Executables: map[string]string{"make": backendSandbox}}},
+			wantErr: "sandbox requires a working_directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !sandboxSupported && tt.config.usesBackend(backendSandbox) {
+				tt.wantErr = "sandbox requires Linux"
+			}
+			err := validateSandbox(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSandboxConfig_readOnlyPaths(t *testing.T) {
+	configured := SandboxConfig{ReadOnlyPaths: []string{"/opt/tools"}}
+	assert.Equal(t, []string{"/opt/tools"}, configured.readOnlyPaths())
+
+	for _, path := range (SandboxConfig{}).readOnlyPaths() {
+		assert.Contains(t, defaultSandboxPaths, path)
+		_, err := os.Lstat(path)
+		assert.NoError(t, err, "missing default paths are left out")
+	}
+}
+
+func TestSandboxSpec_encode(t *testing.T) {
+	spec := sandboxSpec{Workspace: "/work", Dir: "/work/src", ReadOnly: []string{"/usr"}, Child: childSpec{Rlimits: "nofile=64"}, SignalFd: 4}
+	var decoded sandboxSpec
+	require.NoError(t, json.Unmarshal([]byte(spec.encode()), &decoded))
+	assert.Equal(t, spec, decoded)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// seccompNone, as an executable's profile, exempts it from the global one.
+const seccompNone = "none"
+
+// Seccomp profile actions: what happens to a process making a denied
+// syscall.
+const (
+	seccompKill  = "kill"
+	seccompErrno = "errno"
+)
+
+// seccompFSWrite is the group that also denies opening files for writing.
+const seccompFSWrite = "@fs-write"
+
+// seccompGroups are the syscall groups a profile's deny list may name. A
+// group may list syscalls missing on some architectures; they are skipped
+// there.
+var seccompGroups = map[string][]string{
+	// Administration and escape hatches no command run by the server needs.
+	// io_uring is one: its operations open files and sockets without the
+	// syscalls the other groups deny.
+	"@privileged": {
+		"acct", "add_key", "adjtimex", "bpf", "chroot", "clock_adjtime", "clock_settime",
+		"delete_module", "finit_module", "fsconfig", "fsmount", "fsopen", "fspick",
+		"init_module", "io_uring_enter", "io_uring_register", "io_uring_setup",
+		"ioperm", "iopl", "kexec_file_load", "kexec_load", "keyctl",
+		"mount", "mount_setattr", "move_mount", "name_to_handle_at", "open_by_handle_at",
+		"open_tree", "perf_event_open", "pivot_root", "process_vm_readv", "process_vm_writev",
+		"ptrace", "quotactl", "reboot", "request_key", "setdomainname", "sethostname",
+		"setns", "settimeofday", "swapoff", "swapon", "umount2", "unshare", "userfaultfd",
+	},
+	"@network": {
+		"socket", "connect", "bind", "listen", "accept", "accept4",
+	},
+	// Everything that creates, removes, renames or modifies a file, besides
+	// opening one for writing.
+	seccompFSWrite: {
+		"chmod", "chown", "creat", "fallocate", "fchmod", "fchmodat", "fchmodat2", "fchown",
+		"fchownat", "fremovexattr", "fsetxattr", "ftruncate", "futimesat", "lchown", "link",
+		"linkat", "lremovexattr", "lsetxattr", "mkdir", "mkdirat", "mknod", "mknodat",
+		"openat2", "removexattr", "rename", "renameat", "renameat2", "rmdir", "setxattr",
+		"symlink", "symlinkat", "truncate", "unlink", "unlinkat", "utime", "utimensat", "utimes",
+	},
+}
+
+// builtinSeccompProfiles are the profiles available without configuration;
+// seccomp.profiles may replace them.
+var builtinSeccompProfiles = map[string]SeccompProfile{
+	"default":      {Deny: []string{"@privileged"}},
+	"no-network":   {Deny: []string{"@privileged", "@network"}},
+	"read-only-fs": {Deny: []string{"@privileged", seccompFSWrite}},
+}
+
+// seccompRules is a profile resolved for the helper to compile into a
+// filter: the syscalls denied, whether opening a file for writing is too,
+// and the action taken on a denied call. Profile is kept to report
+// violations by.
+type seccompRules struct {
+	Profile        string   `json:"profile"`
+	Syscalls       []string `json:"syscalls"`
+	DenyWriteOpens bool     `json:"deny_write_opens,omitempty"`
+	Action         string   `json:"action"`
+}
+
+// kills reports whether a denied syscall kills the process, so that a
+// violation is seen in how it ended.
+func (r *seccompRules) kills() bool {
+	return r != nil && r.Action != seccompErrno
+}
+
+// seccompPolicy maps executables to their resolved profiles.
+type seccompPolicy struct {
+	global *seccompRules
+	byExe  map[string]*seccompRules
+}
+
+// newSeccompPolicy resolves every profile assigned in c. It returns nil when
+// none is.
+func newSeccompPolicy(c SeccompConfig) (*seccompPolicy, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	profiles := c.profiles()
+	resolve := func(name string) (*seccompRules, error) {
+		if name == "" || name == seccompNone {
+			return nil, nil
+		}
+		profile, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown seccomp profile %q", name)
+		}
+		return resolveSeccompProfile(name, profile)
+	}
+
+	p := &seccompPolicy{byExe: map[string]*seccompRules{}}
+	var err error
+	if p.global, err = resolve(c.Profile); err != nil {
+		return nil, err
+	}
+	for exe, name := range c.Executables {
+		if p.byExe[exe], err = resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// rulesFor returns the rules an executable, by argv[0], runs under: its own
+// profile, by basename, or else the global one. Nil means no filter.
+func (p *seccompPolicy) rulesFor(argv0 string) *seccompRules {
+	if p == nil {
+		return nil
+	}
+	if rules, ok := p.byExe[filepath.Base(argv0)]; ok {
+		return rules
+	}
+	return p.global
+}
+
+// enabled reports whether any executable is assigned a profile.
+func (c SeccompConfig) enabled() bool {
+	if c.Profile != "" && c.Profile != seccompNone {
+		return true
+	}
+	for _, name := range c.Executables {
+		if name != seccompNone {
+			return true
+		}
+	}
+	return false
+}
+
+// profiles returns the built-in profiles overlaid with the configured ones.
+func (c SeccompConfig) profiles() map[string]SeccompProfile {
+	profiles := maps.Clone(builtinSeccompProfiles)
+	maps.Copy(profiles, c.Profiles)
+	return profiles
+}
+
+// resolveSeccompProfile expands the groups of a profile's deny list into the
+// syscalls this architecture has.
+func resolveSeccompProfile(name string, profile SeccompProfile) (*seccompRules, error) {
+	rules := &seccompRules{Profile: name, Action: profile.Action}
+	if rules.Action == "" {
+		rules.Action = seccompKill
+	}
+	for _, entry := range profile.Deny {
+		if group, ok := seccompGroups[entry]; ok {
+			for _, call := range group {
+				if _, ok := seccompSyscall(call); ok {
+					rules.Syscalls = append(rules.Syscalls, call)
+				}
+			}
+			rules.DenyWriteOpens = rules.DenyWriteOpens || entry == seccompFSWrite
+			continue
+		}
+		if strings.HasPrefix(entry, "@") {
+			return nil, fmt.Errorf("seccomp profile %q: unknown syscall group %q", name, entry)
+		}
+		if _, ok := seccompSyscall(entry); !ok {
+			return nil, fmt.Errorf("seccomp profile %q: unknown syscall %q", name, entry)
+		}
+		rules.Syscalls = append(rules.Syscalls, entry)
+	}
+	slices.Sort(rules.Syscalls)
+	rules.Syscalls = slices.Compact(rules.Syscalls)
+	return rules, nil
+}
+
+// validateSeccomp checks the seccomp block of security.yaml.
+func validateSeccomp(c SeccompConfig) error {
+	for name, profile := range c.Profiles {
+		if name == seccompNone {
+			return fmt.Errorf("seccomp profile name %q is reserved", name)
+		}
+		switch profile.Action {
+		case "", seccompKill, seccompErrno:
+		default:
+			return fmt.Errorf("seccomp profile %q: action must be %s or %s, not %q", name, seccompKill, seccompErrno, profile.Action)
+		}
+		for _, entry := range profile.Deny {
+			if entry == "execve" || entry == "execveat" {
+				return fmt.Errorf("seccomp profile %q: %s cannot be denied, the command itself is started with it", name, entry)
+			}
+		}
+		if seccompSupported {
+			if _, err := resolveSeccompProfile(name, profile); err != nil {
+				return err
+			}
+		}
+	}
+	if !c.enabled() {
+		return nil
+	}
+	if !seccompSupported {
+		return fmt.Errorf("seccomp profiles require Linux on amd64 or arm64")
+	}
+	_, err := newSeccompPolicy(c)
+	return err
+}
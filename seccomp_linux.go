@@ -0,0 +1,101 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const seccompSupported = true
+
+// seccompWriteFlags are the open flags that make an open a write.
+const seccompWriteFlags = unix.O_WRONLY | unix.O_RDWR | unix.O_CREAT | unix.O_TRUNC | unix.O_APPEND
+
+// Offsets into struct seccomp_data.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+func seccompSyscall(name string) (uint32, bool) {
+	nr, ok := seccompSyscalls[name]
+	return nr, ok
+}
+
+// filter compiles r into a seccomp BPF program: a denied syscall, or an open
+// for writing when DenyWriteOpens is set, gets the profile's action;
+// anything else is allowed. A syscall made through another architecture's
+// calling convention is never allowed, as its numbers mean something else.
+func (r *seccompRules) filter() ([]unix.SockFilter, error) {
+	deny := uint32(unix.SECCOMP_RET_KILL_PROCESS)
+	if r.Action == seccompErrno {
+		deny = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	}
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		ret  = unix.BPF_RET | unix.BPF_K
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+	)
+
+	prog := []unix.SockFilter{
+		stmt(load, seccompDataArch),
+		jump(jeq, seccompAuditArch, 1, 0),
+		stmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(load, seccompDataNr),
+	}
+	if seccompX32 {
+		prog = append(prog, jump(jge, 0x40000000, 0, 1), stmt(ret, deny))
+	}
+	for _, name := range r.Syscalls {
+		nr, ok := seccompSyscall(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+		prog = append(prog, jump(jeq, nr, 0, 1), stmt(ret, deny))
+	}
+	if r.DenyWriteOpens {
+		for _, nr := range slices.Sorted(maps.Keys(seccompOpenCalls)) {
+			// The low half of the flags argument, on little-endian
+			// architectures, holds every open flag.
+			prog = append(prog,
+				jump(jeq, nr, 0, 4),
+				stmt(load, uint32(seccompDataArgs+8*seccompOpenCalls[nr])),
+				jump(jset, seccompWriteFlags, 0, 1),
+				stmt(ret, deny),
+				stmt(ret, unix.SECCOMP_RET_ALLOW),
+			)
+		}
+	}
+	return append(prog, stmt(ret, unix.SECCOMP_RET_ALLOW)), nil
+}
+
+// install applies r to the calling thread and everything it starts from then
+// on. It sets no_new_privs, which an unprivileged filter requires.
+func (r *seccompRules) install() error {
+	prog, err := r.filter()
+	if err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0); err != nil {
+		return fmt.Errorf("install filter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+const seccompAuditArch = unix.AUDIT_ARCH_AARCH64
+
+// seccompX32 says whether the architecture also takes x32 syscalls, which
+// the filter refuses outright rather than match by number.
+const seccompX32 = false
+
+// seccompOpenCalls maps the syscalls that open a file to the index of their
+// flags argument.
+var seccompOpenCalls = map[uint32]int{
+	unix.SYS_OPENAT: 2,
+}
+
+// seccompSyscalls are the syscalls a seccomp profile can name.
+var seccompSyscalls = map[string]uint32{
+	"accept":             unix.SYS_ACCEPT,
+	"accept4":            unix.SYS_ACCEPT4,
+	"acct":               unix.SYS_ACCT,
+	"add_key":            unix.SYS_ADD_KEY,
+	"adjtimex":           unix.SYS_ADJTIMEX,
+	"bind":               unix.SYS_BIND,
+	"bpf":                unix.SYS_BPF,
+	"capset":             unix.SYS_CAPSET,
+	"chdir":              unix.SYS_CHDIR,
+	"chroot":             unix.SYS_CHROOT,
+	"clock_adjtime":      unix.SYS_CLOCK_ADJTIME,
+	"clock_settime":      unix.SYS_CLOCK_SETTIME,
+	"clone":              unix.SYS_CLONE,
+	"clone3":             unix.SYS_CLONE3,
+	"connect":            unix.SYS_CONNECT,
+	"delete_module":      unix.SYS_DELETE_MODULE,
+	"execve":             unix.SYS_EXECVE,
+	"execveat":           unix.SYS_EXECVEAT,
+	"fallocate":          unix.SYS_FALLOCATE,
+	"fanotify_init":      unix.SYS_FANOTIFY_INIT,
+	"fanotify_mark":      unix.SYS_FANOTIFY_MARK,
+	"fchdir":             unix.SYS_FCHDIR,
+	"fchmod":             unix.SYS_FCHMOD,
+	"fchmodat":           unix.SYS_FCHMODAT,
+	"fchmodat2":          unix.SYS_FCHMODAT2,
+	"fchown":             unix.SYS_FCHOWN,
+	"fchownat":           unix.SYS_FCHOWNAT,
+	"fdatasync":          unix.SYS_FDATASYNC,
+	"finit_module":       unix.SYS_FINIT_MODULE,
+	"fremovexattr":       unix.SYS_FREMOVEXATTR,
+	"fsconfig":           unix.SYS_FSCONFIG,
+	"fsetxattr":          unix.SYS_FSETXATTR,
+	"fsmount":            unix.SYS_FSMOUNT,
+	"fsopen":             unix.SYS_FSOPEN,
+	"fspick":             unix.SYS_FSPICK,
+	"fsync":              unix.SYS_FSYNC,
+	"ftruncate":          unix.SYS_FTRUNCATE,
+	"getdents64":         unix.SYS_GETDENTS64,
+	"getsockopt":         unix.SYS_GETSOCKOPT,
+	"init_module":        unix.SYS_INIT_MODULE,
+	"inotify_init1":      unix.SYS_INOTIFY_INIT1,
+	"io_uring_enter":     unix.SYS_IO_URING_ENTER,
+	"io_uring_register":  unix.SYS_IO_URING_REGISTER,
+	"io_uring_setup":     unix.SYS_IO_URING_SETUP,
+	"ioctl":              unix.SYS_IOCTL,
+	"kexec_file_load":    unix.SYS_KEXEC_FILE_LOAD,
+	"kexec_load":         unix.SYS_KEXEC_LOAD,
+	"keyctl":             unix.SYS_KEYCTL,
+	"kill":               unix.SYS_KILL,
+	"linkat":             unix.SYS_LINKAT,
+	"listen":             unix.SYS_LISTEN,
+	"lookup_dcookie":     unix.SYS_LOOKUP_DCOOKIE,
+	"lremovexattr":       unix.SYS_LREMOVEXATTR,
+	"lsetxattr":          unix.SYS_LSETXATTR,
+	"madvise":            unix.SYS_MADVISE,
+	"memfd_create":       unix.SYS_MEMFD_CREATE,
+	"mkdirat":            unix.SYS_MKDIRAT,
+	"mknodat":            unix.SYS_MKNODAT,
+	"mlock":              unix.SYS_MLOCK,
+	"mlockall":           unix.SYS_MLOCKALL,
+	"mmap":               unix.SYS_MMAP,
+	"mount":              unix.SYS_MOUNT,
+	"mount_setattr":      unix.SYS_MOUNT_SETATTR,
+	"move_mount":         unix.SYS_MOVE_MOUNT,
+	"mprotect":           unix.SYS_MPROTECT,
+	"msync":              unix.SYS_MSYNC,
+	"name_to_handle_at":  unix.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at":  unix.SYS_OPEN_BY_HANDLE_AT,
+	"open_tree":          unix.SYS_OPEN_TREE,
+	"openat":             unix.SYS_OPENAT,
+	"openat2":            unix.SYS_OPENAT2,
+	"perf_event_open":    unix.SYS_PERF_EVENT_OPEN,
+	"personality":        unix.SYS_PERSONALITY,
+	"pivot_root":         unix.SYS_PIVOT_ROOT,
+	"prctl":              unix.SYS_PRCTL,
+	"process_vm_readv":   unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev":  unix.SYS_PROCESS_VM_WRITEV,
+	"ptrace":             unix.SYS_PTRACE,
+	"quotactl":           unix.SYS_QUOTACTL,
+	"read":               unix.SYS_READ,
+	"reboot":             unix.SYS_REBOOT,
+	"recvfrom":           unix.SYS_RECVFROM,
+	"recvmsg":            unix.SYS_RECVMSG,
+	"removexattr":        unix.SYS_REMOVEXATTR,
+	"renameat":           unix.SYS_RENAMEAT,
+	"renameat2":          unix.SYS_RENAMEAT2,
+	"request_key":        unix.SYS_REQUEST_KEY,
+	"sched_setaffinity":  unix.SYS_SCHED_SETAFFINITY,
+	"sched_setscheduler": unix.SYS_SCHED_SETSCHEDULER,
+	"seccomp":            unix.SYS_SECCOMP,
+	"sendmsg":            unix.SYS_SENDMSG,
+	"sendto":             unix.SYS_SENDTO,
+	"setdomainname":      unix.SYS_SETDOMAINNAME,
+	"setgid":             unix.SYS_SETGID,
+	"setgroups":          unix.SYS_SETGROUPS,
+	"sethostname":        unix.SYS_SETHOSTNAME,
+	"setns":              unix.SYS_SETNS,
+	"setpriority":        unix.SYS_SETPRIORITY,
+	"setregid":           unix.SYS_SETREGID,
+	"setresgid":          unix.SYS_SETRESGID,
+	"setresuid":          unix.SYS_SETRESUID,
+	"setreuid":           unix.SYS_SETREUID,
+	"setsockopt":         unix.SYS_SETSOCKOPT,
+	"settimeofday":       unix.SYS_SETTIMEOFDAY,
+	"setuid":             unix.SYS_SETUID,
+	"setxattr":           unix.SYS_SETXATTR,
+	"shutdown":           unix.SYS_SHUTDOWN,
+	"socket":             unix.SYS_SOCKET,
+	"socketpair":         unix.SYS_SOCKETPAIR,
+	"splice":             unix.SYS_SPLICE,
+	"swapoff":            unix.SYS_SWAPOFF,
+	"swapon":             unix.SYS_SWAPON,
+	"symlinkat":          unix.SYS_SYMLINKAT,
+	"sync":               unix.SYS_SYNC,
+	"syncfs":             unix.SYS_SYNCFS,
+	"tee":                unix.SYS_TEE,
+	"tgkill":             unix.SYS_TGKILL,
+	"tkill":              unix.SYS_TKILL,
+	"truncate":           unix.SYS_TRUNCATE,
+	"umount2":            unix.SYS_UMOUNT2,
+	"unlinkat":           unix.SYS_UNLINKAT,
+	"unshare":            unix.SYS_UNSHARE,
+	"userfaultfd":        unix.SYS_USERFAULTFD,
+	"utimensat":          unix.SYS_UTIMENSAT,
+	"vhangup":            unix.SYS_VHANGUP,
+	"vmsplice":           unix.SYS_VMSPLICE,
+	"write":              unix.SYS_WRITE,
+}
@@ -0,0 +1,166 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompRules_filter(t *testing.T) {
+	rules := &seccompRules{Syscalls: []string{"mount", "socket"}, Action: seccompKill}
+	prog, err := rules.filter()
+	require.NoError(t, err)
+
+	// The architecture check comes first, the catch-all allow last.
+	assert.Equal(t, uint32(seccompAuditArch), prog[1].K)
+	assert.Equal(t, uint32(unix.SECCOMP_RET_KILL_PROCESS), prog[2].K)
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), prog[len(prog)-1].K)
+	denied := map[uint32]bool{}
+	for i, ins := range prog[:len(prog)-1] {
+		if ins.Code == unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K && i > 1 {
+			denied[ins.K] = true
+			assert.Equal(t, uint32(unix.SECCOMP_RET_KILL_PROCESS), prog[i+1].K)
+		}
+	}
+	assert.Equal(t, map[uint32]bool{uint32(unix.SYS_MOUNT): true, uint32(unix.SYS_SOCKET): true}, denied)
+
+	rules.Action = seccompErrno
+	rules.DenyWriteOpens = true
+	withOpens, err := rules.filter()
+	require.NoError(t, err)
+	assert.Len(t, withOpens, len(prog)+5*len(seccompOpenCalls))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)), withOpens[len(prog)-2].K)
+
+	_, err = (&seccompRules{Syscalls: []string{"frobnicate"}}).filter()
+	assert.EqualError(t, err, `unknown syscall "frobnicate"`)
+}
+
+func TestBuiltinSeccompProfiles_denyIOUring(t *testing.T) {
+	// io_uring would open files and sockets around every other group.
+	for name, profile := range builtinSeccompProfiles {
+		rules, err := resolveSeccompProfile(name, profile)
+		require.NoError(t, err)
+		for _, call := range []string{"io_uring_setup", "io_uring_enter", "io_uring_register"} {
+			assert.Contains(t, rules.Syscalls, call, "profile %s", name)
+		}
+	}
+}
+
+func TestCommandExecutor_seccomp(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+	workspace := t.TempDir()
+
+	newExecutor := func(shell bool, seccomp SeccompConfig) *CommandExecutor {
+		return newCommandExecutor(SecurityConfig{
+			Enabled:            true,
+			UseShellExecution:  shell,
+			AllowedCommands:    []string{"bash"},
+			AllowedExecutables: []string{"cat", "touch", "head"},
+			MaxExecutionTime:   5 * time.Second,
+			WorkingDirectory:   workspace,
+			Seccomp:            seccomp,
+		}, logger)
+	}
+
+	tests := []struct {
+		name           string
+		shell          bool
+		seccomp        SeccompConfig
+		command        string
+		wantStatus     string
+		wantStdout     string
+		wantStderr     string
+		wantViolations []string
+	}{
+		{
+			name:       "default profile runs ordinary commands",
+			shell:      true,
+			seccomp:    SeccompConfig{Profile: "default"},
+			command:    "echo hello > note && cat note",
+			wantStatus: "success",
+			wantStdout: "hello",
+		},
+		{
+			name:           "no-network kills a connect",
+			shell:          true,
+			seccomp:        SeccompConfig{Profile: "no-network"},
+			command:        "echo > /dev/tcp/127.0.0.1/9",
+			wantStatus:     "seccomp_violation",
+			wantViolations: []string{"bash: no-network"},
+		},
+		{
+			name:       "read-only-fs allows reads",
+			seccomp:    SeccompConfig{Profile: "read-only-fs"},
+			command:    "cat /etc/passwd | head -c 4",
+			wantStatus: "success",
+			wantStdout: "root",
+		},
+		{
+			name:           "read-only-fs kills a write",
+			seccomp:        SeccompConfig{Executables: map[string]string{"touch": "read-only-fs"}},
+			command:        "cat /etc/passwd | touch probe",
+			wantStatus:     "seccomp_violation",
+			wantViolations: []string{"touch: read-only-fs"},
+		},
+		{
+			name:       "an executable can opt out",
+			seccomp:    SeccompConfig{Profile: "read-only-fs", Executables: map[string]string{"touch": "none"}},
+			command:    "cat /etc/passwd | touch probe",
+			wantStatus: "success",
+		},
+		{
+			name:           "a shell's profile covers what it runs",
+			shell:          true,
+			seccomp:        SeccompConfig{Executables: map[string]string{"bash": "read-only-fs", "touch": "none"}},
+			command:        "touch probe",
+			wantStatus:     "seccomp_violation",
+			wantViolations: []string{"bash: read-only-fs"},
+		},
+		{
+			name:  "errno fails the syscall instead",
+			shell: true,
+			seccomp: SeccompConfig{
+				Profile:  "quiet",
+				Profiles: map[string]SeccompProfile{"quiet": {Deny: []string{"@fs-write"}, Action: "errno"}},
+			},
+			command:    "mkdir sub",
+			wantStatus: "error",
+			wantStderr: "Operation not permitted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := execute(ctx, newExecutor(tt.shell, tt.seccomp), tt.command, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status, result.Stderr)
+			assert.Equal(t, tt.wantStdout, result.Stdout)
+			assert.Contains(t, result.Stderr, tt.wantStderr)
+			assert.Equal(t, tt.wantViolations, result.SeccompViolations)
+		})
+	}
+
+	t.Run("combined with the sandbox", func(t *testing.T) {
+		requireUserNamespaces(t)
+		sandboxed := newCommandExecutor(SecurityConfig{
+			Enabled:           true,
+			UseShellExecution: true,
+			AllowedCommands:   []string{"bash"},
+			MaxExecutionTime:  5 * time.Second,
+			WorkingDirectory:  workspace,
+			Sandbox:           SandboxConfig{Enabled: true, Network: true},
+			Seccomp:           SeccompConfig{Profile: "no-network"},
+		}, logger)
+		result, err := execute(ctx, sandboxed, "echo > /dev/tcp/127.0.0.1/9", false)
+		require.NoError(t, err)
+		assert.Equal(t, "seccomp_violation", result.Status, result.Stderr)
+		assert.Equal(t, []string{"bash: no-network"}, result.SeccompViolations)
+	})
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "errors"
+
+// Seccomp filters are built for Linux on amd64 and arm64 only; elsewhere
+// assigning a profile is a configuration error.
+
+const seccompSupported = false
+
+func seccompSyscall(string) (uint32, bool) { return 0, false }
+
+func (*seccompRules) install() error {
+	return errors.New("requires Linux on amd64 or arm64")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSeccomp(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SeccompConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{
+			name:    "reserved profile name",
+			config:  SeccompConfig{Profiles: map[string]SeccompProfile{"none": {}}},
+			wantErr: `seccomp profile name "none" is reserved`,
+		},
+		{
+			name:    "unknown action",
+			config:  SeccompConfig{Profiles: map[string]SeccompProfile{"p": {Action: "trap"}}},
+			wantErr: `seccomp profile "p": action must be kill or errno, not "trap"`,
+		},
+		{
+			name:    "execve denied",
+			config:  SeccompConfig{Profiles: map[string]SeccompProfile{"p": {Deny: []string{"execve"}}}},
+			wantErr: `seccomp profile "p": execve cannot be denied, the command itself is started with it`,
+		},
+	}
+	if seccompSupported {
+		tests = append(tests, []struct {
+			name    string
+			config  SeccompConfig
+			wantErr string
+		}{
+			{
+				name: "built-in and custom profiles",
+				config: SeccompConfig{
+					Profile:     "default",
+					Executables: map[string]string{"curl": "no-network", "git": "none", "make": "build"},
+					Profiles:    map[string]SeccompProfile{"build": {Deny: []string{"@privileged", "ptrace"}, Action: "errno"}},
+				},
+			},
+			{
+				name:    "unknown profile",
+				config:  SeccompConfig{Executables: map[string]string{"curl": "offline"}},
+				wantErr: `unknown seccomp profile "offline"`,
+			},
+			{
+				name:    "unknown group",
+				config:  SeccompConfig{Profiles: map[string]SeccompProfile{"p": {Deny: []string{"@everything"}}}},
+				wantErr: `seccomp profile "p": unknown syscall group "@everything"`,
+			},
+			{
+				name:    "unknown syscall",
+				config:  SeccompConfig{Profiles: map[string]SeccompProfile{"p": {Deny: []string{"frobnicate"}}}},
+				wantErr: `seccomp profile "p": unknown syscall "frobnicate"`,
+			},
+		}...)
+	} else {
+		tests = append(tests, struct {
+			name    string
+			config  SeccompConfig
+			wantErr string
+		}{
+			name:    "unsupported platform",
+			config:  SeccompConfig{Profile: "default"},
+			wantErr: "seccomp profiles require Linux on amd64 or arm64",
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSeccomp(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSeccompConfig_enabled(t *testing.T) {
+	assert.False(t, SeccompConfig{}.enabled())
+	assert.False(t, SeccompConfig{Profile: "none", Executables: map[string]string{"git": "none"}}.enabled())
+	assert.True(t, SeccompConfig{Profile: "default"}.enabled())
+	assert.True(t, SeccompConfig{Executables: map[string]string{"curl": "no-network"}}.enabled())
+}
+
+func TestSeccompPolicy_rulesFor(t *testing.T) {
+	if !seccompSupported {
+		t.Skip("seccomp profiles require Linux on amd64 or arm64")
+	}
+	policy, err := newSeccompPolicy(SeccompConfig{
+		Profile:     "default",
+		Executables: map[string]string{"curl": "no-network", "git": "none", "cp": "copy"},
+		Profiles: map[string]SeccompProfile{
+			"copy": {Deny: []string{"socket", "@fs-write", "socket"}, Action: "errno"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "default", policy.rulesFor("ls").Profile)
+	assert.Equal(t, "no-network", policy.rulesFor("/usr/bin/curl").Profile)
+	assert.Contains(t, policy.rulesFor("curl").Syscalls, "connect")
+	assert.Contains(t, policy.rulesFor("curl").Syscalls, "mount")
+	assert.Nil(t, policy.rulesFor("git"))
+
+	custom := policy.rulesFor("cp")
+	assert.Equal(t, seccompErrno, custom.Action)
+	assert.True(t, custom.DenyWriteOpens)
+	assert.Contains(t, custom.Syscalls, "unlinkat")
+	assert.Equal(t, slices.Compact(slices.Clone(custom.Syscalls)), custom.Syscalls)
+	assert.False(t, custom.kills())
+	assert.True(t, policy.rulesFor("ls").kills())
+
+	var none *seccompPolicy
+	assert.Nil(t, none.rulesFor("ls"))
+	disabled, err := newSeccompPolicy(SeccompConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, disabled)
+}
@@ -1,33 +1,132 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/rs/zerolog"
 )
 
+// Rules name the check that rejected a command, as reported by shell_explain.
+// Most are the security.yaml key that decides the outcome.
+const (
+	ruleSyntax          = "syntax"
+	ruleNoAllowlist     = "no_allowed_executables"
+	ruleAllowlist       = "allowed_executables"
+	ruleInterpreter     = "interpreter"
+	rulePolicyPrefix    = "arg_policy:"
+	ruleInlineEnv       = "inline_env"
+	ruleConfinePaths    = "confine_paths"
+	ruleBlockedPattern  = "blocked_patterns"
+	ruleBlockedCommand  = "blocked_commands"
+	ruleAllowedCommands = "allowed_commands"
+	ruleExecutablePin   = "executable_pin"
+)
+
+// violation is a validation failure tagged with the rule that raised it. Its
+// message is the wrapped error's, so callers that only print errors see no
+// difference.
+type violation struct {
+	rule string
+	err  error
+}
+
+func (e *violation) Error() string { return e.err.Error() }
+func (e *violation) Unwrap() error { return e.err }
+
+func reject(rule string, err error) error {
+	return &violation{rule: rule, err: err}
+}
+
+// ruleOf returns the rule that raised err, or "" if it is not a violation.
+func ruleOf(err error) string {
+	var v *violation
+	if errors.As(err, &v) {
+		return v.rule
+	}
+	return ""
+}
+
 type SecurityValidator struct {
-	config   SecurityConfig
-	logger   zerolog.Logger
-	unfurler *commandUnfurler
-	policies *policySet
+	config    SecurityConfig
+	logger    zerolog.Logger
+	unfurler  *commandUnfurler
+	policies  *policySet
+	inlineEnv map[string]*regexp.Regexp
+	confine   *pathConfinement
+	// dir is the per-call directory commands are judged as running in;
+	// empty means the working directory.
+	dir string
 }
 
 func newSecurityValidator(cfg SecurityConfig, logger zerolog.Logger) *SecurityValidator {
 	v := &SecurityValidator{
-		config:   cfg,
-		logger:   logger.With().Str("component", "security").Logger(),
-		unfurler: newCommandUnfurler(),
-		policies: newDefaultPolicySet(),
+		config:    cfg,
+		logger:    logger.With().Str("component", "security").Logger(),
+		unfurler:  newCommandUnfurler(cfg),
+		policies:  newConfiguredPolicySet(cfg.ArgPolicies),
+		inlineEnv: compileInlineEnv(cfg.InlineEnv),
+		confine:   newPathConfinement(cfg),
 	}
 	v.warnOnInterpreters()
+	v.warnOnDeniedInlineEnv()
+	v.warnOnReplacedPolicies()
 	return v
 }
 
+// in returns a copy of v that judges commands as running in dir, an absolute
+// directory inside the working directory, as resolved by resolveWorkDir.
+// Relative paths are taken from dir; the roots they must stay in do not move.
+func (v *SecurityValidator) in(dir string) *SecurityValidator {
+	c := *v
+	c.dir = dir
+	c.unfurler = v.unfurler.in(dir)
+	if v.confine != nil {
+		confine := *v.confine
+		confine.workDir = dir
+		c.confine = &confine
+	}
+	return &c
+}
+
+// resolveWorkDir resolves a per-call working directory against the
+// configured one; see resolveWorkDir.
+func (v *SecurityValidator) resolveWorkDir(dir string) (string, error) {
+	return resolveWorkDir(v.config.WorkingDirectory, dir)
+}
+
+// workDir is the directory commands are judged as running in.
+func (v *SecurityValidator) workDir() string {
+	if v.dir != "" {
+		return v.dir
+	}
+	return v.config.WorkingDirectory
+}
+
+// compileInlineEnv compiles the inline_env value patterns, anchored so they
+// must match the whole value. An empty pattern accepts any value and maps to
+// nil; a name whose pattern does not compile (validateConfig rejects those) is
+// left out, so it is not allowed at all.
+func compileInlineEnv(patterns map[string]string) map[string]*regexp.Regexp {
+	compiled := make(map[string]*regexp.Regexp, len(patterns))
+	for name, pattern := range patterns {
+		if pattern == "" {
+			compiled[name] = nil
+			continue
+		}
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			continue
+		}
+		compiled[name] = re
+	}
+	return compiled
+}
+
 // warnOnInterpreters flags allowlisted executables that can execute arbitrary
 // commands regardless of metacharacter checks (shell/language interpreters, and
 // git via `-c alias.x=!cmd`). Allowing one defeats secure mode; the warning
@@ -45,6 +144,63 @@ func (v *SecurityValidator) warnOnInterpreters() {
 	}
 }
 
+// warnOnDeniedInlineEnv flags inline_env entries that are hard-denied anyway,
+// so a configuration that looks like it allows them does not go unnoticed.
+func (v *SecurityValidator) warnOnDeniedInlineEnv() {
+	for name := range v.config.InlineEnv {
+		if isDeniedInlineEnv(name) {
+			v.logger.Warn().
+				Str("variable", name).
+				Msg("inline_env entry is always denied - it can make the executed program load or run arbitrary code")
+		}
+	}
+}
+
+// deniedInlineEnvPrefixes and deniedInlineEnv name variables that make the
+// dynamic loader, a shell or a commonly allowlisted tool load or run code of
+// the caller's choosing. They can never be set inline, whatever inline_env
+// says.
+var deniedInlineEnvPrefixes = []string{"LD_", "DYLD_", "GIT_CONFIG", "BASH_FUNC_"}
+
+var deniedInlineEnv = map[string]bool{
+	"PATH": true, "BASH_ENV": true, "ENV": true, "IFS": true, "SHELLOPTS": true,
+	"BASHOPTS": true, "PS4": true, "PROMPT_COMMAND": true, "CDPATH": true,
+	"GCONV_PATH": true, "HOSTALIASES": true, "LOCPATH": true, "NLSPATH": true,
+	"PYTHONPATH": true, "PYTHONSTARTUP": true, "PERL5LIB": true, "PERL5OPT": true,
+	"RUBYLIB": true, "RUBYOPT": true, "NODE_OPTIONS": true, "NODE_PATH": true,
+	"GIT_SSH": true, "GIT_SSH_COMMAND": true, "GIT_EXEC_PATH": true,
+	"GIT_EXTERNAL_DIFF": true, "GIT_PAGER": true, "GIT_EDITOR": true,
+	"GIT_ASKPASS": true, "SSH_ASKPASS": true, "PAGER": true, "EDITOR": true,
+	"VISUAL": true, "LESSOPEN": true, "LESSCLOSE": true, "MANPAGER": true,
+	"TAR_OPTIONS": true,
+}
+
+// isDeniedInlineEnv reports whether name may never be assigned inline.
+func isDeniedInlineEnv(name string) bool {
+	if deniedInlineEnv[name] {
+		return true
+	}
+	for _, prefix := range deniedInlineEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnOnReplacedPolicies flags arg_policies entries that replace a built-in
+// policy rather than extend it, dropping the escape-hatch denials it encodes.
+func (v *SecurityValidator) warnOnReplacedPolicies() {
+	builtins := newDefaultPolicySet()
+	for exe, spec := range v.config.ArgPolicies {
+		if !spec.Extend && builtins.governs(exe) {
+			v.logger.Warn().
+				Str("executable", exe).
+				Msg("arg_policies entry replaces the built-in policy - set extend: true to keep its denials")
+		}
+	}
+}
+
 // isInterpreterExecutable reports whether base names an executable that can
 // itself run arbitrary commands, making executable-allowlisting ineffective.
 func isInterpreterExecutable(base string) bool {
@@ -56,32 +212,32 @@ func isInterpreterExecutable(base string) bool {
 	return false
 }
 
-func (v *SecurityValidator) validateCommand(command string) error {
+// validateCommand judges command and returns the plan it was judged as: the
+// exact argvs, after expansion, that are to be run. The plan is nil in
+// legacy shell mode, where only bash knows what a command runs. A command
+// rejected in secure mode still returns its plan when it could be parsed, for
+// explain to break down.
+func (v *SecurityValidator) validateCommand(command string) (*execPlan, error) {
 	if !v.config.Enabled {
 		v.logger.Debug().Str("command", command).Msg("Security disabled, allowing command")
-		return nil
+		if v.config.UseShellExecution {
+			return nil, nil
+		}
+		res := v.unfurler.unfurl(command)
+		if !res.Allowed {
+			return nil, reject(ruleSyntax, fmt.Errorf("command parsing failed: %s", res.Reason))
+		}
+		return res.Plan, nil
 	}
 
 	v.logger.Debug().Str("command", command).Msg("Validating command")
 
-	// If shell execution is disabled and we have allowed executables configured,
-	// use the secure validation approach
-	if !v.config.UseShellExecution && len(v.config.AllowedExecutables) > 0 {
-		return v.validateExecutableCommand(command)
-	}
-
 	// Legacy validation for backwards compatibility
 	if v.config.UseShellExecution {
 		v.logger.Warn().
 			Str("command", command).
 			Msg("Using legacy shell execution mode - this is vulnerable to injection attacks")
-		return v.validateLegacyCommand(command)
-	}
-
-	// If no allowed executables are configured but security is enabled,
-	// block everything for safety
-	if len(v.config.AllowedExecutables) == 0 {
-		return fmt.Errorf("no allowed executables configured - all commands blocked for security")
+		return nil, v.validateLegacyCommand(command)
 	}
 
 	return v.validateExecutableCommand(command)
@@ -89,41 +245,147 @@ func (v *SecurityValidator) validateCommand(command string) error {
 
 // validateExecutableCommand validates commands using the secure executable
 // allowlist approach. The command is parsed into an AST and accepted only if it
-// is a single, fully-literal simple command (structural whitelist); the
-// resolved argv is then checked against the executable allowlist, per-tool
-// argument policies, and the blocked_patterns/blocked_commands filters.
-func (v *SecurityValidator) validateExecutableCommand(command string) error {
+// is a list of pipelines of fully-literal simple commands (structural
+// whitelist); every command's resolved argv is then checked against the
+// executable allowlist and per-tool argument policies, including commands a
+// short-circuit may never reach, and both every expanded command and the
+// whole command against the blocked_patterns/blocked_commands filters. The
+// plan it returns is the one checked, so globs are expanded once, for the
+// validator, the executor and explain. Without allowed executables every
+// command is blocked for safety.
+func (v *SecurityValidator) validateExecutableCommand(command string) (*execPlan, error) {
 	res := v.unfurler.unfurl(command)
 	if !res.Allowed {
-		return fmt.Errorf("command rejected in secure mode: %s", res.Reason)
+		res.Plan = nil
+	}
+	if len(v.config.AllowedExecutables) == 0 {
+		return res.Plan, reject(ruleNoAllowlist, fmt.Errorf("no allowed executables configured - all commands blocked for security"))
+	}
+	if !res.Allowed {
+		return nil, reject(ruleSyntax, fmt.Errorf("command rejected in secure mode: %s", res.Reason))
+	}
+
+	for _, cmd := range res.Plan.commands() {
+		if err := v.checkEnv(cmd.Env); err != nil {
+			return res.Plan, err
+		}
+		if err := v.checkArgv(cmd.Argv); err != nil {
+			return res.Plan, err
+		}
+		// Expansion can produce text the command itself never contains,
+		// so the filters also see every command as it will run.
+		if err := v.checkBlockedPatternsAndCommands(strings.Join(slices.Concat(cmd.Env, cmd.Argv), " ")); err != nil {
+			return res.Plan, err
+		}
+	}
+
+	// Apply blocked_patterns and blocked_commands to restrict specific
+	// arguments (e.g. block "git remote -v" while allowing git).
+	if err := v.checkBlockedPatternsAndCommands(command); err != nil {
+		return res.Plan, err
+	}
+	return res.Plan, nil
+}
+
+// validateArgv validates a structured argv, which is executed as is and never
+// parsed, so the unfurler has nothing to say about it: it goes straight to the
+// executable allowlist, the per-tool argument policies and, on the arguments
+// joined by spaces, the blocked_patterns/blocked_commands filters. In legacy
+// mode the joined string gets the legacy checks instead.
+func (v *SecurityValidator) validateArgv(argv []string) error {
+	if len(argv) == 0 || argv[0] == "" {
+		return reject(ruleSyntax, fmt.Errorf("argv must name an executable"))
+	}
+	command := strings.Join(argv, " ")
+	if !v.config.Enabled {
+		v.logger.Debug().Strs("argv", argv).Msg("Security disabled, allowing argv")
+		return nil
+	}
+
+	v.logger.Debug().Strs("argv", argv).Msg("Validating argv")
+
+	if v.config.UseShellExecution {
+		return v.validateLegacyCommand(command)
+	}
+	if len(v.config.AllowedExecutables) == 0 {
+		return reject(ruleNoAllowlist, fmt.Errorf("no allowed executables configured - all commands blocked for security"))
 	}
+	if err := v.checkArgv(argv); err != nil {
+		return err
+	}
+	return v.checkBlockedPatternsAndCommands(command)
+}
+
+// checkArgv checks one resolved argv against the executable allowlist and the
+// per-tool argument policies.
+func (v *SecurityValidator) checkArgv(argv []string) error {
+	executable := argv[0]
 
-	executable := res.Argv[0]
+	allowed, ok := v.allowlistEntry(executable)
+	if !ok {
+		return reject(ruleAllowlist, fmt.Errorf("executable '%s' not in allowed list", executable))
+	}
+	// An interpreter defeats the allowlist by executing whatever it is
+	// handed. Hard-deny it unless a per-tool policy governs its arguments.
+	if isInterpreterExecutable(filepath.Base(executable)) && !v.policies.governs(executable) {
+		return reject(ruleInterpreter, fmt.Errorf("executable '%s' is an interpreter and cannot be allowed in secure mode", executable))
+	}
+	if err := v.policies.check(argv); err != nil {
+		return reject(rulePolicyPrefix+filepath.Base(executable), err)
+	}
+	if err := v.checkPaths(argv); err != nil {
+		return reject(ruleConfinePaths, err)
+	}
+	v.logger.Debug().
+		Str("executable", executable).
+		Str("allowed_pattern", allowed).
+		Msg("Command validated against allowed executable")
+	return nil
+}
+
+// checkPaths confines the files argv names to the configured roots when
+// confine_paths is on. Only the named paths are judged, not what a recursive
+// walk beneath them reaches.
+func (v *SecurityValidator) checkPaths(argv []string) error {
+	if v.confine == nil {
+		return nil
+	}
+	for _, arg := range v.policies.pathArgs(argv) {
+		if err := v.confine.check(arg); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(argv[0]), err)
+		}
+	}
+	return nil
+}
 
+// allowlistEntry returns the first allowed_executables entry that executable
+// matches.
+func (v *SecurityValidator) allowlistEntry(executable string) (string, bool) {
 	for _, allowed := range v.config.AllowedExecutables {
 		if v.matchesExecutable(executable, allowed) {
-			// An interpreter defeats the allowlist by executing whatever it is
-			// handed. Hard-deny it unless a per-tool policy governs its arguments.
-			if isInterpreterExecutable(filepath.Base(executable)) && !v.policies.governs(executable) {
-				return fmt.Errorf("executable '%s' is an interpreter and cannot be allowed in secure mode", executable)
-			}
-			if err := v.policies.check(res.Argv); err != nil {
-				return err
-			}
-			// Apply blocked_patterns and blocked_commands to restrict specific
-			// arguments (e.g. block "git remote -v" while allowing git).
-			if err := v.checkBlockedPatternsAndCommands(command); err != nil {
-				return err
-			}
-			v.logger.Debug().
-				Str("executable", executable).
-				Str("allowed_pattern", allowed).
-				Msg("Command validated against allowed executable")
-			return nil
+			return allowed, true
 		}
 	}
+	return "", false
+}
 
-	return fmt.Errorf("executable '%s' not in allowed list", executable)
+// checkEnv checks a command's inline NAME=value assignments against the
+// hard-denied names and the inline_env allowlist and its value patterns.
+func (v *SecurityValidator) checkEnv(env []string) error {
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if isDeniedInlineEnv(name) {
+			return reject(ruleInlineEnv, fmt.Errorf("inline assignment of %s is never allowed", name))
+		}
+		re, ok := v.inlineEnv[name]
+		if !ok {
+			return reject(ruleInlineEnv, fmt.Errorf("inline assignment of %s is not allowed", name))
+		}
+		if re != nil && !re.MatchString(value) {
+			return reject(ruleInlineEnv, fmt.Errorf("inline assignment of %s: value %q does not match the allowed pattern", name, value))
+		}
+	}
+	return nil
 }
 
 // matchesExecutable checks if an executable matches an allowed pattern
@@ -143,8 +405,8 @@ func (v *SecurityValidator) matchesExecutable(executable, pattern string) bool {
 
 	// Check if it's a basename match for simple commands (only if executable is not absolute)
 	if !filepath.IsAbs(executable) && filepath.Base(executable) == pattern {
-		// Verify the executable exists in PATH
-		if _, err := exec.LookPath(executable); err == nil {
+		// Verify the executable exists in the PATH children run with
+		if _, err := lookPath(executable, v.config.childPath()); err == nil {
 			return true
 		}
 	}
@@ -157,13 +419,13 @@ func (v *SecurityValidator) matchesExecutable(executable, pattern string) bool {
 func (v *SecurityValidator) checkBlockedPatternsAndCommands(command string) error {
 	for _, pattern := range v.config.BlockedPatterns {
 		if matched, err := regexp.MatchString(pattern, command); err == nil && matched {
-			return fmt.Errorf("command matches blocked pattern: %s", pattern)
+			return reject(ruleBlockedPattern, fmt.Errorf("command matches blocked pattern: %s", pattern))
 		}
 	}
 
 	for _, blocked := range v.config.BlockedCommands {
 		if strings.Contains(command, blocked) {
-			return fmt.Errorf("command contains blocked keyword: %s", blocked)
+			return reject(ruleBlockedCommand, fmt.Errorf("command contains blocked keyword: %s", blocked))
 		}
 	}
 	return nil
@@ -184,7 +446,7 @@ func (v *SecurityValidator) validateLegacyCommand(command string) error {
 			}
 		}
 		if !allowed {
-			return fmt.Errorf("command not in allowed list")
+			return reject(ruleAllowedCommands, fmt.Errorf("command not in allowed list"))
 		}
 	}
 
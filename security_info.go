@@ -5,4 +5,31 @@ type SecurityInfo struct {
 	WorkingDir      string `json:"working_dir,omitempty"`
 	RunAsUser       string `json:"run_as_user,omitempty"`
 	TimeoutApplied  bool   `json:"timeout_applied"`
+	// Timeout is the timeout the execution ran under, after capping a
+	// requested one at max_timeout.
+	Timeout string `json:"timeout,omitempty"`
+	// Environment names the variables every child started with, when the
+	// environment is controlled; values are never reported.
+	Environment []string `json:"environment,omitempty"`
+	// Backend names the backend the command ran on: local, sandbox or ssh.
+	Backend string `json:"backend,omitempty"`
+	// Sandboxed reports that the command ran in the namespace sandbox.
+	Sandboxed bool `json:"sandboxed,omitempty"`
+	// LandlockABI is the Landlock ABI version the filesystem restrictions
+	// were enforced with; zero when Landlock is not in force.
+	LandlockABI int `json:"landlock_abi,omitempty"`
+	// Credentials are what the command ran with, as seen on the host.
+	Credentials *Credentials `json:"credentials,omitempty"`
+}
+
+// Credentials describe the identity and privileges a command ran with.
+type Credentials struct {
+	Uid    int   `json:"uid"`
+	Gid    int   `json:"gid"`
+	Groups []int `json:"groups"`
+	// NoNewPrivs reports that no_new_privs was set, so that no setuid or
+	// file capability could raise the command's privileges.
+	NoNewPrivs bool `json:"no_new_privs"`
+	// CapabilitiesDropped reports that the command held no capabilities.
+	CapabilitiesDropped bool `json:"capabilities_dropped"`
 }
@@ -141,6 +141,75 @@ func TestSecurityValidator_validateCommand(t *testing.T) {
 			expectError:   true,
 			errorContains: "blocked keyword",
 		},
+		{
+			name: "secure mode with blocked_patterns - brace expansion recreating the pattern",
+			config: SecurityConfig{
+				Enabled:            true,
+				UseShellExecution:  false,
+				AllowedExecutables: []string{"rm"},
+				BlockedPatterns:    []string{`rm\s+-rf`},
+			},
+			command:       "rm -r{f,} x",
+			expectError:   true,
+			errorContains: "blocked pattern",
+		},
+		{
+			name: "secure mode with blocked_commands - variable expanding to the keyword",
+			config: SecurityConfig{
+				Enabled:             true,
+				UseShellExecution:   false,
+				AllowedExecutables:  []string{"echo"},
+				BlockedCommands:     []string{"shadow"},
+				ExpandableVariables: []string{"X"},
+				Variables:           map[string]string{"X": "/etc/shadow"},
+			},
+			command:       "echo $X",
+			expectError:   true,
+			errorContains: "blocked keyword",
+		},
+		{
+			name: "secure mode allows pipeline of allowed executables",
+			config: SecurityConfig{
+				Enabled:            true,
+				UseShellExecution:  false,
+				AllowedExecutables: []string{"grep", "sort", "uniq"},
+			},
+			command:     "grep foo file | sort | uniq -c",
+			expectError: false,
+		},
+		{
+			name: "secure mode checks every pipeline stage against the allowlist",
+			config: SecurityConfig{
+				Enabled:            true,
+				UseShellExecution:  false,
+				AllowedExecutables: []string{"ls", "grep"},
+			},
+			command:       "ls | grep x | rm -rf /",
+			expectError:   true,
+			errorContains: "'rm' not in allowed list",
+		},
+		{
+			name: "secure mode applies arg policies to every pipeline stage",
+			config: SecurityConfig{
+				Enabled:            true,
+				UseShellExecution:  false,
+				AllowedExecutables: []string{"ls", "sort"},
+			},
+			command:       "ls | sort -o /tmp/out",
+			expectError:   true,
+			errorContains: "writes to an arbitrary file",
+		},
+		{
+			name: "secure mode applies arg policies to brace-expanded arguments",
+			config: SecurityConfig{
+				Enabled:            true,
+				UseShellExecution:  false,
+				AllowedExecutables: []string{"sort"},
+			},
+			command:       "sort {-o,/tmp/out}",
+			expectError:   true,
+			errorContains: "writes to an arbitrary file",
+		},
 		// GHSA-74hp-mggr-hv58: git shell-alias bypass via `-c alias.x=!cmd`.
 		// Now caught by the per-tool git argument policy, not metacharacters.
 		{
@@ -172,7 +241,7 @@ func TestSecurityValidator_validateCommand(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			validator := newSecurityValidator(tt.config, logger)
-			err := validator.validateCommand(tt.command)
+			_, err := validator.validateCommand(tt.command)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -244,7 +313,7 @@ func TestSecurityValidator_validateExecutableCommand(t *testing.T) {
 				AllowedExecutables: tt.allowedExecutables,
 			}
 			validator := newSecurityValidator(config, logger)
-			err := validator.validateExecutableCommand(tt.command)
+			_, err := validator.validateExecutableCommand(tt.command)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -452,7 +521,7 @@ func TestSecurityValidator_vulnerability_scenarios(t *testing.T) {
 
 		for _, payload := range vulnerabilityPayloads {
 			t.Run(payload.name, func(t *testing.T) {
-				err := validator.validateCommand(payload.command)
+				_, err := validator.validateCommand(payload.command)
 				if err != nil {
 					assert.Error(t, err, "Secure mode should block: %s", payload.description)
 					// Any of these messages indicates the command was blocked.
@@ -477,12 +546,12 @@ func TestSecurityValidator_vulnerability_scenarios(t *testing.T) {
 		validator := newSecurityValidator(config, logger)
 
 		// The VULN.md example demonstrates the vulnerability - obfuscated commands bypass keyword matching
-		err := validator.validateCommand("echo $($(echo -n c; echo -n h; echo -n m; echo -n o; echo -n d))")
+		_, err := validator.validateCommand("echo $($(echo -n c; echo -n h; echo -n m; echo -n o; echo -n d))")
 		// This should pass because "chmod" doesn't appear literally in the command
 		assert.NoError(t, err, "Legacy mode cannot detect obfuscated commands")
 
 		// But a simple rm should be blocked
-		err = validator.validateCommand("rm file")
+		_, err = validator.validateCommand("rm file")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "blocked keyword")
 	})
@@ -498,9 +567,269 @@ func TestSecurityValidator_vulnerability_scenarios(t *testing.T) {
 		// All payloads would pass validation (but still be dangerous)
 		for _, payload := range vulnerabilityPayloads {
 			t.Run(payload.name, func(t *testing.T) {
-				err := validator.validateCommand(payload.command)
+				_, err := validator.validateCommand(payload.command)
 				assert.NoError(t, err, "Legacy mode without blocks allows: %s", payload.description)
 			})
 		}
 	})
 }
+
+func TestSecurityValidator_globExpansionFeedsPolicies(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	workDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "a.txt"), nil, 0o644))
+
+	validator := newSecurityValidator(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"ls", "find"},
+		WorkingDirectory:   workDir,
+	}, logger)
+
+	plan, err := validator.validateCommand("find *.txt -name a.txt")
+	require.NoError(t, err)
+	// The plan is returned as judged, with the glob already expanded.
+	assert.Equal(t, []string{"find", "a.txt", "-name", "a.txt"}, plan.commands()[0].Argv)
+
+	// A file named like a dangerous primary is expanded into argv and must be
+	// judged by the find policy exactly as if it had been typed.
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "-delete"), nil, 0o644))
+	_, err = validator.validateCommand("find * -name a.txt")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"-delete" is not allowed`)
+
+	// The expanded executable is checked against the allowlist too.
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "rm"), nil, 0o755))
+	_, err = validator.validateCommand("r? a.txt")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'rm' not in allowed list")
+}
+
+func TestSecurityValidator_inlineEnv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	validator := newSecurityValidator(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"sort", "ls"},
+		InlineEnv: map[string]string{
+			"LC_ALL":     "C|POSIX|[a-z]{2}_[A-Z]{2}\\.UTF-8",
+			"TZ":         "",
+			"LD_PRELOAD": "",
+		},
+	}, logger)
+
+	tests := []struct {
+		name          string
+		command       string
+		errorContains string
+	}{
+		{name: "allowed name and value", command: "LC_ALL=C sort file"},
+		{name: "pattern alternative", command: "LC_ALL=en_US.UTF-8 sort file"},
+		{name: "any value", command: "TZ=Europe/Madrid ls"},
+		{name: "every pipeline stage is checked", command: "ls | LC_ALL=C sort"},
+		{
+			name:          "pattern must match the whole value",
+			command:       "LC_ALL=Cx sort file",
+			errorContains: "does not match the allowed pattern",
+		},
+		{
+			name:          "unlisted name",
+			command:       "LANG=C sort file",
+			errorContains: "inline assignment of LANG is not allowed",
+		},
+		{
+			name:          "hard-denied even when configured",
+			command:       "LD_PRELOAD=/tmp/x.so ls",
+			errorContains: "inline assignment of LD_PRELOAD is never allowed",
+		},
+		{
+			name:          "PATH is hard-denied",
+			command:       "PATH=/tmp ls",
+			errorContains: "inline assignment of PATH is never allowed",
+		},
+		{
+			name:          "BASH_ENV is hard-denied",
+			command:       "BASH_ENV=/tmp/x ls",
+			errorContains: "inline assignment of BASH_ENV is never allowed",
+		},
+		{
+			name:          "denied in a later list step",
+			command:       "ls && LD_LIBRARY_PATH=/tmp ls",
+			errorContains: "inline assignment of LD_LIBRARY_PATH is never allowed",
+		},
+		{
+			name:          "executable still checked",
+			command:       "TZ=UTC rm -rf /",
+			errorContains: "'rm' not in allowed list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.validateCommand(tt.command)
+			if tt.errorContains == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
+
+func TestSecurityValidator_confinePaths(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	work := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(work, "notes.txt"), []byte("x\n"), 0o644))
+
+	validator := newSecurityValidator(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"cat", "grep", "sort", "find", "echo"},
+		WorkingDirectory:   work,
+		ConfinePaths:       true,
+	}, logger)
+
+	tests := []struct {
+		name          string
+		command       string
+		errorContains string
+	}{
+		{name: "relative file", command: "cat notes.txt"},
+		{name: "glob inside the workspace", command: "cat *.txt"},
+		{name: "grep pattern is not a path", command: "grep -r /etc ."},
+		{name: "echo has no paths", command: "echo /etc/shadow"},
+		{name: "find inside", command: "find . -name '*.go'"},
+		{
+			name:          "absolute path outside",
+			command:       "cat /etc/shadow",
+			errorContains: `cat: "/etc/shadow" resolves outside the readable roots`,
+		},
+		{
+			name:          "recursive grep outside",
+			command:       "grep -r token /home",
+			errorContains: `"/home" resolves outside`,
+		},
+		{
+			name:          "parent escape",
+			command:       "cat ../../../etc/passwd",
+			errorContains: "resolves outside the readable roots",
+		},
+		{
+			name:          "later pipeline stage",
+			command:       "cat notes.txt | grep x /etc/passwd",
+			errorContains: `grep: "/etc/passwd" resolves outside`,
+		},
+		{
+			name:          "find starting point",
+			command:       "find / -name shadow",
+			errorContains: `find: "/" resolves outside`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.validateCommand(tt.command)
+			if tt.errorContains == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+			assert.Equal(t, ruleConfinePaths, ruleOf(err))
+		})
+	}
+
+	err := validator.validateArgv([]string{"cat", "/etc/hostname"})
+	require.Error(t, err)
+	assert.Equal(t, ruleConfinePaths, ruleOf(err))
+}
+
+func TestSecurityValidator_validateArgv(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+
+	tests := []struct {
+		name          string
+		config        SecurityConfig
+		argv          []string
+		errorContains string
+	}{
+		{
+			name:   "allowed executable",
+			config: SecurityConfig{Enabled: true, AllowedExecutables: []string{"ls"}},
+			argv:   []string{"ls", "-la"},
+		},
+		{
+			name:   "metacharacters are inert arguments",
+			config: SecurityConfig{Enabled: true, AllowedExecutables: []string{"echo"}},
+			argv:   []string{"echo", "$(id)", ";", "|", "*"},
+		},
+		{
+			name:          "executable not allowed",
+			config:        SecurityConfig{Enabled: true, AllowedExecutables: []string{"ls"}},
+			argv:          []string{"rm", "-rf", "/"},
+			errorContains: "'rm' not in allowed list",
+		},
+		{
+			name:          "interpreter is hard-denied",
+			config:        SecurityConfig{Enabled: true, AllowedExecutables: []string{"bash"}},
+			argv:          []string{"bash", "-c", "id"},
+			errorContains: "is an interpreter",
+		},
+		{
+			name:          "argument policies apply",
+			config:        SecurityConfig{Enabled: true, AllowedExecutables: []string{"find"}},
+			argv:          []string{"find", ".", "-exec", "id", ";"},
+			errorContains: "-exec",
+		},
+		{
+			name: "blocked patterns see the joined argv",
+			config: SecurityConfig{
+				Enabled:            true,
+				AllowedExecutables: []string{"ls"},
+				BlockedPatterns:    []string{`(^|\s)/etc/shadow(\s|$)`},
+			},
+			argv:          []string{"ls", "-l", "/etc/shadow"},
+			errorContains: "blocked pattern",
+		},
+		{
+			name:          "empty executable",
+			config:        SecurityConfig{Enabled: true, AllowedExecutables: []string{"ls"}},
+			argv:          []string{""},
+			errorContains: "must name an executable",
+		},
+		{
+			name:          "nothing allowed",
+			config:        SecurityConfig{Enabled: true},
+			argv:          []string{"ls"},
+			errorContains: "no allowed executables configured",
+		},
+		{
+			name:   "security disabled",
+			config: SecurityConfig{Enabled: false},
+			argv:   []string{"anything"},
+		},
+		{
+			name: "legacy mode applies the legacy checks",
+			config: SecurityConfig{
+				Enabled:           true,
+				UseShellExecution: true,
+				AllowedCommands:   []string{"ls"},
+			},
+			argv:          []string{"cat", "/etc/passwd"},
+			errorContains: "command not in allowed list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newSecurityValidator(tt.config, logger).validateArgv(tt.argv)
+			if tt.errorContains == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
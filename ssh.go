@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// sshRemote runs the stages of the ssh backend's commands on the remote
+// host. Every stage becomes an ssh client whose remote command runs exactly
+// the stage's argv, each word quoted, so the remote shell parses nothing the
+// command said. Pipes, lists and here-documents stay on the server, as with
+// every other backend.
+type sshRemote struct {
+	// client is the ssh client binary, or clientErr why it was not found.
+	client    string
+	clientErr error
+	// args are the client's options and the destination.
+	args []string
+	// root is the local working directory and dir the remote directory
+	// mirroring it, empty for the remote account's home.
+	root string
+	dir  string
+}
+
+func newSSHRemote(cfg SecurityConfig) *sshRemote {
+	c := cfg.Backends.SSH
+	r := &sshRemote{root: cfg.WorkingDirectory, dir: c.WorkingDirectory}
+	client := c.Client
+	if client == "" {
+		client = "ssh"
+	}
+	r.client, r.clientErr = exec.LookPath(client)
+
+	// No configuration file is read, nothing can prompt, and only a host key
+	// already known is accepted.
+	r.args = []string{"-F", "none", "-T", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}
+	if c.KnownHostsFile != "" {
+		r.args = append(r.args, "-o", "UserKnownHostsFile="+c.KnownHostsFile)
+	}
+	if c.IdentityFile != "" {
+		r.args = append(r.args, "-i", c.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if c.Port != 0 {
+		r.args = append(r.args, "-p", strconv.Itoa(c.Port))
+	}
+	if c.User != "" {
+		r.args = append(r.args, "-l", c.User)
+	}
+	if c.ConnectTimeout > 0 {
+		r.args = append(r.args, "-o", fmt.Sprintf("ConnectTimeout=%d", int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+	r.args = append(r.args, "--", c.Host)
+	return r
+}
+
+// newSSHExecutor builds the ssh backend: a CommandExecutor whose stages run
+// on the remote host. The limits, sandbox, Landlock, seccomp, exec tracing,
+// privileges, run_as_user, pinned executables and child environment would
+// all confine the local ssh client rather than the command, so none of them
+// applies; the remote account confines what it runs.
+func newSSHExecutor(cfg SecurityConfig, logger zerolog.Logger) *CommandExecutor {
+	var skipped []string
+	if cfg.Limits.rlimitSpec() != "" || len(cfg.Limits.cgroupControllers()) > 0 {
+		skipped = append(skipped, "limits")
+	}
+	if cfg.Landlock.Enabled {
+		skipped = append(skipped, "landlock")
+	}
+	if cfg.Seccomp.enabled() {
+		skipped = append(skipped, "seccomp")
+	}
+	if cfg.ExecTracing.Enabled {
+		skipped = append(skipped, "exec_tracing")
+	}
+	if cfg.Privileges.Enabled {
+		skipped = append(skipped, "privileges")
+	}
+	if cfg.RunAsUser != "" {
+		skipped = append(skipped, "run_as_user")
+	}
+	if cfg.Environment != nil {
+		skipped = append(skipped, "environment")
+	}
+
+	local := cfg
+	local.Limits, local.Sandbox, local.Landlock = ResourceLimits{}, SandboxConfig{}, LandlockConfig{}
+	local.Seccomp, local.ExecTracing, local.Privileges = SeccompConfig{}, ExecTracingConfig{}, PrivilegesConfig{}
+	local.RunAsUser, local.Environment = "", nil
+	e := &CommandExecutor{
+		config:  local,
+		logger:  logger.With().Str("component", "executor").Str("backend", backendSSH).Logger(),
+		backend: backendSSH,
+		remote:  newSSHRemote(cfg),
+	}
+	if e.remote.clientErr != nil {
+		e.logger.Error().Err(e.remote.clientErr).Msg("ssh client not found - every command on the ssh backend will be refused")
+	}
+	if len(skipped) > 0 {
+		e.logger.Warn().
+			Strs("not_applied", skipped).
+			Msg("Commands on the ssh backend are only confined by the remote account")
+	}
+	e.logger.Info().
+		Str("host", cfg.Backends.SSH.Host).
+		Str("remote_dir", cfg.Backends.SSH.WorkingDirectory).
+		Msg("ssh backend configured")
+	return e
+}
+
+// wrap makes cmd, built to run stage in the local dir, run it on the remote
+// host in the corresponding directory instead.
+func (r *sshRemote) wrap(cmd *exec.Cmd, stage *plannedCommand, dir string) {
+	cmd.Path = r.client
+	cmd.Args = append(append([]string{"ssh"}, r.args...), r.command(stage.Argv, stage.Env, dir))
+	cmd.Err = nil
+}
+
+// command renders the remote command line that runs argv, with the
+// assignments env, in the remote counterpart of dir.
+func (r *sshRemote) command(argv, env []string, dir string) string {
+	var b strings.Builder
+	if remote := r.remoteDir(dir); remote != "" {
+		b.WriteString("cd " + shellQuote(remote) + " && ")
+	}
+	b.WriteString("exec")
+	if len(env) > 0 {
+		b.WriteString(" env")
+		for _, assignment := range env {
+			b.WriteString(" " + shellQuote(assignment))
+		}
+	}
+	for _, arg := range argv {
+		b.WriteString(" " + shellQuote(arg))
+	}
+	return b.String()
+}
+
+// remoteDir maps dir, inside the local working directory, to the same place
+// beneath the remote one. Without a remote working directory, commands run
+// in the remote account's home and it returns "".
+func (r *sshRemote) remoteDir(dir string) string {
+	if r.dir == "" || r.root == "" || dir == "" {
+		return r.dir
+	}
+	rel, err := filepath.Rel(r.root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return r.dir
+	}
+	return path.Join(r.dir, filepath.ToSlash(rel))
+}
+
+// check refuses a plan that redirects to or from a file, which would be
+// opened on the server rather than where the command runs. Here-documents and
+// descriptor duplication only involve the client's streams and are kept.
+func (r *sshRemote) check(plan *execPlan) error {
+	for _, cmd := range plan.commands() {
+		for _, redir := range cmd.Redirs {
+			switch redir.Op {
+			case redirIn, redirOut, redirAppend:
+				return fmt.Errorf("the ssh backend cannot redirect '%s' to or from a file", cmd.Argv[0])
+			}
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// validateSSHBackend checks the backends.ssh block of security.yaml.
+func validateSSHBackend(c SSHBackendConfig) error {
+	switch {
+	case c.Host == "":
+		return errors.New("the ssh backend requires backends.ssh.host")
+	case strings.HasPrefix(c.Host, "-") || strings.ContainsAny(c.Host, " \t\n"):
+		return fmt.Errorf("backends.ssh.host %q is not a host name", c.Host)
+	case strings.HasPrefix(c.User, "-") || strings.ContainsAny(c.User, " \t\n@"):
+		return fmt.Errorf("backends.ssh.user %q is not a user name", c.User)
+	case c.Port < 0 || c.Port > 65535:
+		return fmt.Errorf("backends.ssh.port %d is out of range", c.Port)
+	case c.ConnectTimeout < 0:
+		return errors.New("backends.ssh.connect_timeout cannot be negative")
+	case c.WorkingDirectory != "" && !path.IsAbs(c.WorkingDirectory):
+		return fmt.Errorf("backends.ssh.working_directory %q must be an absolute path", c.WorkingDirectory)
+	}
+	return nil
+}
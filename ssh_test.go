@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSSHRemote(t *testing.T) {
+	remote := newSSHRemote(SecurityConfig{Backends: BackendsConfig{SSH: SSHBackendConfig{
+		Host:           "build.example.com",
+		Port:           2222,
+		User:           "mcp",
+		IdentityFile:   "/etc/mcp-shell/id_ed25519",
+		KnownHostsFile: "/etc/mcp-shell/known_hosts",
+		ConnectTimeout: 1500 * time.Millisecond,
+	}}})
+	assert.Equal(t, []string{
+		"-F", "none", "-T", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=/etc/mcp-shell/known_hosts",
+		"-i", "/etc/mcp-shell/id_ed25519", "-o", "IdentitiesOnly=yes",
+		"-p", "2222", "-l", "mcp", "-o", "ConnectTimeout=2",
+		"--", "build.example.com",
+	}, remote.args)
+}
+
+func TestSSHRemote_command(t *testing.T) {
+	tests := []struct {
+		name      string
+		remoteDir string
+		argv      []string
+		env       []string
+		dir       string
+		want      string
+	}{
+		{
+			name: "every word quoted",
+			argv: []string{"echo", "it's", "$HOME", "; rm -rf /"},
+			want: `exec 'echo' 'it'\''s' '$HOME' '; rm -rf /'`,
+		},
+		{
+			name: "assignments",
+			argv: []string{"sort"},
+			env:  []string{"LC_ALL=C"},
+			want: `exec env 'LC_ALL=C' 'sort'`,
+		},
+		{
+			name:      "working directory",
+			remoteDir: "/srv/work",
+			argv:      []string{"ls"},
+			dir:       "/tmp/workspace",
+			want:      `cd '/srv/work' && exec 'ls'`,
+		},
+		{
+			name:      "subdirectory",
+			remoteDir: "/srv/work",
+			argv:      []string{"ls"},
+			dir:       "/tmp/workspace/src/app",
+			want:      `cd '/srv/work/src/app' && exec 'ls'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := &sshRemote{root: "/tmp/workspace", dir: tt.remoteDir}
+			assert.Equal(t, tt.want, remote.command(tt.argv, tt.env, tt.dir))
+		})
+	}
+}
+
+func TestValidateSSHBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SSHBackendConfig
+		wantErr string
+	}{
+		{name: "host only", config: SSHBackendConfig{Host: "build.example.com"}},
+		{name: "no host", wantErr: "the ssh backend requires backends.ssh.host"},
+		{
+			name:    "option as host",
+			config:  SSHBackendConfig{Host: "-oProxyCommand=sh"},
+			wantErr: `backends.ssh.host "-oProxyCommand=sh" is not a host name`,
+		},
+		{
+			name:    "user with a host",
+			config:  SSHBackendConfig{Host: "build", User: "mcp@other"},
+			wantErr: `backends.ssh.user "mcp@other" is not a user name`,
+		},
+		{
+			name:    "port out of range",
+			config:  SSHBackendConfig{Host: "build", Port: 70000},
+			wantErr: "backends.ssh.port 70000 is out of range",
+		},
+		{
+			name:    "relative working directory",
+			config:  SSHBackendConfig{Host: "build", WorkingDirectory: "work"},
+			wantErr: `backends.ssh.working_directory "work" must be an absolute path`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSSHBackend(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// localSSHClient writes a stand-in for the ssh client that runs the remote
+// command, its last argument, with the local sh.
+func localSSHClient(t *testing.T) string {
+	t.Helper()
+	client := filepath.Join(t.TempDir(), "ssh")
+	script := "#!/bin/sh\nfor command; do :; done\nexec /bin/sh -c \"$command\"\n"
+	require.NoError(t, os.WriteFile(client, []byte(script), 0o755))
+	return client
+}
+
+func TestCommandExecutor_ssh(t *testing.T) {
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	ctx := context.Background()
+
+	workspace := t.TempDir()
+	remoteDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(workspace, "sub"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(remoteDir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(remoteDir, "sub", "remote.txt"), nil, 0o644))
+
+	config := SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"echo", "tr", "pwd", "ls", "sh", "cat"},
+		InlineEnv:          map[string]string{"GREETING": ""},
+		MaxExecutionTime:   5 * time.Second,
+		WorkingDirectory:   workspace,
+		Backends: BackendsConfig{
+			Default: backendSSH,
+			SSH: SSHBackendConfig{
+				Host:             "build.example.com",
+				WorkingDirectory: remoteDir,
+				Client:           localSSHClient(t),
+			},
+		},
+	}
+	executor := newExecutor(config, logger)
+	require.IsType(t, &CommandExecutor{}, executor)
+
+	tests := []struct {
+		name         string
+		command      string
+		cwd          string
+		wantStatus   string
+		wantExitCode int
+		wantStdout   string
+		wantDir      string
+		wantErr      string
+	}{
+		{
+			name:       "words reach the remote command unexpanded",
+			command:    `echo "it's" '$HOME' '*'`,
+			wantStatus: "success",
+			wantStdout: "it's $HOME *",
+			wantDir:    remoteDir,
+		},
+		{
+			name:       "pipelines connect remote stages",
+			command:    "echo hello | tr a-z A-Z",
+			wantStatus: "success",
+			wantStdout: "HELLO",
+			wantDir:    remoteDir,
+		},
+		{
+			name:       "the call's directory maps to the remote one",
+			command:    "pwd && ls",
+			cwd:        filepath.Join(workspace, "sub"),
+			wantStatus: "success",
+			wantStdout: filepath.Join(remoteDir, "sub") + "\nremote.txt",
+			wantDir:    filepath.Join(remoteDir, "sub"),
+		},
+		{
+			name:       "assignments are made remotely",
+			command:    `GREETING=hi sh -c 'echo $GREETING'`,
+			wantStatus: "success",
+			wantStdout: "hi",
+			wantDir:    remoteDir,
+		},
+		{
+			name:       "here-documents feed the remote command",
+			command:    "cat <<EOF\nfrom the server\nEOF",
+			wantStatus: "success",
+			wantStdout: "from the server",
+			wantDir:    remoteDir,
+		},
+		{
+			name:         "exit code of the remote command",
+			command:      "sh -c 'exit 3'",
+			wantStatus:   "error",
+			wantExitCode: 3,
+			wantDir:      remoteDir,
+		},
+		{
+			name:    "file redirections are refused",
+			command: "echo hi > out.txt",
+			wantErr: "the ssh backend cannot redirect 'echo' to or from a file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scoped := executor
+			if tt.cwd != "" {
+				scoped = executor.in(tt.cwd)
+			}
+			result, err := execute(ctx, scoped, tt.command, false)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, result.Status, result.Stderr)
+			assert.Equal(t, tt.wantExitCode, result.ExitCode)
+			assert.Equal(t, tt.wantStdout, result.Stdout)
+			assert.Equal(t, backendSSH, result.SecurityInfo.Backend)
+			assert.Equal(t, tt.wantDir, result.SecurityInfo.WorkingDir)
+			assert.Nil(t, result.SecurityInfo.Credentials)
+		})
+	}
+
+	t.Run("missing client refuses every command", func(t *testing.T) {
+		missing := config
+		missing.Backends.SSH.Client = filepath.Join(t.TempDir(), "ssh")
+		_, err := execute(ctx, newExecutor(missing, logger), "echo hi", false)
+		assert.ErrorContains(t, err, "ssh backend: locate the ssh client")
+	})
+}
+
+// TestCommandExecutor_sshd runs commands against a real sshd listening on
+// the loopback interface, when one is installed.
+func TestCommandExecutor_sshd(t *testing.T) {
+	sshd, err := exec.LookPath("sshd")
+	if err != nil {
+		sshd = "/usr/sbin/sshd"
+	}
+	if _, err := os.Stat(sshd); err != nil {
+		t.Skip("sshd is not installed")
+	}
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("the ssh client is not installed")
+	}
+	logger := zerolog.New(zerolog.NewTestWriter(t))
+	me, err := user.Current()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	keygen := func(name string) {
+		out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, name)).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	keygen("host_key")
+	keygen("client_key")
+	clientKey, err := os.ReadFile(filepath.Join(dir, "client_key.pub"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "authorized_keys"), clientKey, 0o600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	sshdConfig := fmt.Sprintf(`Port %d
+ListenAddress 127.0.0.1
+HostKey %s
+AuthorizedKeysFile %s
+PidFile none
+StrictModes no
+PasswordAuthentication no
+KbdInteractiveAuthentication no
+PermitRootLogin prohibit-password
+`, port, filepath.Join(dir, "host_key"), filepath.Join(dir, "authorized_keys"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sshd_config"), []byte(sshdConfig), 0o600))
+	hostKey, err := os.ReadFile(filepath.Join(dir, "host_key.pub"))
+	require.NoError(t, err)
+	knownHosts := fmt.Sprintf("[127.0.0.1]:%d %s", port, hostKey)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownHosts), 0o600))
+
+	var sshdLog strings.Builder
+	server := exec.Command(sshd, "-D", "-e", "-f", filepath.Join(dir, "sshd_config"))
+	server.Stderr = &sshdLog
+	require.NoError(t, server.Start())
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+	ready := false
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			conn.Close()
+			ready = true
+			break
+		}
+	}
+	if !ready {
+		t.Skipf("sshd did not start: %s", sshdLog.String())
+	}
+
+	remoteDir := t.TempDir()
+	executor := newExecutor(SecurityConfig{
+		Enabled:            true,
+		AllowedExecutables: []string{"echo", "pwd", "tr"},
+		MaxExecutionTime:   10 * time.Second,
+		WorkingDirectory:   t.TempDir(),
+		Backends: BackendsConfig{
+			Default: backendSSH,
+			SSH: SSHBackendConfig{
+				Host:             "127.0.0.1",
+				Port:             port,
+				User:             me.Username,
+				IdentityFile:     filepath.Join(dir, "client_key"),
+				KnownHostsFile:   filepath.Join(dir, "known_hosts"),
+				WorkingDirectory: remoteDir,
+				ConnectTimeout:   5 * time.Second,
+			},
+		},
+	}, logger)
+
+	result, err := execute(context.Background(), executor, `pwd && echo "it's" | tr a-z A-Z`, false)
+	require.NoError(t, err)
+	assert.Equal(t, "success", result.Status, result.Stderr+"\n"+sshdLog.String())
+	assert.Equal(t, remoteDir+"\nIT'S", result.Stdout)
+}
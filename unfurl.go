@@ -1,33 +1,154 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
+	"mvdan.cc/sh/v3/pattern"
 	"mvdan.cc/sh/v3/syntax"
 )
 
+// plannedCommand is one simple command of an execution plan: a fully-resolved
+// literal argv ready to be handed to exec without any shell in between, plus
+// the redirections the executor applies, in order, before starting it. Env
+// holds the command's inline NAME=value assignments, which the validator must
+// accept before the executor adds them to that command's environment. Path,
+// set by the executor from the executable's pin, is the file actually run;
+// when empty, argv[0] is looked up in PATH.
+type plannedCommand struct {
+	Argv   []string
+	Env    []string
+	Redirs []plannedRedirect
+	Path   string
+}
+
+// redirOp is a redirection the executor performs itself.
+type redirOp string
+
+const (
+	redirIn     redirOp = "<"
+	redirOut    redirOp = ">"
+	redirAppend redirOp = ">>"
+	redirDup    redirOp = ">&"
+	redirData   redirOp = "<<"
+)
+
+// plannedRedirect is one validated redirection. Path is the symlink-resolved
+// target inside the working directory for file redirections; DupFd is the
+// source descriptor for >& (2>&1 copies fd 1 onto fd 2); Data is the literal
+// stdin of a here-document or here-string.
+type plannedRedirect struct {
+	Fd    int
+	Op    redirOp
+	Path  string
+	DupFd int
+	Data  []byte
+}
+
+// pipelinePlan is one or more commands connected stdout-to-stdin, left to
+// right. A plain simple command is a pipeline with a single stage.
+type pipelinePlan struct {
+	Stages []*plannedCommand
+}
+
+// argvs returns the argv of every stage, in pipeline order.
+func (p *pipelinePlan) argvs() [][]string {
+	out := make([][]string, len(p.Stages))
+	for i, st := range p.Stages {
+		out[i] = st.Argv
+	}
+	return out
+}
+
+// listOp is the operator joining a plan step to the one before it.
+type listOp string
+
+const (
+	opFirst listOp = ""
+	opSeq   listOp = ";"
+	opAnd   listOp = "&&"
+	opOr    listOp = "||"
+)
+
+// planStep is one pipeline of a command list together with the operator that
+// decides, from the previous step's exit code, whether it runs at all.
+type planStep struct {
+	Op       listOp
+	Pipeline *pipelinePlan
+}
+
+// execPlan is the small execution plan a command string unfurls into: a list
+// of pipelines evaluated left to right with &&/||/; short-circuit semantics.
+// && and || have equal precedence and associate left in the shell, so a flat
+// sequence evaluated against the last exit code is exactly equivalent.
+type execPlan struct {
+	Steps []*planStep
+}
+
+// commands returns every simple command in the plan, in source order.
+func (p *execPlan) commands() []*plannedCommand {
+	var out []*plannedCommand
+	for _, step := range p.Steps {
+		out = append(out, step.Pipeline.Stages...)
+	}
+	return out
+}
+
 // unfurlResult is the outcome of structurally analysing one command string.
-// Argv is valid only when Allowed is true; otherwise Reason explains the reject.
+// Plan is valid only when Allowed is true; otherwise Reason explains the
+// reject.
 type unfurlResult struct {
-	Argv    []string
+	Plan    *execPlan
 	Allowed bool
 	Reason  string
 }
 
 // commandUnfurler parses a command string into a shell AST and decides whether
-// it is a single, fully-literal simple command, extracting its resolved argv.
+// it is a list of pipelines of fully-literal simple commands, extracting each
+// command's resolved argv into an execPlan.
 // syntax.Parser is reusable but not safe for concurrent use, so parsers are
 // pooled: each call borrows one and returns it, keeping allocations low without
 // sharing state across goroutines.
 type commandUnfurler struct {
-	parsers sync.Pool
+	parsers *sync.Pool
+	workDir string
+	// dir is the directory relative globs and redirection targets are taken
+	// from, a directory inside workDir; empty means workDir itself.
+	dir            string
+	maxGlobMatches int
+	maxArgvLength  int
+	maxStdinSize   int
+	vars           map[string]string
 }
 
-func newCommandUnfurler() *commandUnfurler {
+// newCommandUnfurler builds an unfurler for cfg. Redirection targets and glob
+// matches are confined to cfg.WorkingDirectory; with none configured, both are
+// rejected.
+func newCommandUnfurler(cfg SecurityConfig) *commandUnfurler {
+	maxGlobMatches := cfg.MaxGlobMatches
+	if maxGlobMatches <= 0 {
+		maxGlobMatches = defaultMaxGlobMatches
+	}
+	maxArgvLength := cfg.MaxArgvLength
+	if maxArgvLength <= 0 {
+		maxArgvLength = defaultMaxArgvLength
+	}
+	maxStdinSize := cfg.MaxStdinSize
+	if maxStdinSize <= 0 {
+		maxStdinSize = defaultMaxStdinSize
+	}
 	return &commandUnfurler{
-		parsers: sync.Pool{
+		workDir:        cfg.WorkingDirectory,
+		maxGlobMatches: maxGlobMatches,
+		maxArgvLength:  maxArgvLength,
+		maxStdinSize:   maxStdinSize,
+		vars:           expansionVariables(cfg),
+		parsers: &sync.Pool{
 			New: func() any {
 				return syntax.NewParser(syntax.Variant(syntax.LangBash))
 			},
@@ -35,13 +156,33 @@ func newCommandUnfurler() *commandUnfurler {
 	}
 }
 
+// in returns a copy of u that takes relative globs and redirection targets
+// from dir, an absolute directory inside the working directory, and expands
+// $PWD to it where $PWD is the working directory.
+func (u *commandUnfurler) in(dir string) *commandUnfurler {
+	c := *u
+	c.dir = dir
+	if pwd, ok := u.vars["PWD"]; ok && pwd == u.workDir {
+		c.vars = maps.Clone(u.vars)
+		c.vars["PWD"] = dir
+	}
+	return &c
+}
+
 // unfurl reports unsafe input via unfurlResult.Allowed/Reason rather than an
-// error. The structural whitelist is default-deny: only a single simple command
-// whose every argument is a constant literal is allowed. The bash variant is
-// used so the widest set of dynamic constructs is recognised and rejected.
+// error. The structural whitelist is default-deny: only statements joined by
+// ;, && or ||, each a simple command or a `|` pipeline of simple commands
+// whose every argument is a constant literal, are allowed. Unquoted globs are
+// expanded in-process against the working directory, so what the validator
+// sees is the argv that will run; brace expansion ({a,b}, {1..3}) is likewise
+// resolved before that, and the resulting argv is capped. Simple commands may
+// carry <, >, >> and 2>&1 redirections onto literal paths inside the working
+// directory. The bash variant is used so the widest set of dynamic constructs
+// is recognised and rejected.
 //
-// The resolved argv holds independent string copies (built via strings.Builder),
-// so the borrowed parser is safe to return to the pool on return.
+// The resolved argv holds independent string copies (built via
+// strings.Builder), so the borrowed parser is safe to return to the pool on
+// return.
 func (u *commandUnfurler) unfurl(command string) unfurlResult {
 	if strings.TrimSpace(command) == "" {
 		return unfurlResult{Reason: "empty command"}
@@ -54,76 +195,321 @@ func (u *commandUnfurler) unfurl(command string) unfurlResult {
 	if err != nil {
 		return unfurlResult{Reason: fmt.Sprintf("unparseable command: %v", err)}
 	}
-	if len(file.Stmts) != 1 {
-		return unfurlResult{Reason: "command must be exactly one statement"}
+	if len(file.Stmts) == 0 {
+		return unfurlResult{Reason: "empty command"}
+	}
+
+	var steps []*planStep
+	for i, stmt := range file.Stmts {
+		op := opSeq
+		if i == 0 {
+			op = opFirst
+		}
+		if steps, err = u.listSteps(stmt, op, steps); err != nil {
+			return unfurlResult{Reason: err.Error()}
+		}
+	}
+
+	return unfurlResult{Plan: &execPlan{Steps: steps}, Allowed: true}
+}
+
+// checkStmtFlags rejects statement modifiers that have no place in a plan.
+// Redirections are only meaningful on a simple command and are checked there.
+func checkStmtFlags(stmt *syntax.Stmt) error {
+	if stmt.Background || stmt.Coprocess || stmt.Negated {
+		return errors.New("background, coprocess and negation are not allowed")
+	}
+	if _, ok := stmt.Cmd.(*syntax.CallExpr); !ok && len(stmt.Redirs) > 0 {
+		return errors.New("redirection is only allowed on a simple command")
+	}
+	return nil
+}
+
+// listSteps flattens an &&/|| tree into plan steps in source order, appending
+// to steps. op joins the statement's first step to whatever precedes it.
+func (u *commandUnfurler) listSteps(stmt *syntax.Stmt, op listOp, steps []*planStep) ([]*planStep, error) {
+	if err := checkStmtFlags(stmt); err != nil {
+		return nil, err
 	}
 
-	stmt := file.Stmts[0]
-	if stmt.Background || stmt.Coprocess || stmt.Negated || len(stmt.Redirs) > 0 {
-		return unfurlResult{Reason: "background, coprocess, negation and redirection are not allowed"}
+	if bin, ok := stmt.Cmd.(*syntax.BinaryCmd); ok && (bin.Op == syntax.AndStmt || bin.Op == syntax.OrStmt) {
+		steps, err := u.listSteps(bin.X, op, steps)
+		if err != nil {
+			return nil, err
+		}
+		next := opAnd
+		if bin.Op == syntax.OrStmt {
+			next = opOr
+		}
+		return u.listSteps(bin.Y, next, steps)
 	}
 
-	call, ok := stmt.Cmd.(*syntax.CallExpr)
-	if !ok {
-		return unfurlResult{Reason: "only a single simple command is allowed (no pipelines, lists, subshells or control flow)"}
+	stages, err := u.pipelineStages(stmt, nil)
+	if err != nil {
+		return nil, err
 	}
-	if len(call.Assigns) > 0 {
-		return unfurlResult{Reason: "inline environment assignments are not allowed"}
+	return append(steps, &planStep{Op: op, Pipeline: &pipelinePlan{Stages: stages}}), nil
+}
+
+// pipelineStages flattens a statement into its pipeline stages, left to right,
+// appending to stages. `a | b | c` nests as BinaryCmd nodes, so both sides are
+// walked recursively. |& (stderr into the pipe) is rejected: only stdout is
+// ever connected between stages.
+func (u *commandUnfurler) pipelineStages(stmt *syntax.Stmt, stages []*plannedCommand) ([]*plannedCommand, error) {
+	if err := checkStmtFlags(stmt); err != nil {
+		return nil, err
+	}
+
+	switch cmd := stmt.Cmd.(type) {
+	case *syntax.CallExpr:
+		pc, err := u.unfurlCall(cmd)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range stmt.Redirs {
+			redir, err := u.unfurlRedirect(r)
+			if err != nil {
+				return nil, err
+			}
+			pc.Redirs = append(pc.Redirs, redir)
+		}
+		return append(stages, pc), nil
+	case *syntax.BinaryCmd:
+		if cmd.Op != syntax.Pipe {
+			return nil, errors.New("only simple commands joined by |, &&, || or ; are allowed (no |&, subshells or control flow)")
+		}
+		stages, err := u.pipelineStages(cmd.X, stages)
+		if err != nil {
+			return nil, err
+		}
+		return u.pipelineStages(cmd.Y, stages)
+	default:
+		return nil, errors.New("only simple commands joined by |, &&, || or ; are allowed (no |&, subshells or control flow)")
+	}
+}
+
+// unfurlCall resolves one simple command into its literal argv, expanding
+// unquoted globs against the working directory.
+func (u *commandUnfurler) unfurlCall(call *syntax.CallExpr) (*plannedCommand, error) {
+	env, err := u.unfurlAssigns(call.Assigns)
+	if err != nil {
+		return nil, err
 	}
 
 	argv := make([]string, 0, len(call.Args))
 	for _, word := range call.Args {
-		// Surface brace expansion ({a,b}) as a dynamic BraceExp part so it is
-		// rejected like any other expansion.
-		syntax.SplitBraces(word)
-		lit, ok := literalWord(word)
+		// Brace expansion comes first, as in bash; each resulting word is then
+		// checked and glob-expanded on its own.
+		words, ok := expandBraces(word, u.maxArgvLength-len(argv))
 		if !ok {
-			return unfurlResult{Reason: "arguments must be constant literals (no expansion, command substitution or glob)"}
+			return nil, fmt.Errorf("command expands to more than %d arguments", u.maxArgvLength)
+		}
+		for _, w := range words {
+			fields, err := u.wordFields(w)
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, fields...)
+			if len(argv) > u.maxArgvLength {
+				return nil, fmt.Errorf("command expands to more than %d arguments", u.maxArgvLength)
+			}
 		}
-		argv = append(argv, lit)
 	}
 	if len(argv) == 0 {
-		return unfurlResult{Reason: "empty command"}
+		return nil, errors.New("empty command")
 	}
 
-	return unfurlResult{Argv: argv, Allowed: true}
+	return &plannedCommand{Argv: argv, Env: env}, nil
+}
+
+// unfurlAssigns resolves inline NAME=value assignments into environment
+// entries. As in bash, the value is neither brace- nor glob-expanded, but it
+// must otherwise be literal (expandable variables aside). Appends (+=),
+// arrays and subscripts are rejected. Which names may be set is policy, left
+// to the validator.
+func (u *commandUnfurler) unfurlAssigns(assigns []*syntax.Assign) ([]string, error) {
+	var env []string
+	for _, a := range assigns {
+		if a.Append || a.Naked || a.Index != nil || a.Array != nil {
+			return nil, errors.New("only NAME=value inline assignments are allowed")
+		}
+		var lit, pat strings.Builder
+		if a.Value != nil {
+			if _, err := u.literalParts(a.Value.Parts, false, &lit, &pat); err != nil {
+				return nil, fmt.Errorf("inline assignment of %s: %w", a.Name.Value, err)
+			}
+		}
+		env = append(env, a.Name.Value+"="+lit.String())
+	}
+	return env, nil
 }
 
-// literalWord returns the constant value of a word, or ok=false if any part is
-// dynamic (parameter/command/process/arithmetic expansion, brace expansion,
-// extended glob, ANSI-C quoting) or an unquoted glob.
-func literalWord(word *syntax.Word) (string, bool) {
-	return literalParts(word.Parts, false)
+// wordFields resolves one argument word into the fields it contributes to
+// argv: its literal value, or the sorted matches when it has a live glob.
+func (u *commandUnfurler) wordFields(word *syntax.Word) ([]string, error) {
+	var lit, pat strings.Builder
+	glob, err := u.literalParts(word.Parts, false, &lit, &pat)
+	if err != nil {
+		return nil, err
+	}
+	if !glob || !isValidGlob(pat.String()) {
+		return []string{lit.String()}, nil
+	}
+	return expandGlob(u.workDir, u.dir, pat.String(), u.maxGlobMatches)
 }
 
-// literalParts concatenates constant word parts. When quoted is false, an
-// unquoted literal containing a glob metacharacter (*, ?, [) is treated as
-// dynamic; inside double quotes those characters are inert.
-func literalParts(parts []syntax.WordPart, quoted bool) (string, bool) {
-	var sb strings.Builder
+// unfurlRedirect validates one redirection. Only <, >, >> onto a literal path,
+// a dup between stdout and stderr (2>&1, >&2) and literal here-documents and
+// here-strings on stdin are accepted; the path must resolve, through
+// symlinks, inside the working directory and, if it already exists, be a
+// regular file. >| (clobber), &>, <> and fd juggling beyond 0-2 are rejected.
+func (u *commandUnfurler) unfurlRedirect(r *syntax.Redirect) (plannedRedirect, error) {
+	fd := -1
+	if r.N != nil {
+		n, err := strconv.Atoi(r.N.Value)
+		if err != nil || n < 0 || n > 2 {
+			return plannedRedirect{}, fmt.Errorf("redirection of file descriptor %q is not allowed", r.N.Value)
+		}
+		fd = n
+	}
+
+	var op redirOp
+	switch r.Op {
+	case syntax.RdrIn:
+		op = redirIn
+		if fd == -1 {
+			fd = 0
+		}
+		if fd != 0 {
+			return plannedRedirect{}, fmt.Errorf("input redirection is only allowed on stdin, not fd %d", fd)
+		}
+	case syntax.RdrOut, syntax.AppOut:
+		op = redirOut
+		if r.Op == syntax.AppOut {
+			op = redirAppend
+		}
+		if fd == -1 {
+			fd = 1
+		}
+		if fd == 0 {
+			return plannedRedirect{}, errors.New("output redirection of stdin is not allowed")
+		}
+	case syntax.DplOut:
+		if fd == -1 {
+			fd = 1
+		}
+		target, err := u.literalWord(r.Word)
+		if err != nil || !((fd == 1 && target == "2") || (fd == 2 && target == "1")) {
+			return plannedRedirect{}, errors.New("only 2>&1 and >&2 descriptor duplication is allowed")
+		}
+		dup, _ := strconv.Atoi(target)
+		return plannedRedirect{Fd: fd, Op: redirDup, DupFd: dup}, nil
+	case syntax.RdrClob:
+		return plannedRedirect{}, errors.New("clobbering redirection >| is not allowed")
+	case syntax.Hdoc, syntax.DashHdoc, syntax.WordHdoc:
+		if fd != -1 && fd != 0 {
+			return plannedRedirect{}, fmt.Errorf("here-documents and here-strings are only allowed on stdin, not fd %d", fd)
+		}
+		data, err := u.stdinData(r)
+		if err != nil {
+			return plannedRedirect{}, err
+		}
+		return plannedRedirect{Fd: 0, Op: redirData, Data: data}, nil
+	default:
+		return plannedRedirect{}, fmt.Errorf("redirection operator %s is not allowed", r.Op)
+	}
+
+	// A brace in a target would make it several paths (bash's "ambiguous
+	// redirect"); split it out so it is rejected as non-literal.
+	syntax.SplitBraces(r.Word)
+	target, err := u.literalWord(r.Word)
+	if errors.Is(err, errNotLiteral) || (err == nil && target == "") {
+		return plannedRedirect{}, errors.New("redirection target must be a constant literal path")
+	}
+	if err != nil {
+		return plannedRedirect{}, err
+	}
+	if u.workDir == "" {
+		return plannedRedirect{}, errors.New("redirection requires a configured working_directory")
+	}
+	if u.dir != "" && !filepath.IsAbs(target) {
+		target = filepath.Join(u.dir, target)
+	}
+	path, err := resolveInRoot(u.workDir, target)
+	if err != nil {
+		return plannedRedirect{}, fmt.Errorf("redirection target rejected: %w", err)
+	}
+	if err := checkRegularIfExists(path); err != nil {
+		return plannedRedirect{}, fmt.Errorf("redirection target rejected: %w", err)
+	}
+
+	return plannedRedirect{Fd: fd, Op: op, Path: path}, nil
+}
+
+// errNotLiteral rejects a word with a dynamic part.
+var errNotLiteral = errors.New("arguments must be constant literals (no expansion or command substitution)")
+
+// literalWord returns the constant value of a word, or errNotLiteral if any
+// part is dynamic (command/process/arithmetic expansion, brace expansion,
+// extended glob, ANSI-C quoting) or an unquoted glob. Expandable variables are
+// substituted.
+func (u *commandUnfurler) literalWord(word *syntax.Word) (string, error) {
+	var lit, pat strings.Builder
+	glob, err := u.literalParts(word.Parts, false, &lit, &pat)
+	if err != nil {
+		return "", err
+	}
+	if glob {
+		return "", errNotLiteral
+	}
+	return lit.String(), nil
+}
+
+// literalParts appends the constant value of word parts to lit, with shell
+// escapes and quotes removed, and the same text to pat as a glob pattern in
+// which only unquoted *, ? and [ stay live. It reports whether any live glob
+// metacharacter was seen, and errNotLiteral if any part is dynamic. An
+// expandable variable's value is inserted verbatim, quoted or not: it is
+// neither split into fields nor glob-expanded.
+func (u *commandUnfurler) literalParts(parts []syntax.WordPart, quoted bool, lit, pat *strings.Builder) (glob bool, err error) {
 	for _, part := range parts {
 		switch p := part.(type) {
 		case *syntax.Lit:
-			if !quoted && strings.ContainsAny(p.Value, "*?[") {
-				return "", false
+			if quoted {
+				v := unescapeDoubleQuoted(p.Value)
+				lit.WriteString(v)
+				pat.WriteString(pattern.QuoteMeta(v, 0))
+				continue
+			}
+			// An unquoted backslash escapes the next character in the value
+			// and in a pattern alike, so the raw text already is the pattern.
+			if pattern.HasMeta(p.Value, 0) {
+				glob = true
 			}
-			sb.WriteString(p.Value)
+			lit.WriteString(unescapeUnquoted(p.Value))
+			pat.WriteString(p.Value)
 		case *syntax.SglQuoted:
 			// $'...' (ANSI-C) can encode arbitrary bytes via escapes; reject it
 			// rather than guess at decoding the parser leaves untouched.
 			if p.Dollar {
-				return "", false
+				return false, errNotLiteral
 			}
-			sb.WriteString(p.Value)
+			lit.WriteString(p.Value)
+			pat.WriteString(pattern.QuoteMeta(p.Value, 0))
 		case *syntax.DblQuoted:
-			inner, ok := literalParts(p.Parts, true)
-			if !ok {
-				return "", false
+			if _, err := u.literalParts(p.Parts, true, lit, pat); err != nil {
+				return false, err
+			}
+		case *syntax.ParamExp:
+			v, err := u.expandParam(p)
+			if err != nil {
+				return false, err
 			}
-			sb.WriteString(inner)
+			lit.WriteString(v)
+			pat.WriteString(pattern.QuoteMeta(v, 0))
 		default:
-			return "", false
+			return false, errNotLiteral
 		}
 	}
-	return sb.String(), true
+	return glob, nil
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,6 +15,7 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 		command     string
 		wantAllowed bool
 		wantArgv    []string
+		wantStages  [][]string
 	}{
 		{
 			name:        "simple command",
@@ -69,16 +72,33 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 		{
 			name:        "pipeline",
 			command:     "ls | grep test",
+			wantAllowed: true,
+			wantStages:  [][]string{{"ls"}, {"grep", "test"}},
+		},
+		{
+			name:        "three stage pipeline",
+			command:     "grep foo file | sort | uniq -c",
+			wantAllowed: true,
+			wantStages:  [][]string{{"grep", "foo", "file"}, {"sort"}, {"uniq", "-c"}},
+		},
+		{
+			name:        "pipeline with dynamic stage",
+			command:     "ls | grep $(whoami)",
 			wantAllowed: false,
 		},
 		{
-			name:        "command list with semicolon",
-			command:     "echo hello; rm file",
+			name:        "pipeline with stderr pipe",
+			command:     "ls |& grep test",
 			wantAllowed: false,
 		},
 		{
-			name:        "logical and",
-			command:     "echo a && rm b",
+			name:        "pipeline with subshell stage",
+			command:     "ls | (grep test)",
+			wantAllowed: false,
+		},
+		{
+			name:        "negated pipeline",
+			command:     "! ls | grep test",
 			wantAllowed: false,
 		},
 		{
@@ -119,7 +139,8 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 		{
 			name:        "brace expansion",
 			command:     "echo a{b,c}",
-			wantAllowed: false,
+			wantAllowed: true,
+			wantArgv:    []string{"echo", "ab", "ac"},
 		},
 		{
 			name:        "subshell",
@@ -127,9 +148,10 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 			wantAllowed: false,
 		},
 		{
-			name:        "inline assignment",
+			name:        "inline assignment is left to the validator",
 			command:     "FOO=bar ls",
-			wantAllowed: false,
+			wantAllowed: true,
+			wantArgv:    []string{"ls"},
 		},
 		{
 			name:        "negation",
@@ -141,6 +163,29 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 			command:     "if true; then ls; fi",
 			wantAllowed: false,
 		},
+		{
+			name:        "backslash escapes are removed",
+			command:     `echo a\ b "c\"d" 'e\f'`,
+			wantAllowed: true,
+			wantArgv:    []string{"echo", "a b", `c"d`, `e\f`},
+		},
+		{
+			name:        "glob without a working directory is rejected",
+			command:     "ls *.go",
+			wantAllowed: false,
+		},
+		{
+			name:        "escaped glob is a literal",
+			command:     `echo \*`,
+			wantAllowed: true,
+			wantArgv:    []string{"echo", "*"},
+		},
+		{
+			name:        "unterminated bracket is a literal",
+			command:     "echo [",
+			wantAllowed: true,
+			wantArgv:    []string{"echo", "["},
+		},
 		{
 			name:        "ansi-c quoting is rejected",
 			command:     `echo $'\143hmod'`,
@@ -148,7 +193,7 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 		},
 	}
 
-	unfurler := newCommandUnfurler()
+	unfurler := newCommandUnfurler(SecurityConfig{})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -158,7 +203,12 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 
 			if tt.wantAllowed {
 				require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
-				assert.Equal(t, tt.wantArgv, res.Argv)
+				wantStages := tt.wantStages
+				if wantStages == nil {
+					wantStages = [][]string{tt.wantArgv}
+				}
+				require.Len(t, res.Plan.Steps, 1)
+				assert.Equal(t, wantStages, res.Plan.Steps[0].Pipeline.argvs())
 			} else {
 				require.False(t, res.Allowed)
 				assert.NotEmpty(t, res.Reason)
@@ -166,3 +216,780 @@ func TestCommandUnfurler_unfurl(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandUnfurler_unfurl_lists(t *testing.T) {
+	type wantStep struct {
+		op     listOp
+		stages [][]string
+	}
+
+	tests := []struct {
+		name        string
+		command     string
+		wantAllowed bool
+		wantSteps   []wantStep
+	}{
+		{
+			name:        "semicolon list",
+			command:     "echo hello; rm file",
+			wantAllowed: true,
+			wantSteps: []wantStep{
+				{op: opFirst, stages: [][]string{{"echo", "hello"}}},
+				{op: opSeq, stages: [][]string{{"rm", "file"}}},
+			},
+		},
+		{
+			name:        "newline separates statements like semicolon",
+			command:     "echo a\necho b",
+			wantAllowed: true,
+			wantSteps: []wantStep{
+				{op: opFirst, stages: [][]string{{"echo", "a"}}},
+				{op: opSeq, stages: [][]string{{"echo", "b"}}},
+			},
+		},
+		{
+			name:        "and-or list is flattened left to right",
+			command:     "test -f a && cat a || echo missing",
+			wantAllowed: true,
+			wantSteps: []wantStep{
+				{op: opFirst, stages: [][]string{{"test", "-f", "a"}}},
+				{op: opAnd, stages: [][]string{{"cat", "a"}}},
+				{op: opOr, stages: [][]string{{"echo", "missing"}}},
+			},
+		},
+		{
+			name:        "pipelines as list elements",
+			command:     "grep x f | sort && echo done; wc -l f",
+			wantAllowed: true,
+			wantSteps: []wantStep{
+				{op: opFirst, stages: [][]string{{"grep", "x", "f"}, {"sort"}}},
+				{op: opAnd, stages: [][]string{{"echo", "done"}}},
+				{op: opSeq, stages: [][]string{{"wc", "-l", "f"}}},
+			},
+		},
+		{
+			name:        "dynamic leaf rejects the whole list",
+			command:     "echo a && echo $(whoami)",
+			wantAllowed: false,
+		},
+		{
+			name:        "background element rejects the whole list",
+			command:     "echo a; sleep 10 &",
+			wantAllowed: false,
+		},
+		{
+			name:        "subshell element rejects the whole list",
+			command:     "echo a || (echo b)",
+			wantAllowed: false,
+		},
+		{
+			name:        "negated element rejects the whole list",
+			command:     "echo a && ! echo b",
+			wantAllowed: false,
+		},
+	}
+
+	unfurler := newCommandUnfurler(SecurityConfig{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			res := unfurler.unfurl(tt.command)
+
+			if !tt.wantAllowed {
+				require.False(t, res.Allowed)
+				assert.NotEmpty(t, res.Reason)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			require.Len(t, res.Plan.Steps, len(tt.wantSteps))
+			for i, want := range tt.wantSteps {
+				assert.Equal(t, want.op, res.Plan.Steps[i].Op)
+				assert.Equal(t, want.stages, res.Plan.Steps[i].Pipeline.argvs())
+			}
+		})
+	}
+}
+
+func TestCommandUnfurler_unfurl_redirects(t *testing.T) {
+	workDir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "data.txt"), []byte("b\na\n"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(workDir, "sub"), 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(workDir, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "absent"), filepath.Join(workDir, "dangling")))
+
+	realWorkDir, err := filepath.EvalSymlinks(workDir)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		command       string
+		wantRedirs    []plannedRedirect
+		reasonContain string
+	}{
+		{
+			name:       "output to new file",
+			command:    "sort data.txt > sorted.txt",
+			wantRedirs: []plannedRedirect{{Fd: 1, Op: redirOut, Path: filepath.Join(realWorkDir, "sorted.txt")}},
+		},
+		{
+			name:       "append",
+			command:    "echo x >> sub/log.txt",
+			wantRedirs: []plannedRedirect{{Fd: 1, Op: redirAppend, Path: filepath.Join(realWorkDir, "sub", "log.txt")}},
+		},
+		{
+			name:       "input from existing file",
+			command:    "wc -l < data.txt",
+			wantRedirs: []plannedRedirect{{Fd: 0, Op: redirIn, Path: filepath.Join(realWorkDir, "data.txt")}},
+		},
+		{
+			name:    "stderr to file then merged order is kept",
+			command: "ls 2> err.txt >&2",
+			wantRedirs: []plannedRedirect{
+				{Fd: 2, Op: redirOut, Path: filepath.Join(realWorkDir, "err.txt")},
+				{Fd: 1, Op: redirDup, DupFd: 2},
+			},
+		},
+		{
+			name:    "stdout to file with 2>&1",
+			command: "ls > out.txt 2>&1",
+			wantRedirs: []plannedRedirect{
+				{Fd: 1, Op: redirOut, Path: filepath.Join(realWorkDir, "out.txt")},
+				{Fd: 2, Op: redirDup, DupFd: 1},
+			},
+		},
+		{
+			name:          "absolute path outside the workspace",
+			command:       "echo x > /etc/passwd",
+			reasonContain: "outside the working directory",
+		},
+		{
+			name:          "dot-dot escape",
+			command:       "echo x > ../x.txt",
+			reasonContain: "outside the working directory",
+		},
+		{
+			name:          "symlink escape",
+			command:       "echo x > escape/x.txt",
+			reasonContain: "outside the working directory",
+		},
+		{
+			name:          "dangling symlink",
+			command:       "echo x > dangling",
+			reasonContain: "dangling symlink",
+		},
+		{
+			name:          "device",
+			command:       "echo x > /dev/null",
+			reasonContain: "outside the working directory",
+		},
+		{
+			name:          "directory target",
+			command:       "echo x > sub",
+			reasonContain: "not a regular file",
+		},
+		{
+			name:          "clobber",
+			command:       "echo x >| out.txt",
+			reasonContain: ">|",
+		},
+		{
+			name:          "here-string on another descriptor",
+			command:       "cat 2<<< hello",
+			reasonContain: "only allowed on stdin",
+		},
+		{
+			name:          "read-write",
+			command:       "cat <> data.txt",
+			reasonContain: "not allowed",
+		},
+		{
+			name:          "stdout and stderr shorthand",
+			command:       "ls &> out.txt",
+			reasonContain: "not allowed",
+		},
+		{
+			name:          "duplicate onto a file name",
+			command:       "ls >&out.txt",
+			reasonContain: "duplication",
+		},
+		{
+			name:          "high file descriptor",
+			command:       "ls 3> out.txt",
+			reasonContain: "file descriptor",
+		},
+		{
+			name:          "dynamic target",
+			command:       "echo x > $(mktemp)",
+			reasonContain: "constant literal",
+		},
+		{
+			name:          "unlisted variable in target",
+			command:       "echo x > $HOME/x",
+			reasonContain: "variable $HOME is not expandable",
+		},
+		{
+			name:          "redirection on a subshell",
+			command:       "(echo x) > out.txt",
+			reasonContain: "simple command",
+		},
+	}
+
+	unfurler := newCommandUnfurler(SecurityConfig{WorkingDirectory: workDir})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			assert.Equal(t, tt.wantRedirs, res.Plan.commands()[0].Redirs)
+		})
+	}
+
+	t.Run("no working directory rejects redirection", func(t *testing.T) {
+		res := newCommandUnfurler(SecurityConfig{}).unfurl("echo x > out.txt")
+		require.False(t, res.Allowed)
+		assert.Contains(t, res.Reason, "working_directory")
+	})
+}
+
+func TestCommandUnfurler_unfurl_globs(t *testing.T) {
+	workDir := t.TempDir()
+	for _, f := range []string{"main.go", "util.go", "notes.md", "logs/a.log", "logs/b.log"} {
+		full := filepath.Join(workDir, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, nil, 0o644))
+	}
+
+	tests := []struct {
+		name          string
+		command       string
+		wantArgv      []string
+		reasonContain string
+	}{
+		{
+			name:     "glob expands in place",
+			command:  "ls -l *.go notes.md",
+			wantArgv: []string{"ls", "-l", "main.go", "util.go", "notes.md"},
+		},
+		{
+			name:     "glob in a subdirectory",
+			command:  "cat logs/*.log",
+			wantArgv: []string{"cat", "logs/a.log", "logs/b.log"},
+		},
+		{
+			name:     "quoted part stays literal inside a glob word",
+			command:  `ls "logs"/*`,
+			wantArgv: []string{"ls", "logs/a.log", "logs/b.log"},
+		},
+		{
+			name:     "quoted glob is not expanded",
+			command:  `echo "*.go"`,
+			wantArgv: []string{"echo", "*.go"},
+		},
+		{
+			name:          "no match",
+			command:       "cat *.rs",
+			reasonContain: "matches no files",
+		},
+		{
+			name:          "escaping pattern",
+			command:       "cat ../*",
+			reasonContain: "outside the working directory",
+		},
+		{
+			name:          "glob in a redirect target",
+			command:       "echo x > *.md",
+			reasonContain: "constant literal",
+		},
+	}
+
+	unfurler := newCommandUnfurler(SecurityConfig{WorkingDirectory: workDir})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			assert.Equal(t, tt.wantArgv, res.Plan.commands()[0].Argv)
+		})
+	}
+
+	t.Run("expansion cap", func(t *testing.T) {
+		capped := newCommandUnfurler(SecurityConfig{WorkingDirectory: workDir, MaxGlobMatches: 1})
+		res := capped.unfurl("ls *.go")
+		require.False(t, res.Allowed)
+		assert.Contains(t, res.Reason, "more than 1 entries")
+	})
+}
+
+func TestCommandUnfurler_unfurl_braces(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		maxArgv       int
+		wantArgv      []string
+		reasonContain string
+	}{
+		{
+			name:     "alternatives",
+			command:  "mkdir -p src/{api,web}",
+			wantArgv: []string{"mkdir", "-p", "src/api", "src/web"},
+		},
+		{
+			name:     "suffix alternatives",
+			command:  "head -n5 a.{txt,md}",
+			wantArgv: []string{"head", "-n5", "a.txt", "a.md"},
+		},
+		{
+			name:     "nested and combined",
+			command:  "echo {a,b{1,2}}{x,y}",
+			wantArgv: []string{"echo", "ax", "ay", "b1x", "b1y", "b2x", "b2y"},
+		},
+		{
+			name:     "numeric sequence",
+			command:  "echo {1..3} {3..1}",
+			wantArgv: []string{"echo", "1", "2", "3", "3", "2", "1"},
+		},
+		{
+			name:     "sequence with increment and padding",
+			command:  "echo f{01..10..4}",
+			wantArgv: []string{"echo", "f01", "f05", "f09"},
+		},
+		{
+			name:     "character sequence",
+			command:  "echo {a..c}",
+			wantArgv: []string{"echo", "a", "b", "c"},
+		},
+		{
+			name:     "quoted alternative keeps its quoting",
+			command:  `echo {"a b",c}`,
+			wantArgv: []string{"echo", "a b", "c"},
+		},
+		{
+			name:     "quoted braces are literal",
+			command:  `echo "{a,b}" '{1..2}'`,
+			wantArgv: []string{"echo", "{a,b}", "{1..2}"},
+		},
+		{
+			name:     "escaped braces are literal",
+			command:  `echo \{a,b\}`,
+			wantArgv: []string{"echo", "{a,b}"},
+		},
+		{
+			name:     "escaped multibyte character",
+			command:  `echo \é{1,2}`,
+			wantArgv: []string{"echo", "é1", "é2"},
+		},
+		{
+			name:     "no comma is literal",
+			command:  "echo {a} {}",
+			wantArgv: []string{"echo", "{a}", "{}"},
+		},
+		{
+			name:     "expansion can produce the executable",
+			command:  "{ls,-l}",
+			wantArgv: []string{"ls", "-l"},
+		},
+		{
+			name:          "expansion inside an alternative is still rejected",
+			command:       "echo {a,$(id)}",
+			reasonContain: "constant literals",
+		},
+		{
+			name:          "huge sequence is refused without being built",
+			command:       "echo {1..2000000000}",
+			reasonContain: "more than 1024 arguments",
+		},
+		{
+			name:          "product over the cap",
+			command:       "echo {a,b}{a,b}{a,b}",
+			maxArgv:       8,
+			reasonContain: "more than 8 arguments",
+		},
+		{
+			name:     "product at the cap",
+			command:  "echo {a,b}{a,b}{c,d,e}",
+			maxArgv:  13,
+			wantArgv: []string{"echo", "aac", "aad", "aae", "abc", "abd", "abe", "bac", "bad", "bae", "bbc", "bbd", "bbe"},
+		},
+		{
+			name:          "cap counts plain arguments too",
+			command:       "echo a b c",
+			maxArgv:       3,
+			reasonContain: "more than 3 arguments",
+		},
+		{
+			name:          "brace in a redirect target",
+			command:       "echo x > {a,b}",
+			reasonContain: "constant literal path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unfurler := newCommandUnfurler(SecurityConfig{MaxArgvLength: tt.maxArgv, WorkingDirectory: t.TempDir()})
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			assert.Equal(t, tt.wantArgv, res.Plan.commands()[0].Argv)
+		})
+	}
+
+	t.Run("braces then globs", func(t *testing.T) {
+		workDir := t.TempDir()
+		for _, f := range []string{"a.go", "b.go", "c.md"} {
+			require.NoError(t, os.WriteFile(filepath.Join(workDir, f), nil, 0o644))
+		}
+		res := newCommandUnfurler(SecurityConfig{WorkingDirectory: workDir}).unfurl("ls *.{go,md}")
+		require.True(t, res.Allowed, res.Reason)
+		assert.Equal(t, []string{"ls", "a.go", "b.go", "c.md"}, res.Plan.commands()[0].Argv)
+	})
+}
+
+func TestCommandUnfurler_unfurl_variables(t *testing.T) {
+	workDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "a.go"), nil, 0o644))
+
+	cfg := SecurityConfig{
+		WorkingDirectory:    workDir,
+		ExpandableVariables: []string{"PWD", "WORKSPACE", "PROJECT", "PATTERN"},
+		Variables: map[string]string{
+			"PROJECT": "my project",
+			"PATTERN": "*.go",
+			"SECRET":  "not listed",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		command       string
+		wantArgv      []string
+		reasonContain string
+	}{
+		{
+			name:     "bare and braced",
+			command:  "echo $PWD ${WORKSPACE}",
+			wantArgv: []string{"echo", workDir, workDir},
+		},
+		{
+			name:     "value is one field even unquoted",
+			command:  "echo $PROJECT",
+			wantArgv: []string{"echo", "my project"},
+		},
+		{
+			name:     "value is never glob-expanded",
+			command:  "echo $PATTERN",
+			wantArgv: []string{"echo", "*.go"},
+		},
+		{
+			name:     "inside double quotes and concatenated",
+			command:  `echo "name=$PROJECT" pre${PROJECT}post`,
+			wantArgv: []string{"echo", "name=my project", "premy projectpost"},
+		},
+		{
+			name:     "single quotes stay literal",
+			command:  `echo '$PROJECT'`,
+			wantArgv: []string{"echo", "$PROJECT"},
+		},
+		{
+			// The pattern is the quoted value then a live *: "\*.go*".
+			name:          "next to a live glob only the glob is live",
+			command:       "ls $PATTERN*",
+			reasonContain: "matches no files",
+		},
+		{
+			name:          "defined but not expandable",
+			command:       "echo $SECRET",
+			reasonContain: "variable $SECRET is not expandable",
+		},
+		{
+			name:          "process environment is not consulted",
+			command:       "echo $PATH",
+			reasonContain: "variable $PATH is not expandable",
+		},
+		{
+			name:          "default operator",
+			command:       "echo ${PROJECT:-x}",
+			reasonContain: "only plain $VAR",
+		},
+		{
+			name:          "length",
+			command:       "echo ${#PROJECT}",
+			reasonContain: "only plain $VAR",
+		},
+		{
+			name:          "indirection",
+			command:       "echo ${!PROJECT}",
+			reasonContain: "only plain $VAR",
+		},
+		{
+			name:          "replacement",
+			command:       "echo ${PROJECT/my/your}",
+			reasonContain: "only plain $VAR",
+		},
+		{
+			name:          "subscript",
+			command:       "echo ${PROJECT[0]}",
+			reasonContain: "only plain $VAR",
+		},
+		{
+			name:          "special parameter",
+			command:       "echo $1 $@",
+			reasonContain: "not expandable",
+		},
+		{
+			name:          "command substitution is still rejected",
+			command:       `echo "$(id)"`,
+			reasonContain: "constant literals",
+		},
+	}
+
+	unfurler := newCommandUnfurler(cfg)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			assert.Equal(t, tt.wantArgv, res.Plan.commands()[0].Argv)
+		})
+	}
+
+	t.Run("variable in a redirect target", func(t *testing.T) {
+		res := unfurler.unfurl("echo x > $WORKSPACE/out.txt")
+		require.True(t, res.Allowed, res.Reason)
+		require.Len(t, res.Plan.commands()[0].Redirs, 1)
+		assert.Equal(t, "out.txt", filepath.Base(res.Plan.commands()[0].Redirs[0].Path))
+	})
+
+	t.Run("listed built-in without a value", func(t *testing.T) {
+		res := newCommandUnfurler(SecurityConfig{ExpandableVariables: []string{"PWD"}}).unfurl("echo $PWD")
+		require.False(t, res.Allowed)
+		assert.Contains(t, res.Reason, "variable $PWD is not expandable")
+	})
+}
+
+func TestCommandUnfurler_unfurl_assignments(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		wantEnv       []string
+		wantArgv      []string
+		reasonContain string
+	}{
+		{
+			name:     "single assignment",
+			command:  "LC_ALL=C sort file",
+			wantEnv:  []string{"LC_ALL=C"},
+			wantArgv: []string{"sort", "file"},
+		},
+		{
+			name:     "several assignments keep their order",
+			command:  "A=1 B='x y' C= env",
+			wantEnv:  []string{"A=1", "B=x y", "C="},
+			wantArgv: []string{"env"},
+		},
+		{
+			name:     "value with = and no glob or brace expansion",
+			command:  "GOFLAGS=-mod=mod X=*.{a,b} go list",
+			wantEnv:  []string{"GOFLAGS=-mod=mod", "X=*.{a,b}"},
+			wantArgv: []string{"go", "list"},
+		},
+		{
+			name:     "expandable variable in the value",
+			command:  "GOPATH=$WORKSPACE/go go env",
+			wantEnv:  []string{"GOPATH=/work/go"},
+			wantArgv: []string{"go", "env"},
+		},
+		{
+			name:          "command substitution in the value",
+			command:       "A=$(id) ls",
+			reasonContain: "inline assignment of A",
+		},
+		{
+			name:          "append",
+			command:       "A+=x ls",
+			reasonContain: "only NAME=value",
+		},
+		{
+			name:          "array",
+			command:       "A=(x y) ls",
+			reasonContain: "cannot be arrays",
+		},
+		{
+			name:          "assignment without a command",
+			command:       "A=1",
+			reasonContain: "empty command",
+		},
+	}
+
+	unfurler := newCommandUnfurler(SecurityConfig{
+		ExpandableVariables: []string{"WORKSPACE"},
+		Variables:           map[string]string{"WORKSPACE": "/work"},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			cmd := res.Plan.commands()[0]
+			assert.Equal(t, tt.wantEnv, cmd.Env)
+			assert.Equal(t, tt.wantArgv, cmd.Argv)
+		})
+	}
+}
+
+func TestCommandUnfurler_unfurl_heredocs(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		maxStdin      int
+		wantData      string
+		reasonContain string
+	}{
+		{
+			name:     "quoted delimiter is verbatim",
+			command:  "sort <<'EOF'\nb $X `id`\n\\$a\nEOF",
+			wantData: "b $X `id`\n\\$a\n",
+		},
+		{
+			name:     "double-quoted delimiter is verbatim",
+			command:  "cat <<\"END\"\n$(id)\nEND",
+			wantData: "$(id)\n",
+		},
+		{
+			name:     "escaped delimiter is verbatim",
+			command:  "cat <<\\EOF\n$HOME\nEOF",
+			wantData: "$HOME\n",
+		},
+		{
+			name:     "bare delimiter without expansions",
+			command:  "wc -l <<EOF\none\ntwo\nEOF",
+			wantData: "one\ntwo\n",
+		},
+		{
+			name:     "bare delimiter unescapes dollar, backtick and backslash only",
+			command:  "cat <<EOF\n\\$HOME \\`x\\` \\\\ \\\"\nEOF",
+			wantData: "$HOME `x` \\ \\\"\n",
+		},
+		{
+			name:     "line continuation in a bare body",
+			command:  "cat <<EOF\na\\\nb\nEOF",
+			wantData: "ab\n",
+		},
+		{
+			name:     "dash strips leading tabs",
+			command:  "cat <<-EOF\n\t\tindented\n\tkeep  spaces\n\tEOF",
+			wantData: "indented\nkeep  spaces\n",
+		},
+		{
+			name:     "empty body",
+			command:  "cat <<EOF\nEOF",
+			wantData: "",
+		},
+		{
+			name:     "here-string gets a trailing newline",
+			command:  "grep b <<< 'a b c'",
+			wantData: "a b c\n",
+		},
+		{
+			name:     "here-string is not glob-expanded",
+			command:  "cat <<< *",
+			wantData: "*\n",
+		},
+		{
+			name:     "here-string with an expandable variable",
+			command:  "cat <<< \"in $WORKSPACE\"",
+			wantData: "in /work\n",
+		},
+		{
+			name:          "parameter expansion in a bare body",
+			command:       "cat <<EOF\nhello $USER\nEOF",
+			reasonContain: "here-document bodies must be literal",
+		},
+		{
+			name:          "command substitution in a bare body",
+			command:       "cat <<EOF\n$(id)\nEOF",
+			reasonContain: "here-document bodies must be literal",
+		},
+		{
+			name:          "expandable variable in a bare body is still rejected",
+			command:       "cat <<EOF\n$WORKSPACE\nEOF",
+			reasonContain: "here-document bodies must be literal",
+		},
+		{
+			name:          "dynamic here-string",
+			command:       "cat <<< $(id)",
+			reasonContain: "here-string",
+		},
+		{
+			name:          "over the size limit",
+			command:       "cat <<< 12345678",
+			maxStdin:      8,
+			reasonContain: "exceeds 8 bytes",
+		},
+		{
+			name:     "at the size limit",
+			command:  "cat <<< 1234567",
+			maxStdin: 8,
+			wantData: "1234567\n",
+		},
+		{
+			name:          "here-document on another descriptor",
+			command:       "cat 3<<EOF\nx\nEOF",
+			reasonContain: "not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unfurler := newCommandUnfurler(SecurityConfig{
+				MaxStdinSize:        tt.maxStdin,
+				ExpandableVariables: []string{"WORKSPACE"},
+				Variables:           map[string]string{"WORKSPACE": "/work"},
+			})
+			res := unfurler.unfurl(tt.command)
+
+			if tt.reasonContain != "" {
+				require.False(t, res.Allowed)
+				assert.Contains(t, res.Reason, tt.reasonContain)
+				return
+			}
+			require.True(t, res.Allowed, "expected allowed, got reason: %s", res.Reason)
+			redirs := res.Plan.commands()[0].Redirs
+			require.Len(t, redirs, 1)
+			assert.Equal(t, redirData, redirs[0].Op)
+			assert.Equal(t, 0, redirs[0].Fd)
+			assert.Equal(t, tt.wantData, string(redirs[0].Data))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"regexp"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// builtinVariables are the expandable variables mcp-shell can supply a value
+// for itself. Any other name in expandable_variables must be defined under
+// variables.
+var builtinVariables = []string{"HOME", "PWD", "WORKSPACE"}
+
+var variableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expansionVariables builds the server-controlled values the unfurler
+// substitutes for $VAR, restricted to the names in cfg.ExpandableVariables.
+// Values never come from the server's own environment: PWD and WORKSPACE are
+// the working directory, HOME is the home directory of run_as_user (or of the
+// server's user), and everything else is cfg.Variables, which also overrides
+// the built-ins. A listed name with no value is left out, so using it is
+// rejected like any unlisted variable.
+func expansionVariables(cfg SecurityConfig) map[string]string {
+	if len(cfg.ExpandableVariables) == 0 {
+		return nil
+	}
+
+	known := make(map[string]string, len(builtinVariables)+len(cfg.Variables))
+	if cfg.WorkingDirectory != "" {
+		known["PWD"] = cfg.WorkingDirectory
+		known["WORKSPACE"] = cfg.WorkingDirectory
+	}
+	if home := homeDir(cfg.RunAsUser); home != "" {
+		known["HOME"] = home
+	}
+	for name, value := range cfg.Variables {
+		known[name] = value
+	}
+
+	vars := make(map[string]string, len(cfg.ExpandableVariables))
+	for _, name := range cfg.ExpandableVariables {
+		if value, ok := known[name]; ok {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// homeDir returns the home directory of the named user, or of the current user
+// when name is empty, and "" if it cannot be determined.
+func homeDir(name string) string {
+	var u *user.User
+	var err error
+	if name != "" {
+		u, err = user.Lookup(name)
+	} else {
+		u, err = user.Current()
+	}
+	if err != nil {
+		return ""
+	}
+	return u.HomeDir
+}
+
+// expandParam resolves a parameter expansion to its server-defined value. Only
+// the plain forms $VAR and ${VAR} of an expandable variable are accepted:
+// every operator (${VAR:-x}, ${VAR#x}, ${#VAR}, ${VAR/x/y}, ...), indirection
+// (${!VAR}), subscripts and special parameters ($1, $@, $?) are rejected.
+func (u *commandUnfurler) expandParam(p *syntax.ParamExp) (string, error) {
+	if p.Param == nil || p.Excl || p.Length || p.Width || p.IsSet ||
+		p.Flags != nil || p.NestedParam != nil || p.Index != nil || len(p.Modifiers) > 0 ||
+		p.Slice != nil || p.Repl != nil || p.Names != 0 || p.Exp != nil {
+		return "", errors.New("only plain $VAR or ${VAR} expansion of an expandable variable is allowed")
+	}
+	value, ok := u.vars[p.Param.Value]
+	if !ok {
+		return "", fmt.Errorf("variable $%s is not expandable", p.Param.Value)
+	}
+	return value, nil
+}
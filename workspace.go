@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// resolveInRoot resolves p, relative to root unless absolute, through every
+// symlink and returns the real path only if it stays inside root's real path.
+// Trailing components that do not exist yet (an output file about to be
+// created) are appended lexically to the deepest existing ancestor, so a
+// target need not exist to be judged. A dangling symlink is rejected outright:
+// creating through it would land wherever it points.
+func resolveInRoot(root, p string) (string, error) {
+	if root == "" {
+		return "", errors.New("no working directory is configured")
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("resolve working directory %q: %w", root, err)
+	}
+	realRoot, err := resolveExisting(absRoot)
+	if err != nil {
+		return "", fmt.Errorf("resolve working directory %q: %w", root, err)
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(absRoot, p)
+	}
+	real, err := resolveExisting(p)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", p, err)
+	}
+	if !pathWithin(realRoot, real) {
+		return "", fmt.Errorf("%q resolves outside the working directory", p)
+	}
+	return real, nil
+}
+
+// resolveWorkDir resolves a per-call working directory, relative to root
+// unless absolute, to the real path of an existing directory inside root.
+func resolveWorkDir(root, dir string) (string, error) {
+	if root == "" {
+		return "", errors.New("a per-call working directory requires a configured working_directory")
+	}
+	real, err := resolveInRoot(root, dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%q is not a directory", dir)
+	}
+	return real, nil
+}
+
+// resolveExisting evaluates symlinks on the longest existing prefix of the
+// absolute path p and appends the non-existent remainder unchanged.
+func resolveExisting(p string) (string, error) {
+	p = filepath.Clean(p)
+	var rest []string
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(p); lerr == nil {
+			return "", fmt.Errorf("%q is a dangling symlink", p)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(append([]string{p}, rest...)...), nil
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// pathWithin reports whether p is root or lies beneath it. Both must be clean
+// absolute paths.
+func pathWithin(root, p string) bool {
+	if root == string(filepath.Separator) {
+		return true
+	}
+	return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+}
+
+// checkRegularIfExists rejects an existing path that is not a regular file:
+// devices, FIFOs, sockets and directories are never valid redirect targets.
+func checkRegularIfExists(p string) error {
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", p)
+	}
+	return nil
+}
+
+// openInRoot re-resolves p inside root at the moment of use and opens it. The
+// final component is never followed (O_NOFOLLOW), and the open is non-blocking
+// so a FIFO swapped in since validation cannot hang the server; the opened
+// file must then be a regular file, checked on the descriptor itself.
+func openInRoot(root, p string, flag int) (*os.File, error) {
+	real, err := resolveInRoot(root, p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(real, flag|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%q is not a regular file", real)
+	}
+	if err := syscall.SetNonblock(int(f.Fd()), false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0o755))
+	require.NoError(t, os.Symlink("dir", filepath.Join(root, "inner-link")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "outer-link")))
+
+	tests := []struct {
+		name          string
+		path          string
+		want          string
+		errorContains string
+	}{
+		{name: "relative existing", path: "dir", want: filepath.Join(realRoot, "dir")},
+		{name: "relative not yet existing", path: "dir/new/file.txt", want: filepath.Join(realRoot, "dir", "new", "file.txt")},
+		{name: "symlink inside root", path: "inner-link/f", want: filepath.Join(realRoot, "dir", "f")},
+		{name: "root itself", path: ".", want: realRoot},
+		{name: "absolute inside root", path: filepath.Join(root, "dir"), want: filepath.Join(realRoot, "dir")},
+		{name: "symlink out of root", path: "outer-link/f", errorContains: "outside the working directory"},
+		{name: "dot-dot out of root", path: "dir/../../x", errorContains: "outside the working directory"},
+		{name: "absolute outside root", path: "/etc/passwd", errorContains: "outside the working directory"},
+		{name: "sibling with root as prefix", path: realRoot + "-sibling/x", errorContains: "outside the working directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveInRoot(root, tt.path)
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("empty root is rejected", func(t *testing.T) {
+		_, err := resolveInRoot("", "x")
+		require.Error(t, err)
+	})
+}
+
+func TestResolveWorkDir(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "src", "app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file.txt"), nil, 0o644))
+	require.NoError(t, os.Symlink("src/app", filepath.Join(root, "app-link")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "outer-link")))
+
+	tests := []struct {
+		name          string
+		root          string
+		dir           string
+		want          string
+		errorContains string
+	}{
+		{name: "relative directory", root: root, dir: "src/app", want: filepath.Join(realRoot, "src", "app")},
+		{name: "root itself", root: root, dir: ".", want: realRoot},
+		{name: "absolute inside root", root: root, dir: filepath.Join(root, "src"), want: filepath.Join(realRoot, "src")},
+		{name: "symlink resolved", root: root, dir: "app-link", want: filepath.Join(realRoot, "src", "app")},
+		{name: "symlink out of root", root: root, dir: "outer-link", errorContains: "outside the working directory"},
+		{name: "dot-dot out of root", root: root, dir: "src/../..", errorContains: "outside the working directory"},
+		{name: "absolute outside root", root: root, dir: "/etc", errorContains: "outside the working directory"},
+		{name: "missing directory", root: root, dir: "nope", errorContains: "no such file"},
+		{name: "not a directory", root: root, dir: "file.txt", errorContains: "not a directory"},
+		{name: "no working directory", dir: "src", errorContains: "requires a configured working_directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveWorkDir(tt.root, tt.dir)
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOpenInRoot(t *testing.T) {
+	root := t.TempDir()
+
+	t.Run("creates a regular file", func(t *testing.T) {
+		f, err := openInRoot(root, "new.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		assert.FileExists(t, filepath.Join(root, "new.txt"))
+	})
+
+	t.Run("refuses a fifo without blocking", func(t *testing.T) {
+		fifo := filepath.Join(root, "fifo")
+		require.NoError(t, syscall.Mkfifo(fifo, 0o644))
+
+		_, err := openInRoot(root, "fifo", os.O_RDONLY)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not a regular file")
+	})
+
+}